@@ -19,6 +19,7 @@ var (
 	MissingRepoError  = ErrorMessage{Error: "Repo parameter missing", Status: 400}
 	MissingTokenError = ErrorMessage{Error: "Token header missing", Status: 400}
 	InternalError     = ErrorMessage{Error: "Sorry, internal server error", Status: 500}
+	UnavailableError  = ErrorMessage{Error: "Sorry, the job couldn't be queued, please retry later", Status: 503}
 	NotFoundError     = ErrorMessage{Error: "Repository not on Github", Status: 404}
 )
 
@@ -41,7 +42,7 @@ func (conf Conf) ApiHandler(w http.ResponseWriter, r *http.Request) {
 	token := tokenHeader[1]
 
 	// Get the data with the token from db.
-	repoInfo, _, err := conf.Database.GetRepo(repo)
+	repoInfo, id, err := conf.Database.GetRepo(repo)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(InternalError)
@@ -50,9 +51,10 @@ func (conf Conf) ApiHandler(w http.ResponseWriter, r *http.Request) {
 
 	// if exist
 	if repoInfo.Exist() {
-		if err := conf.TriggerUpdateJob(repoInfo, token); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(InternalError)
+		// The job wasn't taken by the message queue, the graph would never appear
+		if err := conf.TriggerUpdateJob(repoInfo, id, token); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(UnavailableError)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -61,7 +63,12 @@ func (conf Conf) ApiHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// if doesn't exist in db, check on github
-	repoInfo, err = github.GetRepoInfo(repo, token)
+	client := conf.Github
+	if client == nil {
+		client = github.NewClient("")
+	}
+	// The request to Github is cancelled if the client goes away
+	repoInfo, err = client.WithToken(token).GetRepoInfoContext(r.Context(), repo)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(InternalError)
@@ -69,12 +76,13 @@ func (conf Conf) ApiHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	if repoInfo.Exist() {
 		if err := conf.TriggerAddJob(repoInfo, token); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(InternalError)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(UnavailableError)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(repoInfo)
+		return
 	}
 
 	// if doesn't exist on github 404
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/mq"
 	"github.com/evermax/stargraph/lib/store"
 )
 
@@ -36,7 +37,7 @@ func TestApiHandlerNoToken(t *testing.T) {
 
 func TestApiHandlerErrorOnDB(t *testing.T) {
 	conf := Conf{
-		Database: storedb{getRepoFail: true, exist: true},
+		Database: failingdb{store.NewMemory()},
 	}
 	server := httptest.NewServer(http.HandlerFunc(conf.ApiHandler))
 	req, err := http.NewRequest("GET", server.URL+"?repo=evermax/stargraph", nil)
@@ -54,11 +55,11 @@ func TestApiHandlerErrorOnDB(t *testing.T) {
 }
 
 func TestApiHandlerExistTriggerUpdate(t *testing.T) {
-	q := &msgq{}
+	q := mq.NewMemory()
 	q.DeclareQueue("update")
 	conf := Conf{
 		UpdateQueue:  "update",
-		Database:     storedb{exist: true},
+		Database:     memoryWith("evermax/stargraph"),
 		MessageQueue: q,
 	}
 	server := httptest.NewServer(http.HandlerFunc(conf.ApiHandler))
@@ -75,17 +76,22 @@ func TestApiHandlerExistTriggerUpdate(t *testing.T) {
 		t.Fatalf("Unexpected status %d, expected %d\n", resp.StatusCode, http.StatusOK)
 	}
 
-	if q.updateJobTriggered != 1 {
-		t.Fatalf("Should have triggered one job, instead triggered %d", q.updateJobTriggered)
+	if q.Len("update") != 1 {
+		t.Fatalf("Should have triggered one job, instead triggered %d", q.Len("update"))
+	}
+	// The job carries the id of the repository in the database
+	_, id, _ := conf.Database.GetRepo("evermax/stargraph")
+	if job := nextJob(t, q, "update"); job.ID != id.String() {
+		t.Fatalf("Expected the job to have the id %s, got %q", id, job.ID)
 	}
 }
 
 func TestApiHandlerErrorOnTriggering(t *testing.T) {
-	q := &msgq{}
+	q := mq.NewMemory()
 	q.DeclareQueue("update")
 	conf := Conf{
 		UpdateQueue:  "updatez",
-		Database:     storedb{exist: true},
+		Database:     memoryWith("evermax/stargraph"),
 		MessageQueue: q,
 	}
 	server := httptest.NewServer(http.HandlerFunc(conf.ApiHandler))
@@ -98,17 +104,72 @@ func TestApiHandlerErrorOnTriggering(t *testing.T) {
 	if err != nil {
 		t.Fatalf("An error occured while doing the request: %v\n", err)
 	}
-	if resp.StatusCode != http.StatusInternalServerError {
-		t.Fatalf("Unexpected status %d, expected %d\n", resp.StatusCode, http.StatusInternalServerError)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected status %d, expected %d\n", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestApiHandlerAdd(t *testing.T) {
+	githubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 1, "name": "stargraph", "full_name": "evermax/stargraph", "stargazers_count": 2}`)
+	}))
+	defer githubServer.Close()
+	client := github.NewClient("")
+	client.SetBaseURL(githubServer.URL)
+
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{nil, http.StatusOK},
+		// The broker didn't take the job
+		{mq.ErrNacked, http.StatusServiceUnavailable},
+		{mq.ErrConfirmTimeout, http.StatusServiceUnavailable},
+	}
+	for i, test := range tests {
+		q := mq.NewMemory()
+		q.DeclareQueue("add")
+		conf := Conf{
+			Github:       client,
+			AddQueue:     "add",
+			Database:     store.NewMemory(),
+			MessageQueue: failingq{q, test.err},
+		}
+		server := httptest.NewServer(http.HandlerFunc(conf.ApiHandler))
+		req, err := http.NewRequest("GET", server.URL+"?repo=evermax/stargraph", nil)
+		req.Header.Add(AuthorizationHeader, "Bearer test")
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while making the request: %v\n", i, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		server.Close()
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while doing the request: %v\n", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.expected {
+			t.Fatalf("Test %d: unexpected status %d, expected %d\n", i, resp.StatusCode, test.expected)
+		}
+		if jobs := q.Len("add"); (test.err == nil) != (jobs == 1) {
+			t.Fatalf("Test %d: unexpected %d jobs queued", i, jobs)
+		}
 	}
 }
 
 func TestApiHandlerErrorOnGithub(t *testing.T) {
-	q := &msgq{}
+	githubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer githubServer.Close()
+	client := github.NewClient("")
+	client.SetBaseURL(githubServer.URL)
+
+	q := mq.NewMemory()
 	q.DeclareQueue("add")
 	conf := Conf{
+		Github:       client,
 		UpdateQueue:  "add",
-		Database:     storedb{},
+		Database:     store.NewMemory(),
 		MessageQueue: q,
 	}
 	server := httptest.NewServer(http.HandlerFunc(conf.ApiHandler))
@@ -126,46 +187,33 @@ func TestApiHandlerErrorOnGithub(t *testing.T) {
 	}
 }
 
-type storedb struct {
-	addRepoFail   bool
-	getRepoFail   bool
-	putRepoFail   bool
-	claimWorkFail bool
-	exist         bool
-}
-
-func (db storedb) AddRepo(repo github.RepoInfo) (store.ID, error) {
-	if db.addRepoFail {
-		return iD{}, fmt.Errorf("Random Error")
+// memoryWith returns a store holding the repositories.
+func memoryWith(names ...string) *store.Memory {
+	db := store.NewMemory()
+	for i, name := range names {
+		db.AddRepo(github.RepoInfo{ID: i + 1, Name: name})
 	}
-	return iD{}, nil
+	return db
 }
 
-func (db storedb) GetRepo(repo string) (github.RepoInfo, store.ID, error) {
-	if db.getRepoFail {
-		return github.RepoInfo{}, iD{}, fmt.Errorf("Random Error")
-	}
-	repoInfo := github.RepoInfo{}
-	(&repoInfo).SetExist(db.exist)
-	return repoInfo, iD{}, nil
+// failingdb is a store whose GetRepo always fails.
+type failingdb struct {
+	*store.Memory
 }
 
-func (db storedb) PutRepo(repo github.RepoInfo, id store.ID) error {
-	if db.putRepoFail {
-		return fmt.Errorf("Random Error")
-	}
-	return nil
+func (db failingdb) GetRepo(repo string) (github.RepoInfo, store.ID, error) {
+	return github.RepoInfo{}, nil, fmt.Errorf("Random Error")
 }
 
-func (db storedb) ClaimWork(repo github.RepoInfo, id store.ID) error {
-	if db.claimWorkFail {
-		return fmt.Errorf("Random Error")
-	}
-	return nil
+// failingq is a message queue whose Publish fails with err if not nil.
+type failingq struct {
+	*mq.Memory
+	err error
 }
 
-type iD struct{}
-
-func (id iD) Test() string {
-	return ""
+func (q failingq) Publish(name string, body []byte) error {
+	if q.err != nil {
+		return q.err
+	}
+	return q.Memory.Publish(name, body)
 }
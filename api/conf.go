@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"net/http"
 
 	"github.com/evermax/stargraph/github"
 	"github.com/evermax/stargraph/lib/mq"
@@ -10,7 +11,10 @@ import (
 
 // Conf holds a mq.MessageQueue, the name of the add repo queue
 // and the name of the update repo queue.
+// Github is the client used to check the repositories on Github,
+// if nil the public Github API is used.
 type Conf struct {
+	Github       *github.Client
 	Database     store.Store
 	MessageQueue mq.MessageQueue
 	AddQueue     string
@@ -29,6 +33,7 @@ func NewConf(db store.Store, messageQ mq.MessageQueue, addQueueN, updateQueueN s
 		return
 	}
 	conf = Conf{
+		Github:       github.NewClient(""),
 		Database:     db,
 		MessageQueue: messageQ,
 		AddQueue:     addQueueN,
@@ -37,11 +42,37 @@ func NewConf(db store.Store, messageQ mq.MessageQueue, addQueueN, updateQueueN s
 	return
 }
 
+const (
+	// APIRoute is the route of ApiHandler, adding or updating a repository.
+	APIRoute string = "/api"
+	// GraphRoute is the route of GraphHandler, the graph of a repository of the database.
+	GraphRoute string = "/graph"
+)
+
+// NewServeMux returns a mux serving the handlers of the API on their routes.
+func (conf Conf) NewServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIRoute, conf.ApiHandler)
+	mux.HandleFunc(GraphRoute, conf.GraphHandler)
+	return mux
+}
+
+// jobToken returns the token to put in the jobs.
+// When the services authenticate on their own, as a Github App for instance,
+// the token of the user isn't sent through the message queue.
+func (conf Conf) jobToken(token string) string {
+	if conf.Github != nil && conf.Github.Tokens != nil {
+		return ""
+	}
+	return token
+}
+
 // TriggerAddJob triggers a new add job to the queue in the conf
-// With the provided repoInfo and the token
+// With the provided repoInfo and the token.
+// It fails if the message queue didn't take the job, see mq.Options.Confirm.
 func (conf Conf) TriggerAddJob(repoInfo github.RepoInfo, token string) error {
 	// Create new Job from the repo info and the token
-	job := NewJob(repoInfo, token)
+	job := NewJob(repoInfo, conf.jobToken(token))
 	body, err := job.Marshal()
 	if err != nil {
 		return err
@@ -52,10 +83,13 @@ func (conf Conf) TriggerAddJob(repoInfo github.RepoInfo, token string) error {
 }
 
 // TriggerUpdateJob trigger a new update job to the queue in the conf
-// With the provided repoInfo and the token
-func (conf Conf) TriggerUpdateJob(repoInfo github.RepoInfo, token string) error {
+// With the provided repoInfo, its id in the database and the token
+func (conf Conf) TriggerUpdateJob(repoInfo github.RepoInfo, id store.ID, token string) error {
 	// Create new Job from the repo info and the token
-	job := NewJob(repoInfo, token)
+	job := NewJob(repoInfo, conf.jobToken(token))
+	if id != nil {
+		job.ID = id.String()
+	}
 	body, err := job.Marshal()
 	if err != nil {
 		return err
@@ -65,9 +99,14 @@ func (conf Conf) TriggerUpdateJob(repoInfo github.RepoInfo, token string) error
 	return conf.MessageQueue.Publish(conf.UpdateQueue, body)
 }
 
+// Job is the message sent to the services.
+// ID is the id of the repository in the database, encoded with its String method,
+// empty if the repository isn't stored yet. The services decode it with the ParseID
+// method of their store.Store.
 type Job struct {
 	RepoInfo github.RepoInfo
-	Token    string
+	ID       string `json:",omitempty"`
+	Token    string `json:",omitempty"`
 }
 
 func NewJob(repoInfo github.RepoInfo, token string) Job {
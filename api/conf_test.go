@@ -1,22 +1,21 @@
 package api
 
 import (
-	"fmt"
 	"math/rand"
 	"testing"
 
 	"github.com/evermax/stargraph/github"
 	"github.com/evermax/stargraph/lib/mq"
+	"github.com/evermax/stargraph/lib/store"
 )
 
 func TestNewConf(t *testing.T) {
-	q := &msgq{}
-	NewConf(storedb{}, q, "add", "update")
-	if q.addQueue != "add" {
-		t.Fatalf("addQueue should be \"add\", instead is %s", q.addQueue)
-	}
-	if q.updateQueue != "update" {
-		t.Fatalf("updateQueue should be \"update\", instead is %s", q.updateQueue)
+	q := mq.NewMemory()
+	NewConf(store.NewMemory(), q, "add", "update")
+	for _, name := range []string{"add", "update"} {
+		if err := q.Publish(name, nil); err != nil {
+			t.Fatalf("The queue %s should have been declared: %v", name, err)
+		}
 	}
 }
 
@@ -24,7 +23,7 @@ func TestTriggerAddJob(t *testing.T) {
 	var aqn = "add"
 	var uqn = "update"
 
-	q := &msgq{}
+	q := mq.NewMemory()
 	var conf = Conf{
 		MessageQueue: q,
 		AddQueue:     aqn,
@@ -41,8 +40,8 @@ func TestTriggerAddJob(t *testing.T) {
 		conf.TriggerAddJob(github.RepoInfo{}, "test")
 	}
 
-	if q.addJobTriggered != count {
-		t.Fatalf("The number of triggered job should be: %d, but is %d", count, q.addJobTriggered)
+	if q.Len(aqn) != count {
+		t.Fatalf("The number of triggered job should be: %d, but is %d", count, q.Len(aqn))
 	}
 }
 
@@ -50,7 +49,7 @@ func TestTriggerUpdateJob(t *testing.T) {
 	var aqn = "add"
 	var uqn = "update"
 
-	q := &msgq{}
+	q := mq.NewMemory()
 	var conf = Conf{
 		MessageQueue: q,
 		AddQueue:     aqn,
@@ -64,49 +63,48 @@ func TestTriggerUpdateJob(t *testing.T) {
 
 	var count = rand.Intn(10)
 	for i := 0; i < count; i++ {
-		conf.TriggerUpdateJob(github.RepoInfo{}, "test")
+		conf.TriggerUpdateJob(github.RepoInfo{}, nil, "test")
 	}
 
-	if q.updateJobTriggered != count {
-		t.Fatalf("The number of triggered job should be: %d, but is %d", count, q.updateJobTriggered)
+	if q.Len(uqn) != count {
+		t.Fatalf("The number of triggered job should be: %d, but is %d", count, q.Len(uqn))
 	}
 }
 
-type msgq struct {
-	addQueue           string
-	updateQueue        string
-	addJobTriggered    int
-	updateJobTriggered int
-	token              string
-}
-
-func (q *msgq) DeclareQueue(name string) error {
-	if name == "" {
-		return fmt.Errorf("Name is null")
+func TestTriggerJobToken(t *testing.T) {
+	q := mq.NewMemory()
+	conf, err := NewConf(store.NewMemory(), q, "add", "update")
+	if err != nil {
+		t.Fatalf("An error occured while creating the conf: %v", err)
 	}
-	if name == "add" {
-		q.addQueue = name
+
+	if err := conf.TriggerAddJob(github.RepoInfo{}, "test"); err != nil {
+		t.Fatalf("An error occured while triggering the job: %v", err)
 	}
-	if name == "update" {
-		q.updateQueue = name
+	if job := nextJob(t, q, "add"); job.Token != "test" {
+		t.Fatalf("The token of the user should be in the job, got %q", job.Token)
 	}
-	return nil
-}
 
-func (q *msgq) Publish(name string, body []byte) error {
-	if q.addQueue != name && q.updateQueue != name {
-		// TODO check if indeed an non existing queue would indeed trigger error
-		return fmt.Errorf("No such Q %s", name)
-	}
-	if name == "add" {
-		q.addJobTriggered++
+	// The services authenticate on their own, the token shouldn't go through the queue
+	conf.Github.Tokens = github.NewTokenPool("service")
+	if err := conf.TriggerUpdateJob(github.RepoInfo{}, nil, "test"); err != nil {
+		t.Fatalf("An error occured while triggering the job: %v", err)
 	}
-	if name == "update" {
-		q.updateJobTriggered++
+	if job := nextJob(t, q, "update"); job.Token != "" {
+		t.Fatalf("The token of the user shouldn't be in the job, got %q", job.Token)
 	}
-	return nil
 }
 
-func (q *msgq) Consume(name string, r mq.Receiver) error {
-	return nil
+// nextJob gets and acknowledges the next job of the queue.
+func nextJob(t *testing.T, q *mq.Memory, name string) Job {
+	d, ok, err := q.Get(name)
+	if err != nil || !ok {
+		t.Fatalf("Expected a job on the queue %s: %v", name, err)
+	}
+	d.Ack(false)
+	job, err := Unmarshal(d.Body())
+	if err != nil {
+		t.Fatalf("An error occured while decoding the job: %v", err)
+	}
+	return job
 }
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/evermax/stargraph/lib"
+	"github.com/evermax/stargraph/lib/store"
+)
+
+const (
+	FormatParameter  string = "format"
+	PNGContentHeader string = "image/png"
+)
+
+var (
+	NotGraphedError    = ErrorMessage{Error: "Repository not graphed yet", Status: 404}
+	UnknownFormatError = ErrorMessage{Error: "Unknown format, expected canvasjs, jqplot or png", Status: 400}
+)
+
+// GraphHandler writes the graph of a repository of the database: the cumulative stars
+// along with the net stars, that go down when stargazers unstarred the repository,
+// computed from the snapshots the services took.
+// The format parameter chooses between the data for CanvasJS (the default), jqplot, or a png image.
+func (conf Conf) GraphHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add(ContentTypeHeader, JSONContentHeader)
+
+	repo := r.FormValue(RepoParameter)
+	if repo == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(MissingRepoError)
+		return
+	}
+	format := r.FormValue(FormatParameter)
+	if format != "" && format != "canvasjs" && format != "jqplot" && format != "png" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UnknownFormatError)
+		return
+	}
+
+	repoInfo, id, err := conf.Database.GetRepo(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(InternalError)
+		return
+	}
+	if !repoInfo.Exist() || repoInfo.LastUpdate == "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(NotGraphedError)
+		return
+	}
+	timestamps, err := store.Timestamps(conf.Database, id, store.AllFrom, store.AllTo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(InternalError)
+		return
+	}
+	net, err := store.NetStars(conf.Database, id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(InternalError)
+		return
+	}
+
+	// The graph is rendered before answering, so a failure is still reported
+	var graph bytes.Buffer
+	contentType := JSONContentHeader
+	switch format {
+	case "jqplot":
+		err = lib.WriteJQPlotNet(timestamps, net, &graph)
+	case "png":
+		contentType = PNGContentHeader
+		err = lib.PlotGraphNet("Graph of "+repo, timestamps, net, &graph)
+	default:
+		err = lib.WriteCanvasJSNet(timestamps, net, repoInfo, &graph)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(InternalError)
+		return
+	}
+	w.Header().Set(ContentTypeHeader, contentType)
+	w.WriteHeader(http.StatusOK)
+	graph.WriteTo(w)
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib"
+	"github.com/evermax/stargraph/lib/store"
+)
+
+func TestGraphHandler(t *testing.T) {
+	db := store.NewMemory()
+	id, err := db.AddRepo(github.RepoInfo{ID: 1, Name: "evermax/stargraph", CreationDate: "2015-10-01T00:00:00Z", LastUpdate: "2015-11-02T00:00:00Z"})
+	if err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+	db.AddRepo(github.RepoInfo{ID: 2, Name: "evermax/new"})
+	octocat := github.Stargazer{Timestamp: "2015-10-31T10:00:00Z", User: github.User{Login: "octocat", ID: 583231}}
+	other := github.Stargazer{Timestamp: "2015-10-31T11:00:00Z", User: github.User{Login: "other", ID: 42}}
+	if err := db.AppendTimestamps(id, []int64{1446285600, 1446289200}); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+	// The other stargazer unstarred the next day
+	first := time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC)
+	if err := store.TakeSnapshot(db, id, first, []github.Stargazer{octocat, other}); err != nil {
+		t.Fatalf("An error occured while taking the snapshot: %v", err)
+	}
+	if err := store.TakeSnapshot(db, id, first.Add(24*time.Hour), []github.Stargazer{octocat}); err != nil {
+		t.Fatalf("An error occured while taking the snapshot: %v", err)
+	}
+	conf := Conf{Database: db}
+	server := httptest.NewServer(conf.NewServeMux())
+	defer server.Close()
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"", http.StatusBadRequest},
+		{"?repo=evermax/stargraph&format=svg", http.StatusBadRequest},
+		{"?repo=evermax/nothing", http.StatusNotFound},
+		// Not crawled yet
+		{"?repo=evermax/new", http.StatusNotFound},
+		{"?repo=evermax/stargraph", http.StatusOK},
+		{"?repo=evermax/stargraph&format=jqplot", http.StatusOK},
+	}
+	for i, test := range tests {
+		resp, err := http.Get(server.URL + GraphRoute + test.query)
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while doing the request: %v", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.expected {
+			t.Fatalf("Test %d: unexpected status %d, expected %d", i, resp.StatusCode, test.expected)
+		}
+	}
+
+	resp, err := http.Get(server.URL + GraphRoute + "?repo=evermax/stargraph")
+	if err != nil {
+		t.Fatalf("An error occured while doing the request: %v", err)
+	}
+	defer resp.Body.Close()
+	var graph lib.CanvasJSON
+	if err := json.NewDecoder(resp.Body).Decode(&graph); err != nil {
+		t.Fatalf("An error occured while decoding the graph: %v", err)
+	}
+	if len(graph.Data) != 2 || graph.CreatedDate != "2015-10-01T00:00:00Z" {
+		t.Fatalf("Expected the 2 stars of the repository, got %+v", graph)
+	}
+	expected := []lib.CanvasData{{X: 1446285600000, Y: 1}, {X: 1446289200000, Y: 2}, {X: first.Add(24*time.Hour).Unix() * 1000, Y: 1}}
+	if len(graph.Net) != len(expected) {
+		t.Fatalf("Expected the net stars %v, got %v", expected, graph.Net)
+	}
+	for i := range expected {
+		if graph.Net[i] != expected[i] {
+			t.Fatalf("Expected the net stars %v, got %v", expected, graph.Net)
+		}
+	}
+}
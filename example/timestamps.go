@@ -1,66 +1,80 @@
 package example
 
 import (
+	"context"
 	"fmt"
 	"strconv"
-	"strings"
 
 	"github.com/evermax/stargraph/github"
 )
 
+// Fetcher is a github.StargazerFetcher using the REST API,
+// the pages are requested one after the other with GetStargazers.
+type Fetcher struct {
+	PerPage int
+}
+
+// FetchTimestamps gets the timestamps of the stars of the repository.
+func (f Fetcher) FetchTimestamps(ctx context.Context, client *github.Client, info github.RepoInfo) ([]int64, error) {
+	return GetTimestampsContext(ctx, client, f.PerPage, info.URL())
+}
+
+// FetchStargazers gets who starred the repository and when.
+func (f Fetcher) FetchStargazers(ctx context.Context, client *github.Client, info github.RepoInfo) ([]github.Stargazer, error) {
+	return GetStargazersContext(ctx, client, f.PerPage, info.URL())
+}
+
 // GetTimestamps gets the timestamps of the stars from the Github API
-// count is the number of pages to query
+// client is the Github client holding the token
+// perPage is the number of stars per page
 // url is the Github API url of the repository you want to crawl
-// token is the Github API token
-func GetTimestamps(perPage int, url, token string) (timestamps []int64, err error) {
-	if perPage > 0 {
-		url = url + "?per_page=" + strconv.Itoa(perPage)
+// Set a Cache on the client to only download the pages that changed since the last call.
+func GetTimestamps(client *github.Client, perPage int, url string) ([]int64, error) {
+	return GetTimestampsContext(context.Background(), client, perPage, url)
+}
+
+// GetTimestampsContext is GetTimestamps with a context, the crawling stops when it is done.
+func GetTimestampsContext(ctx context.Context, client *github.Client, perPage int, url string) ([]int64, error) {
+	stargazers, err := GetStargazersContext(ctx, client, perPage, url)
+	if err != nil {
+		return nil, err
 	}
-	linkFormat := github.BuildLinksFormat(url)
+	return github.Timestamps(stargazers)
+}
 
-	getParam := "?page="
-	if strings.Contains(url, "?") {
-		getParam = "&page="
+// GetStargazers gets who starred the repository and when from the Github API,
+// the parameters are the same as for GetTimestamps.
+func GetStargazers(client *github.Client, perPage int, url string) ([]github.Stargazer, error) {
+	return GetStargazersContext(context.Background(), client, perPage, url)
+}
+
+// GetStargazersContext is GetStargazers with a context, the crawling stops when it is done.
+func GetStargazersContext(ctx context.Context, client *github.Client, perPage int, url string) (stargazers []github.Stargazer, err error) {
+	pageURL := url
+	if perPage > 0 {
+		pageURL = url + "?per_page=" + strconv.Itoa(perPage)
 	}
-	var i = 1
-	var last = 2
-	var next int
 	for {
-		pageURL := url + getParam + strconv.Itoa(i)
-		var stargazers []github.Stargazer
+		var page []github.Stargazer
 		var linkHeader string
-		stargazers, linkHeader, err = github.GetStargazers(pageURL, token)
+		page, linkHeader, err = client.GetStargazersContext(ctx, pageURL)
 		if err != nil {
 			return
 		}
+		stargazers = append(stargazers, page...)
 
-		var timestamp int64
-		for _, star := range stargazers {
-			timestamp, err = star.GetTimestamp()
-			if err != nil {
-				return
-			}
-			timestamps = append(timestamps, timestamp)
-		}
-
-		// If the header is empty, it is the only page
-		if linkHeader == "" {
-			break
-		}
-		// This is a little check because the last call will return a Link header
-		// that doesn't have the same format
-		if i < last {
-			_, err = fmt.Sscanf(linkHeader, linkFormat, &next, &last)
-			if err != nil {
-				err = fmt.Errorf("An error occured while parsing the header: %v, parser is %s, link header is %s", err, linkFormat, linkHeader)
-				return
-			}
+		// Follow the next link given by Github, there is none on the last page
+		var links github.Links
+		links, err = github.ParseLinks(linkHeader)
+		if err != nil {
+			err = fmt.Errorf("An error occured while parsing the header: %v, link header is %s", err, linkHeader)
+			return
 		}
-
-		if i == last {
+		next, ok := links.Next()
+		if !ok || next.URL == pageURL {
 			break
 		}
-		i++
+		pageURL = next.URL
 	}
-	return timestamps, nil
+	return stargazers, nil
 }
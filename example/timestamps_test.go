@@ -1,11 +1,13 @@
 package example
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/evermax/stargraph/github"
@@ -20,17 +22,14 @@ func TestGetTimestampsSimple(t *testing.T) {
 		t.Fatalf("An error occured while reading the file %s: %v\n", filePath, err)
 	}
 
-	serverUrl := ""
 	handler := func(w http.ResponseWriter, r *http.Request) {
-		linkFormat := github.BuildLinksFormat(serverUrl)
-		w.Header().Add("Link", fmt.Sprintf(linkFormat, 1, 1))
+		// Github doesn't send a Link header when there is only one page
 		w.WriteHeader(http.StatusOK)
 		w.Write(body)
 	}
 	server := httptest.NewServer(http.HandlerFunc(handler))
-	serverUrl = server.URL + "?per_page=" + strconv.Itoa(batch)
 
-	timestamps, err := GetTimestamps(1, server.URL, "token")
+	timestamps, err := GetTimestamps(github.NewClient("token"), batch, server.URL)
 	if err != nil {
 		t.Fatalf("An error occured while requesting the timestamps: %v\n", err)
 	}
@@ -76,13 +75,22 @@ func TestGetTimestampsMulPages(t *testing.T) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		linkFormat := github.BuildLinksFormat(serverUrl)
-		w.Header().Add("Link", fmt.Sprintf(linkFormat, page, maxPage))
+		// The relations are not always in the same order and the
+		// last page has no next nor last, like on the Github API
+		pageURL := func(p int) string { return serverUrl + "&page=" + strconv.Itoa(p) }
+		var links []string
+		if page > 1 {
+			links = append(links, fmt.Sprintf("<%s>; rel=\"first\"", pageURL(1)), fmt.Sprintf("<%s>; rel=\"prev\"", pageURL(page-1)))
+		}
+		if page < maxPage {
+			links = append(links, fmt.Sprintf("<%s>; rel=\"last\"", pageURL(maxPage)), fmt.Sprintf("<%s>; rel=\"next\"", pageURL(page+1)))
+		}
+		w.Header().Add("Link", strings.Join(links, ", "))
 		w.Write(body)
 	}
 	server := httptest.NewServer(http.HandlerFunc(handler))
 	serverUrl = server.URL + "?per_page=" + strconv.Itoa(batch)
-	timestamps, err := GetTimestamps(batch, server.URL, "token")
+	timestamps, err := GetTimestamps(github.NewClient("token"), batch, server.URL)
 	if err != nil {
 		t.Fatalf("An error occured while requesting the timestamps: %v\n", err)
 	}
@@ -102,3 +110,66 @@ func TestGetTimestampsMulPages(t *testing.T) {
 			" don't have the same values\n", expectedTimestamps, timestamps)
 	}
 }
+
+func TestFetchStargazers(t *testing.T) {
+	filePathFormat := "testdata/mul_stars_%d.json"
+	maxPage := 3
+	var serverURL string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		if pageString := r.FormValue("page"); pageString != "" {
+			page, _ = strconv.Atoi(pageString)
+		}
+		filePath := fmt.Sprintf(filePathFormat, page)
+		body, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			t.Errorf("Reading error of %s: %v\n", filePath, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if page < maxPage {
+			w.Header().Add("Link", fmt.Sprintf("<%s&page=%d>; rel=\"next\"", serverURL, page+1))
+		}
+		w.Write(body)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	serverURL = server.URL + "?per_page=1"
+
+	var fetcher github.StargazerFetcher = Fetcher{PerPage: 1}
+	stargazers, err := fetcher.FetchStargazers(context.Background(), github.NewClient("token"), github.RepoInfo{StarsURL: server.URL})
+	if err != nil {
+		t.Fatalf("An error occured while requesting the stargazers: %v\n", err)
+	}
+	expectedLogins := []string{"octocat", "dependabot[bot]", "evermax"}
+	if len(stargazers) != len(expectedLogins) {
+		t.Fatalf("Expected %d stargazers, got %v", len(expectedLogins), stargazers)
+	}
+	for i, login := range expectedLogins {
+		if stargazers[i].User.Login != login {
+			t.Fatalf("Expected %s, got %s", login, stargazers[i].User.Login)
+		}
+		if stargazers[i].User.IsBot() != (i == 1) {
+			t.Fatalf("Wrong bot flag for %s", login)
+		}
+	}
+}
+
+func TestGetTimestampsCancelled(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("[]"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GetTimestampsContext(ctx, github.NewClient("token"), 1, server.URL); err == nil {
+		t.Fatal("Expected an error with a cancelled context")
+	}
+	if calls != 0 {
+		t.Fatalf("No request should have been made, got %d", calls)
+	}
+}
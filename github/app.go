@@ -0,0 +1,224 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// installationTokenPathFormat will help build the URL to create an installation access token.
+	installationTokenPathFormat = "app/installations/%d/access_tokens"
+	// jwtLifetime is the lifetime of the JWT authenticating the app, Github accepts at most 10 minutes.
+	jwtLifetime = 9 * time.Minute
+	// tokenRefreshMargin is how long before its expiry an installation token is refreshed.
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// AppTokenSource is a TokenSource authenticating as an installation of a Github App,
+// so no personal access token is needed.
+// It signs a JWT with the private key of the app, exchanges it for an installation
+// access token, and keeps the token until it is about to expire.
+// It is safe for concurrent use.
+type AppTokenSource struct {
+	AppID          int64
+	InstallationID int64
+	Key            *rsa.PrivateKey
+	// Client is used for the base URL of the API and the HTTP client
+	// when creating the installation tokens, its Tokens aren't used.
+	Client *Client
+
+	mtx     sync.Mutex
+	token   string
+	expires time.Time
+	limiter *RateLimiter
+	// creating is closed once the token being created is ready, nil if none is
+	creating chan struct{}
+	now      func() time.Time
+}
+
+// NewAppTokenSource creates an AppTokenSource for the installation of the app,
+// the installation tokens being requested to the API of the client.
+func NewAppTokenSource(client *Client, appID, installationID int64, key *rsa.PrivateKey) *AppTokenSource {
+	return &AppTokenSource{
+		AppID:          appID,
+		InstallationID: installationID,
+		Key:            key,
+		Client:         client,
+		limiter:        &RateLimiter{},
+	}
+}
+
+// LoadPrivateKey reads the PEM encoded private key of a Github App,
+// as downloaded from the settings of the app.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(data)
+}
+
+// ParsePrivateKey parses a PEM encoded RSA private key, in the PKCS #1 or PKCS #8 format.
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found in the private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("An error occured while parsing the private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("The private key should be a RSA key")
+	}
+	return key, nil
+}
+
+// JWT returns a JSON Web Token signed with RS256, authenticating as the app.
+func (s *AppTokenSource) JWT() (string, error) {
+	if s.Key == nil {
+		return "", fmt.Errorf("The private key of the app is missing")
+	}
+	now := s.clock()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		// Backdated to allow for clock drift with Github
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(jwtLifetime).Unix(),
+		"iss": strconv.FormatInt(s.AppID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("An error occured while signing the JWT: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Token returns the installation access token, creating a new one
+// if there is none yet or if it is about to expire.
+// Only one token is created at a time, the other callers wait for it
+// until their context is done.
+// The rate limit budget is tracked per installation.
+func (s *AppTokenSource) Token(ctx context.Context) (string, *RateLimiter, error) {
+	for {
+		s.mtx.Lock()
+		if s.limiter == nil {
+			s.limiter = &RateLimiter{}
+		}
+		limiter := s.limiter
+		if s.token != "" && s.clock().Add(tokenRefreshMargin).Before(s.expires) {
+			token := s.token
+			s.mtx.Unlock()
+			return token, limiter, nil
+		}
+		if creating := s.creating; creating != nil {
+			s.mtx.Unlock()
+			select {
+			case <-creating:
+				// Created, or failed and to be created again
+				continue
+			case <-ctx.Done():
+				return "", nil, ctx.Err()
+			}
+		}
+		creating := make(chan struct{})
+		s.creating = creating
+		s.mtx.Unlock()
+
+		// The lock isn't held during the request
+		token, expires, err := s.createToken(ctx)
+		s.mtx.Lock()
+		if err == nil {
+			s.token, s.expires = token, expires
+		}
+		s.creating = nil
+		close(creating)
+		s.mtx.Unlock()
+		if err != nil {
+			return "", nil, err
+		}
+		return token, limiter, nil
+	}
+}
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// createToken exchanges a JWT for a new installation access token.
+// The request is cancelled when the context is done.
+func (s *AppTokenSource) createToken(ctx context.Context) (string, time.Time, error) {
+	client := s.Client
+	if client == nil {
+		client = NewClient("")
+	}
+	jwt, err := s.JWT()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	url := client.URL(fmt.Sprintf(installationTokenPathFormat, s.InstallationID))
+	r, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Add("Authorization", "Bearer "+jwt)
+	r.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(r)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, &StatusError{URL: url, Status: resp.StatusCode}
+	}
+
+	var token installationToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", time.Time{}, fmt.Errorf("An error occured while decoding the installation token: %v", err)
+	}
+	if token.Token == "" {
+		return "", time.Time{}, fmt.Errorf("No installation token in the response of %s", url)
+	}
+	return token.Token, token.ExpiresAt, nil
+}
+
+func (s *AppTokenSource) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
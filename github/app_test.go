@@ -0,0 +1,263 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeApp is a fake Github API creating installation tokens for the app 42
+// and checking that the requests are authenticated with them.
+type fakeApp struct {
+	t       *testing.T
+	key     *rsa.PrivateKey
+	mtx     sync.Mutex
+	created int
+	expires time.Time
+	auths   []string
+}
+
+func (f *fakeApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if r.URL.Path != "/app/installations/7/access_tokens" {
+		f.auths = append(f.auths, r.Header.Get("Authorization"))
+		w.Write([]byte("[]"))
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+	if len(parts) != 3 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&f.key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims struct {
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+		Iss string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Iss != "42" || claims.Exp-claims.Iat > 600 {
+		f.t.Errorf("Unexpected JWT claims %s", payload)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	f.created++
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(installationToken{
+		Token:     fmt.Sprintf("ghs_%d", f.created),
+		ExpiresAt: f.expires,
+	})
+}
+
+func newFakeApp(t *testing.T) (*fakeApp, *httptest.Server, *Client) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("An error occured while generating the key: %v", err)
+	}
+	app := &fakeApp{t: t, key: key, expires: time.Now().Add(time.Hour)}
+	server := httptest.NewServer(app)
+	client := NewClient("")
+	if err := client.SetBaseURL(server.URL); err != nil {
+		t.Fatalf("An error occured while setting the base URL: %v", err)
+	}
+	return app, server, client
+}
+
+func TestAppTokenSource(t *testing.T) {
+	app, server, client := newFakeApp(t)
+	defer server.Close()
+
+	client.Tokens = NewAppTokenSource(client, 42, 7, app.key)
+	for i := 0; i < 3; i++ {
+		if _, _, err := client.GetStargazers(server.URL + "/repositories/1/stargazers"); err != nil {
+			t.Fatalf("Request %d: an error occured: %v", i, err)
+		}
+	}
+
+	app.mtx.Lock()
+	defer app.mtx.Unlock()
+	if app.created != 1 {
+		t.Fatalf("The installation token should have been created once, got %d", app.created)
+	}
+	for i, auth := range app.auths {
+		if auth != "token ghs_1" {
+			t.Fatalf("Request %d: expected the installation token, got %s", i, auth)
+		}
+	}
+}
+
+func TestAppTokenSourceRefresh(t *testing.T) {
+	app, server, client := newFakeApp(t)
+	defer server.Close()
+
+	now := time.Now()
+	source := NewAppTokenSource(client, 42, 7, app.key)
+	source.now = func() time.Time { return now }
+
+	tests := []struct {
+		elapsed  time.Duration
+		expected string
+	}{
+		{elapsed: 0, expected: "ghs_1"},
+		{elapsed: 30 * time.Minute, expected: "ghs_1"},
+		// Less than 5 minutes before the expiry, the token is refreshed
+		{elapsed: 56 * time.Minute, expected: "ghs_2"},
+	}
+	for i, test := range tests {
+		now = app.expires.Add(-time.Hour).Add(test.elapsed)
+		if test.expected == "ghs_2" {
+			app.mtx.Lock()
+			app.expires = app.expires.Add(time.Hour)
+			app.mtx.Unlock()
+		}
+		token, limiter, err := source.Token(context.Background())
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while getting the token: %v", i, err)
+		}
+		if token != test.expected {
+			t.Fatalf("Test %d: expected token %s, got %s", i, test.expected, token)
+		}
+		if limiter == nil {
+			t.Fatalf("Test %d: the token should come with a rate limiter", i)
+		}
+	}
+}
+
+func TestAppTokenSourceWrongKey(t *testing.T) {
+	_, server, client := newFakeApp(t)
+	defer server.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("An error occured while generating the key: %v", err)
+	}
+	// The JWT isn't signed with the key of the app
+	source := NewAppTokenSource(client, 42, 7, key)
+	_, _, err = source.Token(context.Background())
+	statusErr, ok := err.(*StatusError)
+	if !ok {
+		t.Fatalf("Expected a *StatusError, got %v", err)
+	}
+	if statusErr.Status != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, statusErr.Status)
+	}
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("An error occured while generating the key: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "stargraph-app")
+	if err != nil {
+		t.Fatalf("An error occured while creating the temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("An error occured while writing the key: %v", err)
+	}
+	loaded, err := LoadPrivateKey(path)
+	if err != nil {
+		t.Fatalf("An error occured while loading the key: %v", err)
+	}
+	if loaded.N.Cmp(key.N) != 0 {
+		t.Fatal("The loaded key is different from the written one")
+	}
+
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Fatal("Expected an error when parsing garbage")
+	}
+}
+
+func TestAppTokenSourceContext(t *testing.T) {
+	app, server, client := newFakeApp(t)
+	defer server.Close()
+
+	// The token exchange hangs until released
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		app.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	client.SetBaseURL(slow.URL)
+	source := NewAppTokenSource(client, 42, 7, app.key)
+
+	// The request is cancelled with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := source.Token(ctx); err == nil {
+		t.Fatal("The token exchange should be cancelled with the context")
+	}
+
+	// The callers waiting for the exchange in progress aren't blocked past their context
+	tokens := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			token, _, err := source.Token(context.Background())
+			if err != nil {
+				t.Errorf("An error occured while getting the token: %v", err)
+			}
+			tokens <- token
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := source.Token(ctx); err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Fatalf("Expected %v after the timeout, got %v after %v", context.DeadlineExceeded, err, time.Since(start))
+	}
+
+	// One token is created for the waiting callers
+	close(release)
+	for i := 0; i < 2; i++ {
+		if token := <-tokens; token != "ghs_1" {
+			t.Fatalf("Expected ghs_1, got %s", token)
+		}
+	}
+	app.mtx.Lock()
+	defer app.mtx.Unlock()
+	if app.created != 1 {
+		t.Fatalf("The installation token should have been created once, got %d", app.created)
+	}
+}
@@ -0,0 +1,147 @@
+package github
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CachedResponse is a response from the Github API kept to make conditional requests.
+// When Github answers 304 Not Modified, the Body and the Link header are reused
+// and the request doesn't count against the rate limit.
+type CachedResponse struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Link         string `json:"link,omitempty"`
+	Body         []byte `json:"body"`
+}
+
+// Cache interface allows to plug different storages for the responses, keyed by page URL.
+type Cache interface {
+	Get(url string) (CachedResponse, bool)
+	Set(url string, resp CachedResponse) error
+}
+
+// MemoryCache is a Cache keeping the responses in memory.
+// It is safe for concurrent use.
+type MemoryCache struct {
+	mtx       sync.RWMutex
+	responses map[string]CachedResponse
+}
+
+// NewMemoryCache creates an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		responses: make(map[string]CachedResponse),
+	}
+}
+
+// Get returns the response stored for the url.
+func (c *MemoryCache) Get(url string) (CachedResponse, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	resp, ok := c.responses[url]
+	return resp, ok
+}
+
+// Set stores the response for the url.
+func (c *MemoryCache) Set(url string, resp CachedResponse) error {
+	c.mtx.Lock()
+	c.responses[url] = resp
+	c.mtx.Unlock()
+	return nil
+}
+
+// DiskCache is a Cache keeping the responses as JSON files in a directory,
+// so they survive between two runs. The file name is the SHA1 of the url.
+type DiskCache struct {
+	Dir string
+}
+
+// NewDiskCache creates a DiskCache in the directory, creating it if needed.
+func NewDiskCache(dir string) (DiskCache, error) {
+	return DiskCache{Dir: dir}, os.MkdirAll(dir, 0755)
+}
+
+func (c DiskCache) path(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get returns the response stored for the url.
+// A file that can't be read or parsed is considered as a miss.
+func (c DiskCache) Get(url string) (CachedResponse, bool) {
+	var resp CachedResponse
+	b, err := ioutil.ReadFile(c.path(url))
+	if err != nil {
+		return resp, false
+	}
+	if err = json.Unmarshal(b, &resp); err != nil {
+		return resp, false
+	}
+	return resp, true
+}
+
+// Set writes the response for the url in the directory.
+// The file is written then renamed so a concurrent Get never reads a partial file.
+func (c DiskCache) Set(url string, resp CachedResponse) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.Dir, "tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(url))
+}
+
+// setConditionalHeaders looks the request url up in the cache and
+// add the validators of the cached response to the request.
+func setConditionalHeaders(cache Cache, r *http.Request) (CachedResponse, bool) {
+	if cache == nil {
+		return CachedResponse{}, false
+	}
+	cached, ok := cache.Get(r.URL.String())
+	if !ok {
+		return cached, false
+	}
+	if cached.ETag != "" {
+		r.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		r.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+	return cached, true
+}
+
+// storeResponse keeps the response body in the cache if it has a validator.
+func storeResponse(cache Cache, r *http.Request, resp *http.Response, body []byte) error {
+	if cache == nil {
+		return nil
+	}
+	cached := CachedResponse{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Link:         resp.Header.Get("Link"),
+		Body:         body,
+	}
+	if cached.ETag == "" && cached.LastModified == "" {
+		return nil
+	}
+	return cache.Set(r.URL.String(), cached)
+}
@@ -0,0 +1,82 @@
+package github
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestGetStargazersConditional(t *testing.T) {
+	filePath := "testdata/simple_stars.json"
+	body, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("An error occured while reading the file %s: %v\n", filePath, err)
+	}
+
+	etag := `"abcdef"`
+	full, notModified := 0, 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Link", "<next>; rel=\"next\"")
+		w.Write(body)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	client := NewClient("token")
+	client.Cache = NewMemoryCache()
+
+	for i := 0; i < 2; i++ {
+		stargazers, link, err := client.GetStargazers(server.URL)
+		if err != nil {
+			t.Fatalf("Request %d: an error occured while requesting the stargazers: %v", i, err)
+		}
+		if len(stargazers) != 3 {
+			t.Fatalf("Request %d: expected 3 stargazers, got %d", i, len(stargazers))
+		}
+		if link != "<next>; rel=\"next\"" {
+			t.Fatalf("Request %d: the Link header wasn't kept: %s", i, link)
+		}
+	}
+	if full != 1 || notModified != 1 {
+		t.Fatalf("Expected 1 full response and 1 not modified, got %d and %d", full, notModified)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stargraph-cache")
+	if err != nil {
+		t.Fatalf("An error occured while creating the temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatalf("An error occured while creating the cache: %v", err)
+	}
+	url := "https://api.github.com/repositories/1/stargazers?page=2"
+	if _, ok := cache.Get(url); ok {
+		t.Fatal("The cache should be empty")
+	}
+	expected := CachedResponse{ETag: `"tag"`, LastModified: "Sat, 31 Oct 2015 10:00:00 GMT", Body: []byte("[]")}
+	if err := cache.Set(url, expected); err != nil {
+		t.Fatalf("An error occured while storing the response: %v", err)
+	}
+
+	// A new cache on the same directory should find the response
+	cache, _ = NewDiskCache(dir)
+	resp, ok := cache.Get(url)
+	if !ok {
+		t.Fatal("The response should have been found")
+	}
+	if resp.ETag != expected.ETag || resp.LastModified != expected.LastModified || string(resp.Body) != string(expected.Body) {
+		t.Fatalf("Expected %+v, got %+v", expected, resp)
+	}
+}
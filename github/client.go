@@ -0,0 +1,210 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBaseURL is the base URL of the public Github API.
+	DefaultBaseURL = "https://api.github.com/"
+	// maxLimiters is the number of tokens whose rate limit is tracked by WithToken
+	// above which the limiters of the budgets already reset are dropped.
+	maxLimiters = 1000
+	// starsPathFormat will help build the URL to request the stars.
+	// It uses the id of the repository to make the query, so it doesn't change on a rename.
+	starsPathFormat = "repositories/%d/stargazers"
+)
+
+// Client holds what is needed to talk to a Github API: the base URL,
+// which can be the one of a Github Enterprise Server, the HTTP client and the token.
+// If Tokens is set, it is used instead of the Token and the RateLimiter,
+// to spread the requests on several tokens for instance.
+// The RateLimiter and the Cache are optional.
+type Client struct {
+	BaseURL     *url.URL
+	HTTPClient  *http.Client
+	Token       string
+	Tokens      TokenSource
+	RateLimiter *RateLimiter
+	Cache       Cache
+
+	// limiters are the RateLimiters of the tokens of WithToken, shared by the copies.
+	limiters map[string]*RateLimiter
+}
+
+// limitersMtx guards the limiters of the clients.
+var limitersMtx sync.Mutex
+
+// NewClient creates a Client for the public Github API.
+// The token is an API Github token to be able to lift off the 60 requests/hour limit, it can be empty.
+func NewClient(token string) *Client {
+	baseURL, _ := url.Parse(DefaultBaseURL)
+	return &Client{
+		BaseURL:     baseURL,
+		HTTPClient:  http.DefaultClient,
+		Token:       token,
+		RateLimiter: &RateLimiter{},
+	}
+}
+
+// SetBaseURL changes the API the client talks to.
+// For Github Enterprise Server, it looks like https://ghe.example.com/api/v3/
+func (c *Client) SetBaseURL(baseURL string) error {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("An error occured while parsing the API URL %s: %v", baseURL, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("The API URL should be absolute, got %s", baseURL)
+	}
+	c.BaseURL = u
+	return nil
+}
+
+// WithToken returns a copy of the client using another token instead of its Tokens.
+// The copy shares the cache, but has the rate limit budget of the token
+// as it is tracked per token by Github. The budget is kept by the client
+// and shared by all the copies with the same token.
+func (c *Client) WithToken(token string) *Client {
+	limitersMtx.Lock()
+	defer limitersMtx.Unlock()
+	if c.limiters == nil {
+		c.limiters = make(map[string]*RateLimiter)
+	}
+	client := *c
+	client.Token = token
+	client.Tokens = nil
+	if c.RateLimiter != nil && token != c.Token {
+		limiter, ok := c.limiters[token]
+		if !ok {
+			if len(c.limiters) >= maxLimiters {
+				pruneLimiters(c.limiters)
+			}
+			limiter = &RateLimiter{Block: c.RateLimiter.Block}
+			c.limiters[token] = limiter
+		}
+		client.RateLimiter = limiter
+	}
+	return &client
+}
+
+// pruneLimiters drops the limiters whose budget has been reset since, there is nothing left to track.
+func pruneLimiters(limiters map[string]*RateLimiter) {
+	now := time.Now()
+	for token, limiter := range limiters {
+		rate, known := limiter.Rate()
+		if limiter.AvailableAt().Before(now) && (!known || rate.Reset.Before(now)) {
+			delete(limiters, token)
+		}
+	}
+}
+
+// URL resolves the path against the base URL of the API.
+func (c *Client) URL(path string) string {
+	u, err := c.BaseURL.Parse(path)
+	if err != nil {
+		return c.BaseURL.String() + path
+	}
+	return u.String()
+}
+
+// StarsURL returns the URL to request the stars of the repository with the id.
+func (c *Client) StarsURL(id int) string {
+	return c.URL(fmt.Sprintf(starsPathFormat, id))
+}
+
+// GraphQLURL returns the URL of the GraphQL endpoint of the API.
+// On Github Enterprise Server, it is /api/graphql while the REST API is in /api/v3/.
+func (c *Client) GraphQLURL() string {
+	if strings.HasSuffix(c.BaseURL.Path, "/v3/") {
+		return c.URL("../graphql")
+	}
+	return c.URL("graphql")
+}
+
+// newRequest creates a request authenticated with the token of the client.
+// The request is cancelled when the context is done.
+func (c *Client) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	r, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+	if c.Tokens == nil && c.Token != "" {
+		r.Header.Add("Authorization", "token "+c.Token)
+	}
+	return r, nil
+}
+
+// do sends the request through the rate limiter of the client.
+// With Tokens, the request is sent again with another token
+// as long as the tokens are refused because of the rate limit.
+func (c *Client) do(r *http.Request) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if c.Tokens != nil {
+		return c.doWithTokens(httpClient, r)
+	}
+	limiter := c.RateLimiter
+	if limiter == nil {
+		limiter = &RateLimiter{}
+	}
+	return limiter.Do(httpClient, r)
+}
+
+func (c *Client) doWithTokens(httpClient *http.Client, r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+	}
+
+	tried := make(map[string]bool)
+	var lastErr error
+	for {
+		token, limiter, err := c.Tokens.Token(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		if tried[token] {
+			// The pool gave back a token that was just refused
+			return nil, lastErr
+		}
+		tried[token] = true
+
+		// Each attempt has its own copy, the previous one might still be referenced by the transport
+		attempt := r.WithContext(r.Context())
+		attempt.Header = make(http.Header, len(r.Header)+1)
+		for k, v := range r.Header {
+			attempt.Header[k] = v
+		}
+		attempt.Header.Set("Authorization", "token "+token)
+		if body != nil {
+			attempt.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err := limiter.Do(httpClient, attempt)
+		if _, ok := err.(*RateLimitError); ok {
+			lastErr = err
+			continue
+		}
+		return resp, err
+	}
+}
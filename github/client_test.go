@@ -0,0 +1,96 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientURLs(t *testing.T) {
+	tests := []struct {
+		baseURL    string
+		starsURL   string
+		graphQLURL string
+	}{
+		{
+			baseURL:    DefaultBaseURL,
+			starsURL:   "https://api.github.com/repositories/42/stargazers",
+			graphQLURL: "https://api.github.com/graphql",
+		},
+		{
+			baseURL:    "https://ghe.example.com/api/v3/",
+			starsURL:   "https://ghe.example.com/api/v3/repositories/42/stargazers",
+			graphQLURL: "https://ghe.example.com/api/graphql",
+		},
+		{
+			baseURL:    "https://ghe.example.com/api/v3",
+			starsURL:   "https://ghe.example.com/api/v3/repositories/42/stargazers",
+			graphQLURL: "https://ghe.example.com/api/graphql",
+		},
+	}
+
+	for i, test := range tests {
+		client := NewClient("")
+		if err := client.SetBaseURL(test.baseURL); err != nil {
+			t.Fatalf("Test %d: an error occured while setting the base URL: %v", i, err)
+		}
+		if client.StarsURL(42) != test.starsURL {
+			t.Fatalf("Test %d: expected %s, got %s", i, test.starsURL, client.StarsURL(42))
+		}
+		if client.GraphQLURL() != test.graphQLURL {
+			t.Fatalf("Test %d: expected %s, got %s", i, test.graphQLURL, client.GraphQLURL())
+		}
+	}
+}
+
+func TestClientSetBaseURLRelative(t *testing.T) {
+	if err := NewClient("").SetBaseURL("ghe.example.com"); err == nil {
+		t.Fatal("A relative base URL should be refused")
+	}
+}
+
+func TestClientWithToken(t *testing.T) {
+	client := NewClient("first")
+	client.Cache = NewMemoryCache()
+	other := client.WithToken("second")
+	if other.Token != "second" || client.Token != "first" {
+		t.Fatalf("The token should only change on the copy: %s, %s", client.Token, other.Token)
+	}
+	if other.Cache != client.Cache {
+		t.Fatal("The cache should be shared")
+	}
+	if other.RateLimiter == client.RateLimiter {
+		t.Fatal("The rate limit budget should not be shared between tokens")
+	}
+	// The budget of a token is kept between the requests
+	if again := client.WithToken("second"); again.RateLimiter != other.RateLimiter {
+		t.Fatal("The rate limit budget of the token should be kept by the client")
+	}
+	if again := other.WithToken("second"); again.RateLimiter != other.RateLimiter {
+		t.Fatal("The rate limit budget of the token should be shared by the copies")
+	}
+	if first := other.WithToken("first"); first.RateLimiter == other.RateLimiter {
+		t.Fatal("The rate limit budget should not be shared between tokens")
+	}
+}
+
+func TestClientWithTokenPrune(t *testing.T) {
+	client := NewClient("")
+	for i := 0; i < maxLimiters; i++ {
+		client.WithToken(fmt.Sprintf("token%d", i))
+	}
+	// Exhausted until tomorrow
+	exhausted := client.WithToken("token0").RateLimiter
+	h := http.Header{}
+	h.Set(HeaderRateRemaining, "0")
+	h.Set(HeaderRateReset, fmt.Sprint(time.Now().Add(24*time.Hour).Unix()))
+	exhausted.update(h)
+	client.WithToken("new")
+	if len(client.limiters) != 2 {
+		t.Fatalf("Expected only the exhausted and the new limiters to be kept, got %d", len(client.limiters))
+	}
+	if client.WithToken("token0").RateLimiter != exhausted {
+		t.Fatal("The exhausted budget should be kept")
+	}
+}
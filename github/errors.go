@@ -0,0 +1,39 @@
+package github
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// StatusError is returned when the Github API answered with an unexpected status.
+type StatusError struct {
+	URL    string
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Wrong error status while requesting %s: %d", e.URL, e.Status)
+}
+
+// Retryable tells whether the request that failed with err might succeed if sent again.
+// Server errors, timeouts, temporary network errors and rate limits are retryable,
+// while errors such as a bad token (401), a repository that doesn't exist anymore (404)
+// or an invalid URL are not.
+func Retryable(err error) bool {
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *RateLimitError:
+		return true
+	case *StatusError:
+		return e.Status >= http.StatusInternalServerError || e.Status == http.StatusTooManyRequests
+	case net.Error:
+		return e.Timeout() || e.Temporary()
+	}
+	return false
+}
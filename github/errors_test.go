@@ -0,0 +1,36 @@
+package github
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{&StatusError{Status: http.StatusBadGateway}, true},
+		{&StatusError{Status: http.StatusInternalServerError}, true},
+		{&StatusError{Status: http.StatusUnauthorized}, false},
+		{&StatusError{Status: http.StatusNotFound}, false},
+		{&RateLimitError{Status: http.StatusForbidden}, true},
+		{&url.Error{Op: "Get", URL: "https://api.github.com", Err: &net.OpError{Op: "accept", Err: syscall.EMFILE}}, true},
+		{&url.Error{Op: "Get", URL: "https://api.github.com", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, true},
+		{&net.OpError{Op: "dial", Err: syscall.ETIMEDOUT}, true},
+		{&url.Error{Op: "Get", URL: "ftp://api.github.com", Err: fmt.Errorf("unsupported protocol scheme \"ftp\"")}, false},
+		{&url.Error{Op: "parse", URL: "://api.github.com", Err: fmt.Errorf("missing protocol scheme")}, false},
+		{&url.Error{Op: "Get", URL: "https://api.github.com", Err: &net.DNSError{Err: "no such host"}}, false},
+		{fmt.Errorf("An error occured while parsing the timestamp"), false},
+	}
+	for i, test := range tests {
+		if Retryable(test.err) != test.retryable {
+			t.Fatalf("Test %d: %v should be retryable: %v", i, test.err, test.retryable)
+		}
+	}
+}
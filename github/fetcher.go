@@ -0,0 +1,20 @@
+package github
+
+import "context"
+
+// StarFetcher is implemented by the different ways of getting the timestamps
+// of all the stars of a repository, so the CLI and the services can choose
+// between the REST API (limited to 400 pages) and the GraphQL API.
+// The requests are made with the client and stop when the context is done.
+// The timestamps are returned sorted.
+type StarFetcher interface {
+	FetchTimestamps(ctx context.Context, client *Client, info RepoInfo) ([]int64, error)
+}
+
+// StargazerFetcher is implemented by the StarFetchers that can also tell who starred
+// the repository, to thank the early adopters or spot the bots for instance.
+// The stargazers are returned sorted by the time of their star.
+type StargazerFetcher interface {
+	StarFetcher
+	FetchStargazers(ctx context.Context, client *Client, info RepoInfo) ([]Stargazer, error)
+}
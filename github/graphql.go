@@ -0,0 +1,157 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const stargazersQuery = `query($owner: String!, $name: String!, $first: Int!, $cursor: String) {
+  repository(owner: $owner, name: $name) {
+    stargazers(first: $first, after: $cursor, orderBy: {field: STARRED_AT, direction: ASC}) {
+      pageInfo { endCursor hasNextPage }
+      edges {
+        starredAt
+        node { login databaseId __typename avatarUrl url }
+      }
+    }
+  }
+}`
+
+// GraphQLFetcher is a StargazerFetcher using the GraphQL API.
+// Its cursor pagination doesn't have the 400 pages limit of the REST API.
+type GraphQLFetcher struct {
+	// PerPage is the number of stars per request, 100 (the maximum) if 0.
+	PerPage int
+}
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLStargazers struct {
+	Data struct {
+		Repository *struct {
+			Stargazers struct {
+				PageInfo struct {
+					EndCursor   string `json:"endCursor"`
+					HasNextPage bool   `json:"hasNextPage"`
+				} `json:"pageInfo"`
+				Edges []struct {
+					StarredAt string `json:"starredAt"`
+					Node      struct {
+						Login      string `json:"login"`
+						DatabaseID int    `json:"databaseId"`
+						Typename   string `json:"__typename"`
+						AvatarURL  string `json:"avatarUrl"`
+						URL        string `json:"url"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"stargazers"`
+		} `json:"repository"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// FetchTimestamps gets the timestamps of all the stars of the repository.
+func (f GraphQLFetcher) FetchTimestamps(ctx context.Context, client *Client, info RepoInfo) ([]int64, error) {
+	stargazers, err := f.FetchStargazers(ctx, client, info)
+	if err != nil {
+		return nil, err
+	}
+	return Timestamps(stargazers)
+}
+
+// FetchStargazers gets who starred the repository and when,
+// following the cursor of the stargazers connection.
+// The repository is identified by its FullName (:username/:reponame).
+func (f GraphQLFetcher) FetchStargazers(ctx context.Context, client *Client, info RepoInfo) ([]Stargazer, error) {
+	parts := strings.Split(info.FullName, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("The repository full name should be formated as :username/:reponame, got %q", info.FullName)
+	}
+	perPage := f.PerPage
+	if perPage <= 0 || perPage > 100 {
+		perPage = 100
+	}
+
+	stargazers := make([]Stargazer, 0, info.Count)
+	var cursor interface{}
+	for {
+		page, err := f.query(ctx, client, graphQLRequest{
+			Query: stargazersQuery,
+			Variables: map[string]interface{}{
+				"owner":  parts[0],
+				"name":   parts[1],
+				"first":  perPage,
+				"cursor": cursor,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		connection := page.Data.Repository.Stargazers
+		for _, edge := range connection.Edges {
+			stargazers = append(stargazers, Stargazer{
+				Timestamp: edge.StarredAt,
+				User: User{
+					Login:     edge.Node.Login,
+					ID:        edge.Node.DatabaseID,
+					Type:      edge.Node.Typename,
+					AvatarURL: edge.Node.AvatarURL,
+					HTMLURL:   edge.Node.URL,
+				},
+			})
+		}
+		if !connection.PageInfo.HasNextPage {
+			break
+		}
+		cursor = connection.PageInfo.EndCursor
+	}
+	return stargazers, nil
+}
+
+func (f GraphQLFetcher) query(ctx context.Context, client *Client, query graphQLRequest) (page graphQLStargazers, err error) {
+	body, err := json.Marshal(query)
+	if err != nil {
+		return
+	}
+	r, err := client.newRequest(ctx, "POST", client.GraphQLURL(), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	r.Header.Add("Content-Type", "application/json")
+
+	resp, err := client.do(r)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = &StatusError{URL: r.URL.String(), Status: resp.StatusCode}
+		return
+	}
+	if err = json.Unmarshal(respBody, &page); err != nil {
+		return
+	}
+	if len(page.Errors) > 0 {
+		err = fmt.Errorf("The GraphQL query failed: %s", page.Errors[0].Message)
+		return
+	}
+	if page.Data.Repository == nil {
+		err = fmt.Errorf("Repository not found")
+	}
+	return
+}
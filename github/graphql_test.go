@@ -0,0 +1,116 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func graphQLHandler(t *testing.T, pages map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/graphql" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "token token" {
+			t.Errorf("Unexpected authorization header %q", r.Header.Get("Authorization"))
+		}
+		var query graphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			t.Errorf("An error occured while decoding the query: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cursor, _ := query.Variables["cursor"].(string)
+		filePath, ok := pages[cursor]
+		if !ok {
+			t.Errorf("Unexpected cursor %q", cursor)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			t.Errorf("Reading error of %s: %v\n", filePath, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(body)
+	}
+}
+
+func TestGraphQLFetchTimestamps(t *testing.T) {
+	expectedTimestamps := []int64{1446285600, 1446289200, 1446292800}
+	server := httptest.NewServer(graphQLHandler(t, map[string]string{
+		"":             "testdata/graphql_stars_1.json",
+		"Y3Vyc29yOjI=": "testdata/graphql_stars_2.json",
+	}))
+	defer server.Close()
+
+	client := NewClient("token")
+	client.SetBaseURL(server.URL)
+	var fetcher StarFetcher = GraphQLFetcher{}
+	timestamps, err := fetcher.FetchTimestamps(context.Background(), client, RepoInfo{FullName: "evermax/stargraph"})
+	if err != nil {
+		t.Fatalf("An error occured while fetching the timestamps: %v", err)
+	}
+	if len(timestamps) != len(expectedTimestamps) {
+		t.Fatalf("Expected %v, got %v", expectedTimestamps, timestamps)
+	}
+	for i, v := range timestamps {
+		if expectedTimestamps[i] != v {
+			t.Fatalf("Expected %v, got %v", expectedTimestamps, timestamps)
+		}
+	}
+}
+
+func TestGraphQLFetchStargazers(t *testing.T) {
+	server := httptest.NewServer(graphQLHandler(t, map[string]string{
+		"":             "testdata/graphql_stars_1.json",
+		"Y3Vyc29yOjI=": "testdata/graphql_stars_2.json",
+	}))
+	defer server.Close()
+
+	client := NewClient("token")
+	client.SetBaseURL(server.URL)
+	var fetcher StargazerFetcher = GraphQLFetcher{}
+	stargazers, err := fetcher.FetchStargazers(context.Background(), client, RepoInfo{FullName: "evermax/stargraph"})
+	if err != nil {
+		t.Fatalf("An error occured while fetching the stargazers: %v", err)
+	}
+	expected := []Stargazer{
+		{"2015-10-31T10:00:00Z", User{"octocat", 583231, "User", "https://avatars.githubusercontent.com/u/583231?v=4", "https://github.com/octocat"}},
+		{"2015-10-31T11:00:00Z", User{"hubot", 480938, "User", "https://avatars.githubusercontent.com/u/480938?v=4", "https://github.com/hubot"}},
+		{"2015-10-31T12:00:00Z", User{"evermax", 1163025, "User", "https://avatars.githubusercontent.com/u/1163025?v=4", "https://github.com/evermax"}},
+	}
+	if len(stargazers) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, stargazers)
+	}
+	for i, v := range stargazers {
+		if expected[i] != v {
+			t.Fatalf("Expected %v, got %v", expected[i], v)
+		}
+	}
+}
+
+func TestGraphQLFetchTimestampsNotFound(t *testing.T) {
+	server := httptest.NewServer(graphQLHandler(t, map[string]string{
+		"": "testdata/graphql_not_found.json",
+	}))
+	defer server.Close()
+
+	client := NewClient("token")
+	client.SetBaseURL(server.URL)
+	_, err := GraphQLFetcher{}.FetchTimestamps(context.Background(), client, RepoInfo{FullName: "evermax/nothing"})
+	if err == nil {
+		t.Fatal("Expected an error for a repository that doesn't exist")
+	}
+}
+
+func TestGraphQLFetchTimestampsWrongName(t *testing.T) {
+	_, err := GraphQLFetcher{}.FetchTimestamps(context.Background(), NewClient("token"), RepoInfo{Name: "stargraph"})
+	if err == nil {
+		t.Fatal("Expected an error without the full name of the repository")
+	}
+}
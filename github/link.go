@@ -0,0 +1,133 @@
+package github
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Link is one of the targets of a Link header (RFC 8288).
+// Page is the value of the page parameter of the URL, 0 if there is none.
+type Link struct {
+	URL  string
+	Page int
+}
+
+// Links holds the targets of a Link header by relation type (next, last, prev, first...).
+// This header is the only way to know how to navigate the
+// different star pages. Especially, it helps to know what is the last page.
+// See https://developer.github.com/v3/#pagination
+type Links map[string]Link
+
+// Next returns the link to the next page, false if this is the last page.
+func (l Links) Next() (Link, bool) {
+	link, ok := l["next"]
+	return link, ok
+}
+
+// Last returns the link to the last page, false if this is the last page.
+func (l Links) Last() (Link, bool) {
+	link, ok := l["last"]
+	return link, ok
+}
+
+// WithPage returns the URL of the link with its page parameter set to page,
+// the URL of another page of the same list.
+func (l Link) WithPage(page int) (string, error) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return "", fmt.Errorf("An error occured while parsing the link %s: %v", l.URL, err)
+	}
+	query := u.Query()
+	query.Set("page", strconv.Itoa(page))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// ParseLinks parses a Link header such as
+//
+//	<https://api.github.com/repositories/1/stargazers?page=2>; rel="next", <https://api.github.com/repositories/1/stargazers?page=5>; rel="last"
+//
+// The order of the links and of their parameters doesn't matter,
+// a rel parameter with several relation types adds the link for each of them.
+// An empty header gives no links.
+func ParseLinks(header string) (Links, error) {
+	links := make(Links)
+	rest := strings.TrimSpace(header)
+	for rest != "" {
+		if rest[0] != '<' {
+			return nil, fmt.Errorf("An error occured while parsing the Link header, expected '<' at %q", rest)
+		}
+		end := strings.IndexByte(rest, '>')
+		if end < 0 {
+			return nil, fmt.Errorf("An error occured while parsing the Link header, missing '>' in %q", rest)
+		}
+		target := rest[1:end]
+		rest = rest[end+1:]
+
+		var rels []string
+		var param string
+		for {
+			rest = strings.TrimLeft(rest, " \t")
+			if rest == "" || rest[0] == ',' {
+				break
+			}
+			if rest[0] != ';' {
+				return nil, fmt.Errorf("An error occured while parsing the Link header, expected ';' at %q", rest)
+			}
+			param, rest = cutParam(rest[1:])
+			name, value := splitParam(param)
+			if strings.EqualFold(name, "rel") {
+				rels = append(rels, strings.Fields(value)...)
+			}
+		}
+		rest = strings.TrimLeft(strings.TrimPrefix(rest, ","), " \t")
+
+		link := Link{URL: target}
+		if u, err := url.Parse(target); err == nil {
+			link.Page, _ = strconv.Atoi(u.Query().Get("page"))
+		}
+		for _, rel := range rels {
+			links[strings.ToLower(rel)] = link
+		}
+	}
+	return links, nil
+}
+
+// cutParam returns the parameter at the beginning of s, up to the next
+// ';' or ',' that is not in a quoted string, and what follows it.
+func cutParam(s string) (string, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ';', ',':
+			if !quoted {
+				return s[:i], s[i:]
+			}
+		}
+	}
+	return s, ""
+}
+
+// splitParam splits a name=value parameter and unquotes the value.
+func splitParam(param string) (string, string) {
+	parts := strings.SplitN(param, "=", 2)
+	name := strings.TrimSpace(parts[0])
+	if len(parts) == 1 {
+		return name, ""
+	}
+	value := strings.TrimSpace(parts[1])
+	if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+		value = unquoted
+	} else {
+		value = strings.Trim(value, `"`)
+	}
+	return name, value
+}
@@ -0,0 +1,106 @@
+package github
+
+import "testing"
+
+func TestParseLinks(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected Links
+	}{
+		{
+			header:   "",
+			expected: Links{},
+		},
+		{
+			header: `<https://api.github.com/repositories/1/stargazers?per_page=100&page=2>; rel="next", <https://api.github.com/repositories/1/stargazers?per_page=100&page=5>; rel="last"`,
+			expected: Links{
+				"next": {URL: "https://api.github.com/repositories/1/stargazers?per_page=100&page=2", Page: 2},
+				"last": {URL: "https://api.github.com/repositories/1/stargazers?per_page=100&page=5", Page: 5},
+			},
+		},
+		{
+			// Reordered, with prev and first, the page before per_page
+			header: `<https://api.github.com/repositories/1/stargazers?page=5&per_page=100>; rel="last", <https://api.github.com/repositories/1/stargazers?page=1&per_page=100>; rel="first",` +
+				` <https://api.github.com/repositories/1/stargazers?page=2&per_page=100>; rel="prev", <https://api.github.com/repositories/1/stargazers?page=4&per_page=100>; rel="next"`,
+			expected: Links{
+				"next":  {URL: "https://api.github.com/repositories/1/stargazers?page=4&per_page=100", Page: 4},
+				"last":  {URL: "https://api.github.com/repositories/1/stargazers?page=5&per_page=100", Page: 5},
+				"first": {URL: "https://api.github.com/repositories/1/stargazers?page=1&per_page=100", Page: 1},
+				"prev":  {URL: "https://api.github.com/repositories/1/stargazers?page=2&per_page=100", Page: 2},
+			},
+		},
+		{
+			// Several relations, other parameters, unquoted rel and a comma in a quoted value
+			header: `<https://example.com/a?page=3>; title="a, b; c"; rel="next last",<https://example.com/b>;rel=prev`,
+			expected: Links{
+				"next": {URL: "https://example.com/a?page=3", Page: 3},
+				"last": {URL: "https://example.com/a?page=3", Page: 3},
+				"prev": {URL: "https://example.com/b"},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		links, err := ParseLinks(test.header)
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while parsing the header: %v", i, err)
+		}
+		if len(links) != len(test.expected) {
+			t.Fatalf("Test %d: expected %v, got %v", i, test.expected, links)
+		}
+		for rel, link := range test.expected {
+			if links[rel] != link {
+				t.Fatalf("Test %d: expected %v for %s, got %v", i, link, rel, links[rel])
+			}
+		}
+	}
+}
+
+func TestParseLinksLastPage(t *testing.T) {
+	links, err := ParseLinks(`<https://api.github.com/repositories/1/stargazers?page=1>; rel="first", <https://api.github.com/repositories/1/stargazers?page=4>; rel="prev"`)
+	if err != nil {
+		t.Fatalf("An error occured while parsing the header: %v", err)
+	}
+	if _, ok := links.Next(); ok {
+		t.Fatal("There should be no next link on the last page")
+	}
+	if _, ok := links.Last(); ok {
+		t.Fatal("There should be no last link on the last page")
+	}
+}
+
+func TestParseLinksMalformed(t *testing.T) {
+	headers := []string{
+		`https://api.github.com/repositories/1/stargazers?page=2; rel="next"`,
+		`<https://api.github.com/repositories/1/stargazers?page=2; rel="next"`,
+		`<https://api.github.com/repositories/1/stargazers?page=2> rel="next"`,
+	}
+	for i, header := range headers {
+		if _, err := ParseLinks(header); err == nil {
+			t.Fatalf("Test %d: expected an error for %s", i, header)
+		}
+	}
+}
+
+func TestLinkWithPage(t *testing.T) {
+	tests := []struct {
+		link     Link
+		page     int
+		expected string
+	}{
+		{Link{URL: "https://api.github.com/repositories/1/stargazers?per_page=100&page=5", Page: 5}, 3, "https://api.github.com/repositories/1/stargazers?page=3&per_page=100"},
+		{Link{URL: "https://api.github.com/repositories/1/stargazers"}, 2, "https://api.github.com/repositories/1/stargazers?page=2"},
+	}
+	for i, test := range tests {
+		url, err := test.link.WithPage(test.page)
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while setting the page: %v", i, err)
+		}
+		if url != test.expected {
+			t.Fatalf("Test %d: expected %s, got %s", i, test.expected, url)
+		}
+	}
+	if _, err := (Link{URL: "%zz"}).WithPage(2); err == nil {
+		t.Fatal("Expected an error for a malformed link")
+	}
+}
@@ -0,0 +1,244 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// HeaderRateLimit is the header holding the number of requests allowed per hour.
+	HeaderRateLimit = "X-RateLimit-Limit"
+	// HeaderRateRemaining is the header holding the number of requests left in the current window.
+	HeaderRateRemaining = "X-RateLimit-Remaining"
+	// HeaderRateReset is the header holding the Unix time at which the current window resets.
+	HeaderRateReset = "X-RateLimit-Reset"
+	// HeaderRetryAfter is sent along the secondary (abuse) rate limits,
+	// it holds the number of seconds to wait before retrying.
+	HeaderRetryAfter = "Retry-After"
+)
+
+// Rate represents the rate limit status of a token
+// as reported by the Github API in the response headers.
+type Rate struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// ParseRate reads the X-RateLimit headers of a response.
+// The second value is false if the headers were not present.
+func ParseRate(h http.Header) (Rate, bool) {
+	var rate Rate
+	remaining := h.Get(HeaderRateRemaining)
+	if remaining == "" {
+		return rate, false
+	}
+	var err error
+	if rate.Remaining, err = strconv.Atoi(remaining); err != nil {
+		return rate, false
+	}
+	rate.Limit, _ = strconv.Atoi(h.Get(HeaderRateLimit))
+	if reset, err := strconv.ParseInt(h.Get(HeaderRateReset), 10, 64); err == nil {
+		rate.Reset = time.Unix(reset, 0)
+	}
+	return rate, true
+}
+
+// parseRetryAfter reads the Retry-After header, that can either be
+// a number of seconds or an HTTP date.
+func parseRetryAfter(h http.Header) time.Duration {
+	value := h.Get(HeaderRetryAfter)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(time.Now())
+	}
+	return 0
+}
+
+// RateLimitError is returned when the Github API refused a request
+// because the rate limit of the token was exceeded.
+// It carries the time at which the requests can be made again.
+type RateLimitError struct {
+	Rate Rate
+	// RetryAfter is set when Github asked to slow down (secondary rate limit).
+	RetryAfter time.Duration
+	Status     int
+	// at is the time at which the error was received, used to compute the end of RetryAfter.
+	at time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Github API rate limit exceeded (status %d), requests allowed again at %s",
+		e.Status, e.ResetTime().Format(time.RFC3339))
+}
+
+// ResetTime returns the time at which it is possible to make requests again.
+func (e *RateLimitError) ResetTime() time.Time {
+	if e.RetryAfter > 0 {
+		return e.at.Add(e.RetryAfter)
+	}
+	return e.Rate.Reset
+}
+
+// Wait returns how long to wait before making requests again.
+func (e *RateLimitError) Wait() time.Duration {
+	wait := e.ResetTime().Sub(time.Now())
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// rateLimitError checks whether the response is a rate limit refusal
+// and return the corresponding RateLimitError, nil otherwise.
+func rateLimitError(resp *http.Response) *RateLimitError {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	rate, ok := ParseRate(resp.Header)
+	retryAfter := parseRetryAfter(resp.Header)
+	if retryAfter <= 0 && (!ok || rate.Remaining > 0) {
+		// A simple forbidden, the token doesn't have the rights
+		return nil
+	}
+	return &RateLimitError{
+		Rate:       rate,
+		RetryAfter: retryAfter,
+		Status:     resp.StatusCode,
+		at:         time.Now(),
+	}
+}
+
+// RateLimiter keeps track of the rate limit budget reported by the Github API
+// and is used to send the requests to it.
+// If Block is true, requests wait for the reset time when the budget is exhausted
+// instead of returning a RateLimitError.
+type RateLimiter struct {
+	Block bool
+
+	mtx   sync.Mutex
+	rate  Rate
+	known bool
+	// until is set when Github refused a request, it can be later than the reset of the rate.
+	until time.Time
+}
+
+// Rate returns the last rate limit status received.
+// The boolean is false if no request was made yet.
+func (l *RateLimiter) Rate() (Rate, bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.rate, l.known
+}
+
+// Remaining returns the number of requests left before reaching the rate limit,
+// or -1 if it is not known yet.
+func (l *RateLimiter) Remaining() int {
+	rate, ok := l.Rate()
+	if !ok {
+		return -1
+	}
+	return rate.Remaining
+}
+
+func (l *RateLimiter) update(h http.Header) {
+	rate, ok := ParseRate(h)
+	if !ok {
+		return
+	}
+	l.mtx.Lock()
+	l.rate = rate
+	l.known = true
+	l.mtx.Unlock()
+}
+
+// AvailableAt returns the time at which requests can be sent again.
+// It is in the past if the budget is not known to be exhausted.
+func (l *RateLimiter) AvailableAt() time.Time {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	at := l.until
+	if l.known && l.rate.Remaining <= 0 && l.rate.Reset.After(at) {
+		at = l.rate.Reset
+	}
+	return at
+}
+
+// exhausted returns the time to wait if the budget is known to be exhausted.
+func (l *RateLimiter) exhausted() time.Duration {
+	return l.AvailableAt().Sub(time.Now())
+}
+
+// Do sends the request with the client, and keeps track of the rate limit.
+// If the rate limit is exceeded, it will either return a RateLimitError
+// or, if Block is set, wait for the reset and send the request again.
+// The wait is interrupted when the context of the request is done.
+func (l *RateLimiter) Do(client *http.Client, r *http.Request) (*http.Response, error) {
+	// Keep the body as the request might be sent several times
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+	}
+	for {
+		if body != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		if wait := l.exhausted(); wait > 0 {
+			if !l.Block {
+				rate, _ := l.Rate()
+				return nil, &RateLimitError{Rate: rate, RetryAfter: wait, Status: http.StatusForbidden, at: time.Now()}
+			}
+			if err := sleep(r.Context(), wait); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := client.Do(r)
+		if err != nil {
+			return nil, err
+		}
+		l.update(resp.Header)
+
+		rateErr := rateLimitError(resp)
+		if rateErr == nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+		l.mtx.Lock()
+		l.until = rateErr.ResetTime()
+		l.mtx.Unlock()
+		if !l.Block {
+			return nil, rateErr
+		}
+		if err := sleep(r.Context(), rateErr.Wait()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleep waits for the duration, or returns the error of the context if it is done before.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
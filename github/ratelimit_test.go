@@ -0,0 +1,138 @@
+package github
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	h := http.Header{}
+	h.Set(HeaderRateLimit, "5000")
+	h.Set(HeaderRateRemaining, "4321")
+	h.Set(HeaderRateReset, "1446285600")
+	rate, ok := ParseRate(h)
+	if !ok {
+		t.Fatal("The rate headers should have been found")
+	}
+	if rate.Limit != 5000 || rate.Remaining != 4321 || rate.Reset.Unix() != 1446285600 {
+		t.Fatalf("Unexpected rate parsed: %+v", rate)
+	}
+
+	if _, ok := ParseRate(http.Header{}); ok {
+		t.Fatal("No rate should be found without the headers")
+	}
+}
+
+func TestGetStargazersRateLimitError(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderRateLimit, "60")
+		w.Header().Set(HeaderRateRemaining, "0")
+		w.Header().Set(HeaderRateReset, strconv.FormatInt(reset, 10))
+		w.WriteHeader(http.StatusForbidden)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	client := NewClient("token")
+
+	_, _, err := client.GetStargazers(server.URL)
+	rateErr, ok := err.(*RateLimitError)
+	if !ok {
+		t.Fatalf("Expected a *RateLimitError, got %v", err)
+	}
+	if rateErr.ResetTime().Unix() != reset {
+		t.Fatalf("Expected reset time %d, got %d", reset, rateErr.ResetTime().Unix())
+	}
+	if client.RateLimiter.Remaining() != 0 {
+		t.Fatalf("Expected no remaining budget, got %d", client.RateLimiter.Remaining())
+	}
+}
+
+func TestGetStargazersForbidden(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderRateRemaining, "59")
+		w.WriteHeader(http.StatusForbidden)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	client := NewClient("token")
+
+	_, _, err := client.GetStargazers(server.URL)
+	if err == nil {
+		t.Fatal("Expected an error on forbidden status")
+	}
+	if _, ok := err.(*RateLimitError); ok {
+		t.Fatal("A forbidden status with budget left is not a rate limit error")
+	}
+}
+
+func TestRateLimiterBlockRetryAfter(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set(HeaderRateRemaining, "10")
+		if calls == 1 {
+			w.Header().Set(HeaderRetryAfter, "1")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("[]"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	limiter := &RateLimiter{Block: true}
+	r, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatalf("An error occured while creating the request: %v", err)
+	}
+	start := time.Now()
+	resp, err := limiter.Do(http.DefaultClient, r)
+	if err != nil {
+		t.Fatalf("The limiter should have waited and retried, got: %v", err)
+	}
+	resp.Body.Close()
+	if calls != 2 {
+		t.Fatalf("Expected 2 calls, got %d", calls)
+	}
+	if time.Since(start) < time.Second {
+		t.Fatal("The limiter should have waited for the Retry-After duration")
+	}
+	if limiter.Remaining() != 10 {
+		t.Fatalf("Expected 10 remaining, got %d", limiter.Remaining())
+	}
+}
+
+func TestRateLimiterExhausted(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set(HeaderRateRemaining, "0")
+		w.Header().Set(HeaderRateReset, strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.Write([]byte("[]"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	limiter := &RateLimiter{}
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := limiter.Do(http.DefaultClient, r)
+		if i == 0 {
+			if err != nil {
+				t.Fatalf("The first request should succeed: %v", err)
+			}
+			resp.Body.Close()
+			continue
+		}
+		if _, ok := err.(*RateLimitError); !ok {
+			t.Fatalf("Expected a *RateLimitError once the budget is exhausted, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("The exhausted limiter shouldn't have sent the request, got %d calls", calls)
+	}
+}
@@ -1,11 +1,12 @@
 package github
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	"time"
 )
 
 // IRepoInfo is an interface for the RepoInfo for test purposes
@@ -16,31 +17,36 @@ type IRepoInfo interface {
 	URL() string
 }
 
-const (
-	// GithubRepoURL is the base URL to get the data for a repository
-	GithubRepoURL = "https://api.github.com/repos/"
-	// GithubStarURLFormat will help build the URL to request the stars.
-	// It will use the id of the repository to make the query.
-	GithubStarURLFormat = "https://api.github.com/repositories/%d/stargazers"
-)
-
 // RepoInfo is the entity that will be put in the database but
 // it is also used to parse the response from the Github API.
+// While a service works on the repository, WorkedOn is set and the service
+// holds a lease on the work until LeaseExpiry.
+// Version is the revision of the repository in the database, each write increments it.
 type RepoInfo struct {
-	ID           int     `json:"id"`
-	Name         string  `json:"name"`
-	Count        int     `json:"stargazers_count"`
-	CreationDate string  `json:"created_at"`
-	LastStarDate string  `json:"last_star_date,omitempty"`
-	LastUpdate   string  `json:"last_update,omitempty"`
-	WorkedOn     bool    `json:"worked_on,omitempty"`
-	Timestamps   []int64 `json:"timestamps,omitempty"`
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	FullName     string    `json:"full_name,omitempty"`
+	Count        int       `json:"stargazers_count"`
+	CreationDate string    `json:"created_at"`
+	LastStarDate string    `json:"last_star_date,omitempty"`
+	LastUpdate   string    `json:"last_update,omitempty"`
+	WorkedOn     bool      `json:"worked_on,omitempty"`
+	LeaseOwner   string    `json:"lease_owner,omitempty"`
+	LeaseExpiry  time.Time `json:"lease_expiry"`
+	Timestamps   []int64   `json:"timestamps,omitempty"`
+	StarsURL     string    `json:"stars_url,omitempty"`
+	Version      int64     `json:"version,omitempty"`
 	exist        bool
 }
 
-// URL will return the URL to request the stars for the repository
+// URL will return the URL to request the stars for the repository.
+// The StarsURL is set by Client.GetRepoInfo, if the RepoInfo wasn't
+// requested with a Client, it is the URL on the public Github API.
 func (info RepoInfo) URL() string {
-	return fmt.Sprintf(GithubStarURLFormat, info.ID)
+	if info.StarsURL != "" {
+		return info.StarsURL
+	}
+	return DefaultBaseURL + fmt.Sprintf(starsPathFormat, info.ID)
 }
 
 // StarCount will return the number of stars for the repository
@@ -60,23 +66,24 @@ func (info *RepoInfo) SetExist(exist bool) {
 }
 
 // GetRepoInfo get the api url from a repo.
-// The token is an API Github token to be able to lift off the 60 requests/hour limit
 // The repo is a Github repo formated as follow `:username/:reponame`
-func GetRepoInfo(token, repo string) (info RepoInfo, err error) {
-	url := GithubRepoURL + repo
-	r, err := http.NewRequest("GET", url, nil)
+// If the rate limit of the token is exceeded, the error is a *RateLimitError.
+func (c *Client) GetRepoInfo(repo string) (RepoInfo, error) {
+	return c.GetRepoInfoContext(context.Background(), repo)
+}
+
+// GetRepoInfoContext is GetRepoInfo with a context to cancel the request.
+func (c *Client) GetRepoInfoContext(ctx context.Context, repo string) (info RepoInfo, err error) {
+	r, err := c.newRequest(ctx, "GET", c.URL("repos/"+repo), nil)
 	if err != nil {
 		return
 	}
-	if token != "" {
-		r.Header.Add("Authorization", "token "+token)
-	}
 
-	client := http.Client{}
-	resp, err := client.Do(r)
+	resp, err := c.do(r)
 	if err != nil {
 		return
 	}
+	defer resp.Body.Close()
 
 	// The repo doesn't exist so no error, just empty repo
 	if resp.StatusCode == http.StatusNotFound {
@@ -96,19 +103,8 @@ func GetRepoInfo(token, repo string) (info RepoInfo, err error) {
 	if err = json.Unmarshal(bodyBytes, &info); err != nil {
 		return
 	}
+	info.StarsURL = c.StarsURL(info.ID)
 	info.exist = true
 
 	return info, nil
 }
-
-// BuildLinksFormat will build the Link header parser.
-// This header is the only way to know how to navigate the
-// different star pages. Especially, it helps to know what is
-// the last page.
-// See https://developer.github.com/v3/activity/starring/
-func BuildLinksFormat(url string) string {
-	if strings.Contains(url, "?") {
-		return "<" + url + "&page=%d>; rel=\"next\", <" + url + "&page=%d>; rel=\"last\""
-	}
-	return "<" + url + "?page=%d>; rel=\"next\", <" + url + "?page=%d>; rel=\"last\""
-}
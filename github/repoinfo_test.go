@@ -2,30 +2,63 @@ package github
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"strings"
 	"testing"
 )
 
-// func TestGetRepoInfo(t *testing.T) {
-// 	repo := "evermax/stargraph"
-// 	expectedURL := "https://api.github.com/repositories/45301830/stargazers"
-// 	repoInfo, err := GetRepoInfo("", repo)
-// 	if err != nil {
-// 		t.Fatalf("An error occured when getting the repo informations: %v\n", err)
-// 	}
-// 	if !repoInfo.Exist() {
-// 		t.Fatalf("The repository wasn't found on Github: %v\n", repoInfo)
-// 	}
-
-// 	if repoInfo.URL() != expectedURL {
-// 		t.Fatalf("The url gotten from Github API is %s, should be %s", repoInfo.URL(), expectedURL)
-// 	}
-// }
+func TestGetRepoInfo(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/evermax/stargraph" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"id": 45301830, "name": "stargraph", "full_name": "evermax/stargraph", "stargazers_count": 3}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := NewClient("")
+	if err := client.SetBaseURL(server.URL + "/api/v3"); err != nil {
+		t.Fatalf("An error occured while setting the base URL: %v", err)
+	}
+	expectedURL := server.URL + "/api/v3/repositories/45301830/stargazers"
+	repoInfo, err := client.GetRepoInfo("evermax/stargraph")
+	if err != nil {
+		t.Fatalf("An error occured when getting the repo informations: %v\n", err)
+	}
+	if !repoInfo.Exist() {
+		t.Fatalf("The repository wasn't found on Github: %v\n", repoInfo)
+	}
+	if repoInfo.URL() != expectedURL {
+		t.Fatalf("The url gotten from Github API is %s, should be %s", repoInfo.URL(), expectedURL)
+	}
+
+	repoInfo, err = client.GetRepoInfo("evermax/nothing")
+	if err != nil {
+		t.Fatalf("An error occured when getting the repo informations: %v\n", err)
+	}
+	if repoInfo.Exist() {
+		t.Fatal("The repository shouldn't exist")
+	}
+}
 
 func TestGetRepoInfo_WrongToken(t *testing.T) {
-	repo := "evermax/stargraph"
-	_, err := GetRepoInfo("qwerty", repo)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Bad credentials"}`))
+			return
+		}
+		w.Write([]byte("{}"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := NewClient("qwerty")
+	client.SetBaseURL(server.URL)
+	_, err := client.GetRepoInfo("evermax/stargraph")
 	if err == nil {
 		t.Fatal("There should be an error because the token is incorrect")
 	}
@@ -34,6 +67,14 @@ func TestGetRepoInfo_WrongToken(t *testing.T) {
 	}
 }
 
+func TestRepoInfoDefaultURL(t *testing.T) {
+	info := RepoInfo{ID: 45301830}
+	expectedURL := "https://api.github.com/repositories/45301830/stargazers"
+	if info.URL() != expectedURL {
+		t.Fatalf("Expected %s, got %s", expectedURL, info.URL())
+	}
+}
+
 func TestExist(t *testing.T) {
 	info := RepoInfo{}
 	info.SetExist(true)
@@ -41,23 +82,3 @@ func TestExist(t *testing.T) {
 		t.Fatalf("The exit variable wasn't changed.\n")
 	}
 }
-
-func TestBuildFormatWithPerPage(t *testing.T) {
-	var url = "test.com?perPage=3"
-	var expectedFormat = "<" + url + "&page=%d>; rel=\"next\", <" + url + "&page=%d>; rel=\"last\""
-
-	var result = BuildLinksFormat(url)
-	if expectedFormat != result {
-		t.Fatalf("expected following build format: %s\nGot: %s", expectedFormat, result)
-	}
-}
-
-func TestBuildFormatWithoutPerPage(t *testing.T) {
-	var url = "test.com"
-	var expectedFormat = "<" + url + "?page=%d>; rel=\"next\", <" + url + "?page=%d>; rel=\"last\""
-
-	var result = BuildLinksFormat(url)
-	if expectedFormat != result {
-		t.Fatalf("expected following build format: %s\nGot: %s", expectedFormat, result)
-	}
-}
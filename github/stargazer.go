@@ -1,6 +1,7 @@
 package github
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,8 +10,24 @@ import (
 )
 
 // Stargazer will be used to parse the result of a request from the Github API for the stars.
+// It tells who starred the repository and when.
 type Stargazer struct {
 	Timestamp string `json:"starred_at"`
+	User      User   `json:"user"`
+}
+
+// User is the profile of a Github account, as given along with the stars.
+type User struct {
+	Login     string `json:"login"`
+	ID        int    `json:"id"`
+	Type      string `json:"type"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	HTMLURL   string `json:"html_url,omitempty"`
+}
+
+// IsBot tells whether the account is a bot, like the ones of the Github Apps.
+func (u User) IsBot() bool {
+	return u.Type == "Bot"
 }
 
 func (s Stargazer) GetTimestamp() (int64, error) {
@@ -21,33 +38,68 @@ func (s Stargazer) GetTimestamp() (int64, error) {
 	return t.Unix(), nil
 }
 
-func GetStargazers(pageUrl, token string) (stargazers []Stargazer, link string, err error) {
-	r, err := http.NewRequest("GET", pageUrl, nil)
+// Timestamps returns the timestamps of the stars, in the same order.
+func Timestamps(stargazers []Stargazer) ([]int64, error) {
+	timestamps := make([]int64, 0, len(stargazers))
+	for _, star := range stargazers {
+		timestamp, err := star.GetTimestamp()
+		if err != nil {
+			return nil, err
+		}
+		timestamps = append(timestamps, timestamp)
+	}
+	return timestamps, nil
+}
+
+// GetStargazers requests one page of stargazers and returns them along with the Link header.
+// If the rate limit of the token is exceeded, the error is a *RateLimitError,
+// if Github answers with an unexpected status, it is a *StatusError.
+// When the client has a Cache, the request is conditional and the cached page
+// is reused if Github answers that it was not modified.
+func (c *Client) GetStargazers(pageURL string) ([]Stargazer, string, error) {
+	return c.GetStargazersContext(context.Background(), pageURL)
+}
+
+// GetStargazersContext is GetStargazers with a context to cancel the request.
+func (c *Client) GetStargazersContext(ctx context.Context, pageURL string) (stargazers []Stargazer, link string, err error) {
+	r, err := c.newRequest(ctx, "GET", pageURL, nil)
 	if err != nil {
 		return
 	}
 
 	r.Header.Add("Accept", "application/vnd.github.v3.star+json")
-	r.Header.Add("Authorization", "token "+token)
+	cached, hit := setConditionalHeaders(c.Cache, r)
 
-	client := http.Client{}
-	resp, err := client.Do(r)
+	resp, err := c.do(r)
 	if err != nil {
 		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("Wrong error status while requesting stargazers: %d", resp.StatusCode)
-		return
-	}
+	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
+	var body []byte
+	if hit && resp.StatusCode == http.StatusNotModified {
+		// The page didn't change since it was cached
+		body, link = cached.Body, cached.Link
+	} else {
+		if resp.StatusCode != http.StatusOK {
+			err = &StatusError{URL: pageURL, Status: resp.StatusCode}
+			return
+		}
+
+		body, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return
+		}
+		link = resp.Header.Get("Link")
+		if err = storeResponse(c.Cache, r, resp, body); err != nil {
+			err = fmt.Errorf("An error occured while caching the stargazers page: %v", err)
+			return
+		}
 	}
 
 	err = json.Unmarshal(body, &stargazers)
 	if err != nil {
 		return
 	}
-	return stargazers, resp.Header.Get("Link"), nil
+	return stargazers, link, nil
 }
@@ -11,7 +11,11 @@ import (
 
 func TestGetStargazers(t *testing.T) {
 	filePath := "testdata/simple_stars.json"
-	expectedTimestamps := []Stargazer{{"2015-10-31T10:00:00Z"}, {"2015-10-31T11:00:00Z"}, {"2015-10-31T12:00:00Z"}}
+	expectedTimestamps := []Stargazer{
+		{"2015-10-31T10:00:00Z", User{"octocat", 583231, "User", "https://avatars.githubusercontent.com/u/583231?v=4", "https://github.com/octocat"}},
+		{"2015-10-31T11:00:00Z", User{"dependabot[bot]", 49699333, "Bot", "https://avatars.githubusercontent.com/in/29110?v=4", "https://github.com/apps/dependabot"}},
+		{"2015-10-31T12:00:00Z", User{"evermax", 1163025, "User", "https://avatars.githubusercontent.com/u/1163025?v=4", "https://github.com/evermax"}},
+	}
 	body, err := ioutil.ReadFile(filePath)
 	batch := 1
 	if err != nil {
@@ -20,15 +24,14 @@ func TestGetStargazers(t *testing.T) {
 
 	serverURL := ""
 	handler := func(w http.ResponseWriter, r *http.Request) {
-		linkFormat := BuildLinksFormat(serverURL)
-		w.Header().Add("Link", fmt.Sprintf(linkFormat, 1, 1))
+		w.Header().Add("Link", fmt.Sprintf("<%s&page=2>; rel=\"next\", <%s&page=2>; rel=\"last\"", serverURL, serverURL))
 		w.WriteHeader(http.StatusOK)
 		w.Write(body)
 	}
 	server := httptest.NewServer(http.HandlerFunc(handler))
 	serverURL = server.URL + "?per_page=" + strconv.Itoa(batch)
 
-	timestamps, _, err := GetStargazers(server.URL, "token")
+	timestamps, link, err := NewClient("token").GetStargazers(serverURL)
 	if err != nil {
 		t.Fatalf("An error occured while requesting the timestamps: %v\n", err)
 	}
@@ -47,6 +50,16 @@ func TestGetStargazers(t *testing.T) {
 		t.Fatalf("The expected timestamps %v and the actual ones %v"+
 			" don't have the same values\n", expectedTimestamps, timestamps)
 	}
+	if timestamps[0].User.IsBot() || !timestamps[1].User.IsBot() {
+		t.Fatalf("Only the second stargazer should be a bot: %v", timestamps)
+	}
+	links, err := ParseLinks(link)
+	if err != nil {
+		t.Fatalf("An error occured while parsing the Link header %s: %v", link, err)
+	}
+	if next, ok := links.Next(); !ok || next.Page != 2 {
+		t.Fatalf("Expected a next link to page 2, got %v", links)
+	}
 }
 
 func TestTimestampParsingSuccess(t *testing.T) {
@@ -72,3 +85,18 @@ func TestTimestampParsingError(t *testing.T) {
 		t.Fatal("Expected error in parsing")
 	}
 }
+
+func TestTimestamps(t *testing.T) {
+	stargazers := []Stargazer{{Timestamp: "2015-10-31T10:00:00Z"}, {Timestamp: "2015-10-31T11:00:00Z"}}
+	timestamps, err := Timestamps(stargazers)
+	if err != nil {
+		t.Fatalf("An error occured while parsing the timestamps: %v", err)
+	}
+	if len(timestamps) != 2 || timestamps[0] != 1446285600 || timestamps[1] != 1446289200 {
+		t.Fatalf("Expected [1446285600 1446289200], got %v", timestamps)
+	}
+
+	if _, err := Timestamps([]Stargazer{{Timestamp: "yesterday"}}); err == nil {
+		t.Fatal("Expected error in parsing")
+	}
+}
@@ -0,0 +1,159 @@
+package github
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource hands out the token to authenticate a request with,
+// along with the RateLimiter tracking the budget of that token.
+// It can return a *RateLimitError if no token can be used right now.
+// The context is the one of the request, it stops the source waiting or
+// making requests to get the token.
+type TokenSource interface {
+	Token(ctx context.Context) (string, *RateLimiter, error)
+}
+
+// TokenPool is a TokenSource spreading the requests on several Github API tokens.
+// It hands out the token with the most remaining budget, the tokens with the same
+// budget being used in turn, and skips the exhausted tokens until their reset time.
+// It is safe for concurrent use.
+type TokenPool struct {
+	mtx      sync.Mutex
+	tokens   []string
+	limiters []*RateLimiter
+	next     int
+}
+
+// NewTokenPool creates a TokenPool with the tokens, the empty ones are ignored.
+func NewTokenPool(tokens ...string) *TokenPool {
+	p := &TokenPool{}
+	for _, token := range tokens {
+		p.Add(token)
+	}
+	return p
+}
+
+// TokenPoolFromFile creates a TokenPool with the tokens of the file, one per line.
+// Empty lines and lines starting with # are ignored.
+func TokenPoolFromFile(path string) (*TokenPool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &TokenPool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.Add(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("An error occured while reading the tokens file %s: %v", path, err)
+	}
+	if p.Len() == 0 {
+		return nil, fmt.Errorf("No token found in %s", path)
+	}
+	return p, nil
+}
+
+// TokenPoolFromEnv creates a TokenPool with the tokens of the environment variable,
+// separated by commas or spaces.
+func TokenPoolFromEnv(name string) (*TokenPool, error) {
+	tokens := strings.FieldsFunc(os.Getenv(name), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("No token found in the environment variable %s", name)
+	}
+	return NewTokenPool(tokens...), nil
+}
+
+// Add adds a token to the pool, if it is not empty and not already in it.
+func (p *TokenPool) Add(token string) {
+	if token == "" {
+		return
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, t := range p.tokens {
+		if t == token {
+			return
+		}
+	}
+	p.tokens = append(p.tokens, token)
+	p.limiters = append(p.limiters, &RateLimiter{})
+}
+
+// Len returns the number of tokens in the pool.
+func (p *TokenPool) Len() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return len(p.tokens)
+}
+
+// Remaining returns the sum of the budgets of the tokens that are known.
+func (p *TokenPool) Remaining() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var remaining int
+	for _, limiter := range p.limiters {
+		if r := limiter.Remaining(); r > 0 {
+			remaining += r
+		}
+	}
+	return remaining
+}
+
+// Token returns the token with the most remaining budget, a token that was
+// not used yet being considered as having a full budget.
+// If all the tokens are exhausted, the *RateLimitError holds the earliest reset time.
+func (p *TokenPool) Token(ctx context.Context) (string, *RateLimiter, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if len(p.tokens) == 0 {
+		return "", nil, fmt.Errorf("The token pool is empty")
+	}
+
+	now := time.Now()
+	best := -1
+	bestRemaining := 0
+	var earliest time.Time
+	// Start after the last token handed out, so the tokens with the same budget are used in turn
+	for i := 0; i < len(p.tokens); i++ {
+		index := (p.next + i) % len(p.tokens)
+		limiter := p.limiters[index]
+		if at := limiter.AvailableAt(); at.After(now) {
+			if earliest.IsZero() || at.Before(earliest) {
+				earliest = at
+			}
+			continue
+		}
+		remaining := limiter.Remaining()
+		if remaining < 0 {
+			remaining = int(^uint(0) >> 1)
+		}
+		if best < 0 || remaining > bestRemaining {
+			best, bestRemaining = index, remaining
+		}
+	}
+	if best < 0 {
+		return "", nil, &RateLimitError{
+			Status:     http.StatusForbidden,
+			RetryAfter: earliest.Sub(now),
+			at:         now,
+		}
+	}
+	p.next = best + 1
+	return p.tokens[best], p.limiters[best], nil
+}
@@ -0,0 +1,181 @@
+package github
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenPoolRoundRobin(t *testing.T) {
+	pool := NewTokenPool("a", "b", "", "c", "a")
+	if pool.Len() != 3 {
+		t.Fatalf("Expected 3 tokens, got %d", pool.Len())
+	}
+
+	expected := []string{"a", "b", "c", "a", "b", "c"}
+	for i, e := range expected {
+		token, _, err := pool.Token(context.Background())
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while getting a token: %v", i, err)
+		}
+		if token != e {
+			t.Fatalf("Test %d: expected token %s, got %s", i, e, token)
+		}
+	}
+}
+
+func TestTokenPoolBudget(t *testing.T) {
+	pool := NewTokenPool("a", "b", "c")
+	reset := time.Now().Add(time.Hour)
+	budgets := map[string]int{"a": 10, "b": 500, "c": 0}
+	for i := 0; i < 3; i++ {
+		token, limiter, err := pool.Token(context.Background())
+		if err != nil {
+			t.Fatalf("An error occured while getting a token: %v", err)
+		}
+		h := http.Header{}
+		h.Set(HeaderRateLimit, "5000")
+		h.Set(HeaderRateRemaining, strconv.Itoa(budgets[token]))
+		h.Set(HeaderRateReset, strconv.FormatInt(reset.Unix(), 10))
+		limiter.update(h)
+	}
+
+	// b has the most budget left and c is exhausted
+	for i := 0; i < 3; i++ {
+		token, _, err := pool.Token(context.Background())
+		if err != nil {
+			t.Fatalf("An error occured while getting a token: %v", err)
+		}
+		if token != "b" {
+			t.Fatalf("Expected token b, got %s", token)
+		}
+	}
+	if pool.Remaining() != 510 {
+		t.Fatalf("Expected a remaining budget of 510, got %d", pool.Remaining())
+	}
+}
+
+func TestTokenPoolExhausted(t *testing.T) {
+	pool := NewTokenPool("a", "b")
+	now := time.Now()
+	pool.limiters[0].until = now.Add(time.Hour)
+	pool.limiters[1].until = now.Add(time.Minute)
+
+	_, _, err := pool.Token(context.Background())
+	rateErr, ok := err.(*RateLimitError)
+	if !ok {
+		t.Fatalf("Expected a *RateLimitError, got %v", err)
+	}
+	if wait := rateErr.Wait(); wait > time.Minute || wait < 50*time.Second {
+		t.Fatalf("Expected to wait about a minute, got %v", wait)
+	}
+
+	if _, _, err := NewTokenPool().Token(context.Background()); err == nil {
+		t.Fatal("An empty pool shouldn't return a token")
+	}
+}
+
+func TestTokenPoolFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stargraph-tokens")
+	if err != nil {
+		t.Fatalf("An error occured while creating the temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens")
+	if err := ioutil.WriteFile(path, []byte("# Github tokens\na\n\n  b  \n#c\n"), 0600); err != nil {
+		t.Fatalf("An error occured while writing the tokens file: %v", err)
+	}
+	pool, err := TokenPoolFromFile(path)
+	if err != nil {
+		t.Fatalf("An error occured while loading the tokens: %v", err)
+	}
+	if len(pool.tokens) != 2 || pool.tokens[0] != "a" || pool.tokens[1] != "b" {
+		t.Fatalf("Expected tokens [a b], got %v", pool.tokens)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("# No token\n"), 0600); err != nil {
+		t.Fatalf("An error occured while writing the tokens file: %v", err)
+	}
+	if _, err := TokenPoolFromFile(path); err == nil {
+		t.Fatal("A file without token should return an error")
+	}
+	if _, err := TokenPoolFromFile(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("A missing file should return an error")
+	}
+}
+
+func TestTokenPoolFromEnv(t *testing.T) {
+	name := "STARGRAPH_TEST_TOKENS"
+	defer os.Unsetenv(name)
+
+	os.Setenv(name, "a,b c, d")
+	pool, err := TokenPoolFromEnv(name)
+	if err != nil {
+		t.Fatalf("An error occured while loading the tokens: %v", err)
+	}
+	if pool.Len() != 4 {
+		t.Fatalf("Expected 4 tokens, got %v", pool.tokens)
+	}
+
+	os.Setenv(name, " , ")
+	if _, err := TokenPoolFromEnv(name); err == nil {
+		t.Fatal("An empty variable should return an error")
+	}
+}
+
+func TestClientTokenRotation(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+	var mtx sync.Mutex
+	calls := make(map[string]int)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		mtx.Lock()
+		calls[auth]++
+		mtx.Unlock()
+		w.Header().Set(HeaderRateLimit, "5000")
+		w.Header().Set(HeaderRateReset, strconv.FormatInt(reset, 10))
+		if auth == "token exhausted" {
+			w.Header().Set(HeaderRateRemaining, "0")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set(HeaderRateRemaining, "4000")
+		w.Write([]byte("[]"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := NewClient("")
+	client.Tokens = NewTokenPool("exhausted", "valid")
+	for i := 0; i < 3; i++ {
+		if _, _, err := client.GetStargazers(server.URL); err != nil {
+			t.Fatalf("Request %d: an error occured: %v", i, err)
+		}
+	}
+
+	mtx.Lock()
+	exhausted, valid := calls["token exhausted"], calls["token valid"]
+	mtx.Unlock()
+	if exhausted != 1 {
+		t.Fatalf("The exhausted token should have been used once, got %d", exhausted)
+	}
+	if valid != 3 {
+		t.Fatalf("The valid token should have been used 3 times, got %d", valid)
+	}
+
+	// Once all the tokens are exhausted, the error is returned
+	client.Tokens = NewTokenPool("exhausted")
+	if _, _, err := client.GetStargazers(server.URL); err == nil {
+		t.Fatal("Expected an error with only exhausted tokens")
+	} else if _, ok := err.(*RateLimitError); !ok {
+		t.Fatalf("Expected a *RateLimitError, got %v", err)
+	}
+}
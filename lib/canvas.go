@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
 )
 
 type CanvasData struct {
@@ -15,15 +16,26 @@ type CanvasData struct {
 type CanvasJSON struct {
 	CreatedDate string       `json:"created_at"`
 	Data        []CanvasData `json:"data"`
+	Net         []CanvasData `json:"net,omitempty"`
 }
 
 func WriteCanvasJS(timestamps []int64, info github.RepoInfo, w io.Writer) error {
+	return WriteCanvasJSNet(timestamps, nil, info, w)
+}
+
+// WriteCanvasJSNet writes the cumulative stars along with the net stars over time,
+// that go down when stargazers unstarred the repository.
+func WriteCanvasJSNet(timestamps []int64, net []history.Point, info github.RepoInfo, w io.Writer) error {
 	canvasData := make([]CanvasData, len(timestamps))
 	for i, timestamp := range timestamps {
 		canvasData[i] = CanvasData{X: timestamp * 1000, Y: int64(i + 1)}
 	}
+	netData := make([]CanvasData, len(net))
+	for i, point := range net {
+		netData[i] = CanvasData{X: point.Time * 1000, Y: int64(point.Stars)}
+	}
 
-	bytes, err := json.Marshal(CanvasJSON{CreatedDate: info.CreationDate, Data: canvasData})
+	bytes, err := json.Marshal(CanvasJSON{CreatedDate: info.CreationDate, Data: canvasData, Net: netData})
 	if err != nil {
 		return err
 	}
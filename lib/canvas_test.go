@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
 )
 
 func TestWriteCanvas(t *testing.T) {
@@ -19,3 +20,16 @@ func TestWriteCanvas(t *testing.T) {
 		t.Fatalf("Expected %s, got %s", expected, str)
 	}
 }
+
+func TestWriteCanvasNet(t *testing.T) {
+	timestamps := []int64{1234, 5678}
+	net := []history.Point{{Time: 1234, Stars: 1}, {Time: 3000, Stars: 0}, {Time: 5678, Stars: 1}}
+	expected := `{"created_at":"2015-10-31T13:01:08Z","data":[{"x":1234000,"y":1},{"x":5678000,"y":2}],"net":[{"x":1234000,"y":1},{"x":3000000,"y":0},{"x":5678000,"y":1}]}`
+	var buff bytes.Buffer
+	if err := WriteCanvasJSNet(timestamps, net, github.RepoInfo{CreationDate: "2015-10-31T13:01:08Z"}, &buff); err != nil {
+		t.Fatalf("An error when writing the writer: %v", err)
+	}
+	if str := buff.String(); str != expected {
+		t.Fatalf("Expected %s, got %s", expected, str)
+	}
+}
@@ -0,0 +1,223 @@
+// Package history keeps snapshots of who starred a repository and when, to find out
+// who unstarred it since. Github only lists the current stargazers, so a graph made of
+// their timestamps never goes down: the net stars over time are the stars of all
+// the snapshots minus the stars gone from one snapshot to the next.
+package history
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/evermax/stargraph/github"
+)
+
+// ErrCorrupted is returned when a snapshot can't be decoded.
+var ErrCorrupted = fmt.Errorf("Corrupted snapshot of the stargazers")
+
+// Star is a star of the stargazer with the UserID at the Unix time StarredAt.
+type Star struct {
+	UserID    int   `json:"user_id"`
+	StarredAt int64 `json:"starred_at"`
+}
+
+// Snapshot is the set of the stars of a repository at the Unix time Taken,
+// sorted by UserID.
+type Snapshot struct {
+	Taken int64
+	Stars []Star
+}
+
+// NewSnapshot creates the snapshot of the stargazers at the time taken.
+func NewSnapshot(taken time.Time, stargazers []github.Stargazer) (Snapshot, error) {
+	timestamps, err := github.Timestamps(stargazers)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot := Snapshot{Taken: taken.Unix(), Stars: make([]Star, len(stargazers))}
+	for i, stargazer := range stargazers {
+		snapshot.Stars[i] = Star{UserID: stargazer.User.ID, StarredAt: timestamps[i]}
+	}
+	sort.Sort(byUserID(snapshot.Stars))
+	return snapshot, nil
+}
+
+// MarshalBinary encodes the snapshot: the user IDs as the unsigned varint of the difference
+// with the previous one, each followed by the varint of the time of the star.
+func (s Snapshot) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 2*binary.MaxVarintLen64+len(s.Stars)*8)
+	buf := make([]byte, binary.MaxVarintLen64)
+	put := func(n int) {
+		b = append(b, buf[:n]...)
+	}
+	put(binary.PutUvarint(buf, uint64(len(s.Stars))))
+	put(binary.PutVarint(buf, s.Taken))
+	previous := 0
+	for _, star := range s.Stars {
+		if star.UserID < previous {
+			return nil, fmt.Errorf("The stars of the snapshot are not sorted by user")
+		}
+		put(binary.PutUvarint(buf, uint64(star.UserID-previous)))
+		put(binary.PutVarint(buf, star.StarredAt))
+		previous = star.UserID
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a snapshot encoded with MarshalBinary.
+func (s *Snapshot) UnmarshalBinary(b []byte) error {
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return ErrCorrupted
+	}
+	b = b[n:]
+	taken, n := binary.Varint(b)
+	if n <= 0 {
+		return ErrCorrupted
+	}
+	b = b[n:]
+	// Each star takes at least 2 bytes
+	if count > uint64(len(b)/2) {
+		return ErrCorrupted
+	}
+	stars := make([]Star, count)
+	previous := 0
+	for i := range stars {
+		delta, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrCorrupted
+		}
+		b = b[n:]
+		starredAt, n := binary.Varint(b)
+		if n <= 0 {
+			return ErrCorrupted
+		}
+		b = b[n:]
+		previous += int(delta)
+		stars[i] = Star{UserID: previous, StarredAt: starredAt}
+	}
+	if len(b) != 0 {
+		return ErrCorrupted
+	}
+	s.Taken, s.Stars = taken, stars
+	return nil
+}
+
+// Unstar is a star that was in a snapshot but not in the next one.
+// Github doesn't tell when the stargazer unstarred, so it is the time Detected
+// of the snapshot it is gone from, or of the new star if the stargazer starred again.
+type Unstar struct {
+	Star
+	Detected int64 `json:"detected"`
+}
+
+// Diff returns the stars of the next snapshot that were not in the previous one,
+// and the stars of the previous one that are gone.
+// A stargazer who unstarred and starred again between them is in both.
+func Diff(previous, next Snapshot) (stars []Star, unstars []Unstar) {
+	before := make(map[int]int64, len(previous.Stars))
+	for _, star := range previous.Stars {
+		before[star.UserID] = star.StarredAt
+	}
+	after := make(map[int]int64, len(next.Stars))
+	for _, star := range next.Stars {
+		after[star.UserID] = star.StarredAt
+		if starredAt, ok := before[star.UserID]; !ok || starredAt != star.StarredAt {
+			stars = append(stars, star)
+		}
+	}
+	for _, star := range previous.Stars {
+		starredAt, ok := after[star.UserID]
+		switch {
+		case !ok:
+			unstars = append(unstars, Unstar{Star: star, Detected: next.Taken})
+		case starredAt != star.StarredAt:
+			// Starred again, the unstar was before
+			unstars = append(unstars, Unstar{Star: star, Detected: starredAt})
+		}
+	}
+	return stars, unstars
+}
+
+// Unstars returns the unstars found between the snapshots, sorted by the time they were detected.
+func Unstars(snapshots []Snapshot) []Unstar {
+	snapshots = sorted(snapshots)
+	var unstars []Unstar
+	for i := 1; i < len(snapshots); i++ {
+		_, gone := Diff(snapshots[i-1], snapshots[i])
+		unstars = append(unstars, gone...)
+	}
+	sort.Stable(byDetected(unstars))
+	return unstars
+}
+
+// Point is the number of Stars at the Unix Time.
+type Point struct {
+	Time  int64 `json:"time"`
+	Stars int   `json:"stars"`
+}
+
+// Net returns the net stars over time: a point at each time the number of stars changed,
+// counting the stars of all the snapshots and removing the unstars.
+func Net(snapshots []Snapshot) []Point {
+	snapshots = sorted(snapshots)
+	changes := make(map[int64]int)
+	var previous Snapshot
+	for _, snapshot := range snapshots {
+		stars, unstars := Diff(previous, snapshot)
+		for _, star := range stars {
+			changes[star.StarredAt]++
+		}
+		for _, unstar := range unstars {
+			changes[unstar.Detected]--
+		}
+		previous = snapshot
+	}
+
+	times := make([]int64, 0, len(changes))
+	for t, change := range changes {
+		if change != 0 {
+			times = append(times, t)
+		}
+	}
+	sort.Sort(int64s(times))
+	points := make([]Point, len(times))
+	count := 0
+	for i, t := range times {
+		count += changes[t]
+		points[i] = Point{Time: t, Stars: count}
+	}
+	return points
+}
+
+// sorted returns a copy of the snapshots sorted by the time they were taken.
+func sorted(snapshots []Snapshot) []Snapshot {
+	snapshots = append([]Snapshot(nil), snapshots...)
+	sort.Stable(byTaken(snapshots))
+	return snapshots
+}
+
+type byUserID []Star
+
+func (s byUserID) Len() int           { return len(s) }
+func (s byUserID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byUserID) Less(i, j int) bool { return s[i].UserID < s[j].UserID }
+
+type byDetected []Unstar
+
+func (s byDetected) Len() int           { return len(s) }
+func (s byDetected) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDetected) Less(i, j int) bool { return s[i].Detected < s[j].Detected }
+
+type byTaken []Snapshot
+
+func (s byTaken) Len() int           { return len(s) }
+func (s byTaken) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTaken) Less(i, j int) bool { return s[i].Taken < s[j].Taken }
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
@@ -0,0 +1,90 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evermax/stargraph/github"
+)
+
+func TestNewSnapshot(t *testing.T) {
+	stargazers := []github.Stargazer{
+		{Timestamp: "2015-10-31T11:00:00Z", User: github.User{Login: "dependabot[bot]", ID: 49699333}},
+		{Timestamp: "2015-10-31T10:00:00Z", User: github.User{Login: "octocat", ID: 583231}},
+	}
+	taken := time.Date(2015, 11, 1, 10, 0, 0, 0, time.UTC)
+	snapshot, err := NewSnapshot(taken, stargazers)
+	if err != nil {
+		t.Fatalf("An error occured while creating the snapshot: %v", err)
+	}
+	expected := []Star{{UserID: 583231, StarredAt: 1446285600}, {UserID: 49699333, StarredAt: 1446289200}}
+	if snapshot.Taken != taken.Unix() || len(snapshot.Stars) != 2 || snapshot.Stars[0] != expected[0] || snapshot.Stars[1] != expected[1] {
+		t.Fatalf("Expected the stars %v sorted by user, got %+v", expected, snapshot)
+	}
+
+	if _, err := NewSnapshot(taken, []github.Stargazer{{Timestamp: "yesterday"}}); err == nil {
+		t.Fatal("A stargazer with an invalid timestamp should fail")
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	snapshot := Snapshot{Taken: 1446372000, Stars: []Star{{UserID: 1, StarredAt: 1446285600}, {UserID: 583231, StarredAt: 1446289200}}}
+	b, err := snapshot.MarshalBinary()
+	if err != nil {
+		t.Fatalf("An error occured while encoding the snapshot: %v", err)
+	}
+	var decoded Snapshot
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatalf("An error occured while decoding the snapshot: %v", err)
+	}
+	if decoded.Taken != snapshot.Taken || len(decoded.Stars) != 2 || decoded.Stars[0] != snapshot.Stars[0] || decoded.Stars[1] != snapshot.Stars[1] {
+		t.Fatalf("Expected %+v, got %+v", snapshot, decoded)
+	}
+
+	for i, corrupted := range [][]byte{nil, b[:len(b)-1], append(b, 0)} {
+		if err := decoded.UnmarshalBinary(corrupted); err != ErrCorrupted {
+			t.Fatalf("Test %d: expected %v, got %v", i, ErrCorrupted, err)
+		}
+	}
+	unsorted := Snapshot{Stars: []Star{{UserID: 2}, {UserID: 1}}}
+	if _, err := unsorted.MarshalBinary(); err == nil {
+		t.Fatal("Encoding a snapshot not sorted by user should fail")
+	}
+}
+
+func TestDiffUnstarsNet(t *testing.T) {
+	snapshots := []Snapshot{
+		// Out of order, they are sorted by the time they were taken
+		{Taken: 300, Stars: []Star{{UserID: 1, StarredAt: 10}, {UserID: 3, StarredAt: 250}, {UserID: 4, StarredAt: 120}}},
+		{Taken: 100, Stars: []Star{{UserID: 1, StarredAt: 10}, {UserID: 2, StarredAt: 20}, {UserID: 4, StarredAt: 30}}},
+		{Taken: 200, Stars: []Star{{UserID: 1, StarredAt: 10}, {UserID: 4, StarredAt: 120}}},
+	}
+
+	stars, unstars := Diff(snapshots[1], snapshots[2])
+	// 2 unstarred, 4 unstarred and starred again
+	if len(stars) != 1 || stars[0] != (Star{UserID: 4, StarredAt: 120}) {
+		t.Fatalf("Expected the new star of 4, got %v", stars)
+	}
+	if len(unstars) != 2 || unstars[0].UserID != 2 || unstars[0].Detected != 200 || unstars[1].UserID != 4 || unstars[1].Detected != 120 {
+		t.Fatalf("Expected the unstars of 2 at 200 and 4 at 120, got %v", unstars)
+	}
+
+	all := Unstars(snapshots)
+	if len(all) != 2 || all[0].UserID != 4 || all[1].UserID != 2 {
+		t.Fatalf("Expected the unstars sorted by detection, got %v", all)
+	}
+
+	expected := []Point{{10, 1}, {20, 2}, {30, 3}, {200, 2}, {250, 3}}
+	net := Net(snapshots)
+	if len(net) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, net)
+	}
+	for i := range expected {
+		if net[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, net)
+		}
+	}
+	if points := Net(nil); len(points) != 0 {
+		t.Fatalf("Expected no points without snapshots, got %v", points)
+	}
+}
@@ -3,6 +3,8 @@ package lib
 import (
 	"encoding/json"
 	"io"
+
+	"github.com/evermax/stargraph/lib/history"
 )
 
 func WriteJQPlot(timestamps []int64, w io.Writer) error {
@@ -20,3 +22,22 @@ func WriteJQPlot(timestamps []int64, w io.Writer) error {
 	}
 	return nil
 }
+
+// WriteJQPlotNet writes two series: the cumulative stars and the net stars over time,
+// that go down when stargazers unstarred the repository.
+func WriteJQPlotNet(timestamps []int64, net []history.Point, w io.Writer) error {
+	cumulative := make([][]int64, len(timestamps))
+	for i, timestamp := range timestamps {
+		cumulative[i] = []int64{timestamp, int64(i + 1)}
+	}
+	netPlots := make([][]int64, len(net))
+	for i, point := range net {
+		netPlots[i] = []int64{point.Time, int64(point.Stars)}
+	}
+	bytes, err := json.Marshal([][][]int64{cumulative, netPlots})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bytes)
+	return err
+}
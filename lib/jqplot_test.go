@@ -3,6 +3,8 @@ package lib
 import (
 	"bytes"
 	"testing"
+
+	"github.com/evermax/stargraph/lib/history"
 )
 
 func TestWriteJQPlot(t *testing.T) {
@@ -18,3 +20,16 @@ func TestWriteJQPlot(t *testing.T) {
 	}
 
 }
+
+func TestWriteJQPlotNet(t *testing.T) {
+	timestamps := []int64{1234, 5678, 91011}
+	net := []history.Point{{Time: 1234, Stars: 1}, {Time: 5678, Stars: 2}, {Time: 7000, Stars: 1}, {Time: 91011, Stars: 2}}
+	expected := "[[[1234,1],[5678,2],[91011,3]],[[1234,1],[5678,2],[7000,1],[91011,2]]]"
+	var buff bytes.Buffer
+	if err := WriteJQPlotNet(timestamps, net, &buff); err != nil {
+		t.Fatalf("An error occured when writting JQ Plot: %v", err)
+	}
+	if str := buff.String(); str != expected {
+		t.Fatalf("Expected %s, got %s", expected, str)
+	}
+}
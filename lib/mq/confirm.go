@@ -0,0 +1,114 @@
+package mq
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrNacked is returned by Publish when the AMQP server didn't take the message.
+	ErrNacked = fmt.Errorf("The message was rejected by the AMQP server")
+	// ErrConfirmTimeout is returned by Publish when the AMQP server didn't confirm the message in time.
+	// The message may have been taken anyway.
+	ErrConfirmTimeout = fmt.Errorf("The AMQP server didn't confirm the message in time")
+)
+
+// amqpChannel is the channel of a connection. With publisher confirms, its Publish
+// waits for the confirmation of the server.
+type amqpChannel struct {
+	*amqp.Channel
+	confirms *confirmer
+}
+
+// Publish publishes the message, and waits for its confirmation if enabled.
+func (ch *amqpChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if ch.confirms == nil {
+		return ch.Channel.Publish(exchange, key, mandatory, immediate, msg)
+	}
+	return ch.confirms.publish(ch.Channel, exchange, key, mandatory, immediate, msg)
+}
+
+// confirmer matches the confirmations of the server with the messages published on a channel
+// in confirm mode. The server numbers the messages in the order they are published from 1,
+// the delivery tags of the confirmations.
+type confirmer struct {
+	timeout time.Duration
+
+	mtx sync.Mutex
+	// tag is the delivery tag of the last message published
+	tag uint64
+	// waiting holds the publishers waiting for a confirmation by tag, nil once the channel is closed
+	waiting map[uint64]chan bool
+}
+
+func newConfirmer(timeout time.Duration) *confirmer {
+	return &confirmer{
+		timeout: timeout,
+		waiting: make(map[uint64]chan bool),
+	}
+}
+
+// publish publishes the message with the publisher, then waits for its confirmation
+// up to the timeout, for ever if 0. It returns amqp.ErrClosed if the channel is closed
+// before the message is confirmed.
+func (c *confirmer) publish(p publisher, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	// The tags follow the order of the publishings
+	c.mtx.Lock()
+	if c.waiting == nil {
+		c.mtx.Unlock()
+		return amqp.ErrClosed
+	}
+	if err := p.Publish(exchange, key, mandatory, immediate, msg); err != nil {
+		c.mtx.Unlock()
+		return err
+	}
+	c.tag++
+	tag := c.tag
+	confirmed := make(chan bool, 1)
+	c.waiting[tag] = confirmed
+	c.mtx.Unlock()
+
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case ack, ok := <-confirmed:
+		if !ok {
+			return amqp.ErrClosed
+		}
+		if !ack {
+			return ErrNacked
+		}
+		return nil
+	case <-timeout:
+		c.mtx.Lock()
+		delete(c.waiting, tag)
+		c.mtx.Unlock()
+		return ErrConfirmTimeout
+	}
+}
+
+// run passes the confirmations to the publishers waiting for them until the channel is closed,
+// then the publishers still waiting get amqp.ErrClosed.
+func (c *confirmer) run(confirms <-chan amqp.Confirmation) {
+	for confirm := range confirms {
+		c.mtx.Lock()
+		if confirmed, ok := c.waiting[confirm.DeliveryTag]; ok {
+			confirmed <- confirm.Ack
+			delete(c.waiting, confirm.DeliveryTag)
+		}
+		c.mtx.Unlock()
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, confirmed := range c.waiting {
+		close(confirmed)
+	}
+	c.waiting = nil
+}
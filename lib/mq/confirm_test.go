@@ -0,0 +1,89 @@
+package mq
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// publishAll publishes the bodies with the confirmer at once, and returns their errors in order.
+func publishAll(c *confirmer, p publisher, bodies ...string) []chan error {
+	errs := make([]chan error, len(bodies))
+	for i, body := range bodies {
+		errs[i] = make(chan error, 1)
+		go func(body string, errs chan error) {
+			errs <- c.publish(p, "", "add", false, false, amqp.Publishing{Body: []byte(body)})
+		}(body, errs[i])
+		// Published in order
+		for {
+			c.mtx.Lock()
+			published := c.tag == uint64(i+1)
+			c.mtx.Unlock()
+			if published {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return errs
+}
+
+func TestConfirmer(t *testing.T) {
+	c, p := newConfirmer(0), &channel{}
+	confirms := make(chan amqp.Confirmation)
+	go c.run(confirms)
+	errs := publishAll(c, p, "job1", "job2", "job3")
+
+	// Confirmed in any order
+	confirms <- amqp.Confirmation{DeliveryTag: 3, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+	if err := <-errs[2]; err != nil {
+		t.Fatalf("Expected job3 to be confirmed, got %v", err)
+	}
+	if err := <-errs[0]; err != ErrNacked {
+		t.Fatalf("Expected %v for job1, got %v", ErrNacked, err)
+	}
+	select {
+	case err := <-errs[1]:
+		t.Fatalf("job2 should wait for its confirmation, got %v", err)
+	default:
+	}
+
+	// Not confirmed before the channel is closed
+	close(confirms)
+	if err := <-errs[1]; err != amqp.ErrClosed {
+		t.Fatalf("Expected %v for job2, got %v", amqp.ErrClosed, err)
+	}
+	if err := c.publish(p, "", "add", false, false, amqp.Publishing{}); err != amqp.ErrClosed {
+		t.Fatalf("Expected %v once closed, got %v", amqp.ErrClosed, err)
+	}
+	if len(p.published) != 3 {
+		t.Fatalf("Expected 3 messages published, got %d", len(p.published))
+	}
+}
+
+func TestConfirmerTimeout(t *testing.T) {
+	c := newConfirmer(50 * time.Millisecond)
+	confirms := make(chan amqp.Confirmation)
+	go c.run(confirms)
+	defer close(confirms)
+
+	start := time.Now()
+	if err := c.publish(&channel{}, "", "add", false, false, amqp.Publishing{}); err != ErrConfirmTimeout || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("Expected %v after the timeout, got %v after %v", ErrConfirmTimeout, err, time.Since(start))
+	}
+	// A late confirmation is dropped
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+
+	// Not published, nothing to confirm
+	if err := c.publish(&channel{err: fmt.Errorf("Channel closed")}, "", "add", false, false, amqp.Publishing{}); err == nil {
+		t.Fatal("Expected the error of the publisher")
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.tag != 1 || len(c.waiting) != 0 {
+		t.Fatalf("Expected only the first message to be numbered, got %d and %d waiting", c.tag, len(c.waiting))
+	}
+}
@@ -0,0 +1,87 @@
+package mq
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeadLetterQueue returns the name of the dead-letter queue of the queue.
+func DeadLetterQueue(queue string) string {
+	return queue + ".dead"
+}
+
+// RetryQueue returns the name of the queue where the messages of the queue wait for the delay
+// before being retried, for the brokers that implement Retry with a queue per delay.
+// The delay is rounded up to a power of two seconds, so that the jittered and the rate limit
+// delays share a few queues: a queue has a few dozen retry queues at most.
+func RetryQueue(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%ds", queue, retrySeconds(delay))
+}
+
+// retrySeconds returns the delay of the retry queue of the delay, in seconds.
+func retrySeconds(delay time.Duration) int64 {
+	seconds := int64(1)
+	for time.Duration(seconds)*time.Second < delay {
+		seconds *= 2
+	}
+	return seconds
+}
+
+// DeadLetter is a message of a dead-letter queue.
+type DeadLetter struct {
+	Body   []byte
+	Reason string
+}
+
+// Inspect returns at most n messages of the dead-letter queue of the queue, all of them if n <= 0.
+// The messages stay in the dead-letter queue.
+func Inspect(q MessageQueue, queue string, n int) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	var last Delivery
+	var err error
+	for n <= 0 || len(letters) < n {
+		d, ok, getErr := q.Get(DeadLetterQueue(queue))
+		if getErr != nil {
+			err = getErr
+			break
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, DeadLetter{Body: d.Body(), Reason: d.Reason()})
+		last = d
+	}
+	if last != nil {
+		// Put them all back in the dead-letter queue, in order
+		if nackErr := last.Nack(true, true); nackErr != nil && err == nil {
+			err = nackErr
+		}
+	}
+	return letters, err
+}
+
+// Replay publishes at most n messages of the dead-letter queue of the queue back on the queue,
+// all of them if n <= 0, and returns how many were replayed. They are tried again from scratch.
+// The queue is declared first, the services consuming it might not have declared it yet,
+// and a message published on a queue that doesn't exist would be dropped by the server.
+func Replay(q MessageQueue, queue string, n int) (int, error) {
+	if err := q.DeclareQueue(queue); err != nil {
+		return 0, fmt.Errorf("Failed to declare the queue %s: %v", queue, err)
+	}
+	replayed := 0
+	for n <= 0 || replayed < n {
+		d, ok, err := q.Get(DeadLetterQueue(queue))
+		if err != nil || !ok {
+			return replayed, err
+		}
+		if err := q.Publish(queue, d.Body()); err != nil {
+			d.Nack(false, true)
+			return replayed, err
+		}
+		if err := d.Ack(false); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
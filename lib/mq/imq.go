@@ -1,15 +1,26 @@
 package mq
 
+import "time"
+
 // MessageQueue interface help masking the complexity of the amqp library
 // and reduce them to the needs of this project. The interface helps testing
 // and only provide with the ability to declare a queue, publish on a queue
 // and consume the elements coming on a queue.
+//
+// A failed message can be retried later with Retry, which acknowledges it and publishes
+// it again on its queue after the delay, keeping its number of attempts. A message that
+// failed too many times is moved with DeadLetter to the dead-letter queue of its queue,
+// see DeadLetterQueue, where it can be inspected and replayed.
 type MessageQueue interface {
 	DeclareQueue(string) error
 	Publish(string, []byte) error
 	// Consume should block until the underlying connection to is closed
 	// or the Receiver function send a bool to the channel it's passed
 	Consume(string, Receiver) error
+	// Get delivers the next message of the queue, false if the queue is empty.
+	Get(string) (Delivery, bool, error)
+	Retry(queue string, d Delivery, delay time.Duration) error
+	DeadLetter(queue string, d Delivery, reason string) error
 }
 
 // Receiver is a function type that will be called every time
@@ -25,8 +36,15 @@ type Receiver func(Delivery, chan bool)
 // Delivery interface provide a wrapper for the message and acknowledgment
 // system of AMQP. It will allows the read the body of the message and either
 // aknowledge it or non acknowledge it.
+// Redelivered tells whether the message was delivered before and requeued,
+// and Attempts how many times it was delivered, this time included.
+// Reason is why the message was moved to a dead-letter queue, empty if it wasn't.
 type Delivery interface {
 	Body() []byte
 	Ack(bool) error
 	Nack(bool, bool) error
+	Reject(bool) error
+	Redelivered() bool
+	Attempts() int
+	Reason() string
 }
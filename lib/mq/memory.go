@@ -0,0 +1,405 @@
+package mq
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when using a message queue once it is closed.
+var ErrClosed = fmt.Errorf("Message queue closed")
+
+// Memory is a MessageQueue keeping the messages in memory, to run the API
+// and the services in one process, for the local development and the tests.
+// It has the semantics of RabbitMQ: a message is delivered to one consumer of its queue
+// and stays unacknowledged until it is acked or nacked. A message nacked with requeue,
+// or delivered to a consumer that stopped without acknowledging it, is put back
+// at the head of its queue and delivered again with Redelivered true.
+// The retries wait on timers, and the dead-letter queues are declared when needed.
+// It is safe for concurrent use.
+type Memory struct {
+	mtx    sync.Mutex
+	cond   *sync.Cond
+	queues map[string]*memoryQueue
+	// tag is the tag of the last delivery
+	tag uint64
+	// getter holds the deliveries of Get
+	getter *consumer
+	closed bool
+	done   chan struct{}
+}
+
+type memoryQueue struct {
+	ready   []message
+	unacked int
+}
+
+// message is a message published on a queue, delivered attempts times so far.
+// reason is why it was dead-lettered.
+type message struct {
+	body     []byte
+	attempts int
+	reason   string
+}
+
+// consumer holds the deliveries it didn't acknowledge yet, by tag.
+type consumer struct {
+	unacked map[uint64]*MemoryDelivery
+}
+
+func newConsumer() *consumer {
+	return &consumer{unacked: make(map[uint64]*MemoryDelivery)}
+}
+
+// NewMemory creates a Memory message queue without queues.
+func NewMemory() *Memory {
+	m := &Memory{
+		queues: make(map[string]*memoryQueue),
+		getter: newConsumer(),
+		done:   make(chan struct{}),
+	}
+	m.cond = sync.NewCond(&m.mtx)
+	return m
+}
+
+// DeclareQueue creates the queue if it doesn't exist yet.
+func (m *Memory) DeclareQueue(name string) error {
+	if name == "" {
+		return fmt.Errorf("Failed to declare a queue: the name is empty")
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if _, ok := m.queues[name]; !ok {
+		m.queues[name] = &memoryQueue{}
+	}
+	return nil
+}
+
+// Publish adds a message with a copy of the body at the end of the queue.
+// The queue must have been declared.
+func (m *Memory) Publish(name string, body []byte) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	q, err := m.queue(name)
+	if err != nil {
+		return err
+	}
+	q.ready = append(q.ready, message{body: append([]byte(nil), body...)})
+	m.cond.Broadcast()
+	return nil
+}
+
+// Consume calls the Receiver with the messages of the queue, one at a time.
+// It blocks until the Receiver sends to the channel it's passed or the Memory is closed,
+// then waits for the Receiver to return. The messages it was delivered and didn't
+// acknowledge are requeued, like when the channel of a consumer of RabbitMQ is closed.
+func (m *Memory) Consume(name string, r Receiver) error {
+	m.mtx.Lock()
+	_, err := m.queue(name)
+	m.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	c := newConsumer()
+	// The Receiver can stop the consumer without blocking
+	stop := make(chan bool, 1)
+	stopped := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			d, ok := m.next(name, c, stopped)
+			if !ok {
+				return
+			}
+			r(d, stop)
+		}
+	}()
+	select {
+	case <-stop:
+	case <-m.done:
+	}
+	m.mtx.Lock()
+	close(stopped)
+	m.cond.Broadcast()
+	m.mtx.Unlock()
+	<-finished
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.requeue(c)
+	return nil
+}
+
+// next waits for a message on the queue and delivers it to the consumer.
+// It returns false once the consumer is stopped or the Memory closed.
+func (m *Memory) next(name string, c *consumer, stopped chan struct{}) (*MemoryDelivery, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	q := m.queues[name]
+	for {
+		select {
+		case <-stopped:
+			return nil, false
+		default:
+		}
+		if m.closed {
+			return nil, false
+		}
+		if len(q.ready) > 0 {
+			return m.deliver(name, q, c), true
+		}
+		m.cond.Wait()
+	}
+}
+
+// Get delivers the message at the head of the queue, false if the queue is empty.
+// The message has to be acknowledged like the ones given to the consumers.
+func (m *Memory) Get(name string) (Delivery, bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	q, err := m.queue(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(q.ready) == 0 {
+		return nil, false, nil
+	}
+	return m.deliver(name, q, m.getter), true, nil
+}
+
+// deliver gives the message at the head of the queue to the consumer.
+func (m *Memory) deliver(name string, q *memoryQueue, c *consumer) *MemoryDelivery {
+	msg := q.ready[0]
+	q.ready = q.ready[1:]
+	msg.attempts++
+	q.unacked++
+	m.tag++
+	d := &MemoryDelivery{m: m, c: c, queue: name, tag: m.tag, message: msg}
+	c.unacked[d.tag] = d
+	return d
+}
+
+// Retry acknowledges the message and publishes it again at the end of the queue after the delay.
+// The message is lost if the Memory is closed in the meantime.
+func (m *Memory) Retry(name string, d Delivery, delay time.Duration) error {
+	m.mtx.Lock()
+	_, err := m.queue(name)
+	m.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	msg, err := m.take(d)
+	if err != nil {
+		return err
+	}
+	time.AfterFunc(delay, func() {
+		m.mtx.Lock()
+		defer m.mtx.Unlock()
+		if q, err := m.queue(name); err == nil {
+			q.ready = append(q.ready, msg)
+			m.cond.Broadcast()
+		}
+	})
+	return nil
+}
+
+// DeadLetter acknowledges the message and moves it to the dead-letter queue of the queue.
+func (m *Memory) DeadLetter(name string, d Delivery, reason string) error {
+	msg, err := m.take(d)
+	if err != nil {
+		return err
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	dead := DeadLetterQueue(name)
+	if _, ok := m.queues[dead]; !ok {
+		m.queues[dead] = &memoryQueue{}
+	}
+	m.queues[dead].ready = append(m.queues[dead].ready, message{body: msg.body, reason: reason})
+	m.cond.Broadcast()
+	return nil
+}
+
+// take acknowledges the delivery and returns its message.
+func (m *Memory) take(d Delivery) (message, error) {
+	md, ok := d.(*MemoryDelivery)
+	if !ok || md.m != m {
+		return message{}, fmt.Errorf("The delivery %v doesn't come from this message queue", d)
+	}
+	if err := md.Ack(false); err != nil {
+		return message{}, err
+	}
+	return md.message, nil
+}
+
+// Len returns the number of messages of the queue waiting to be delivered.
+func (m *Memory) Len(name string) int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if q, ok := m.queues[name]; ok {
+		return len(q.ready)
+	}
+	return 0
+}
+
+// Unacked returns the number of messages of the queue delivered but not acknowledged yet.
+func (m *Memory) Unacked(name string) int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if q, ok := m.queues[name]; ok {
+		return q.unacked
+	}
+	return 0
+}
+
+// Close stops the consumers, the messages are lost.
+// Using the Memory afterwards returns ErrClosed.
+func (m *Memory) Close() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+	m.cond.Broadcast()
+	return nil
+}
+
+func (m *Memory) queue(name string) (*memoryQueue, error) {
+	if m.closed {
+		return nil, ErrClosed
+	}
+	q, ok := m.queues[name]
+	if !ok {
+		return nil, fmt.Errorf("No queue %s declared", name)
+	}
+	return q, nil
+}
+
+// settle removes the deliveries of the consumer up to the tag, only the one with the tag
+// if not multiple, and returns them sorted by tag.
+func (m *Memory) settle(c *consumer, tag uint64, multiple bool) ([]*MemoryDelivery, error) {
+	if _, ok := c.unacked[tag]; !ok {
+		return nil, fmt.Errorf("The delivery %d is not waiting for an acknowledgment", tag)
+	}
+	var settled []*MemoryDelivery
+	for t, d := range c.unacked {
+		if t == tag || (multiple && t < tag) {
+			settled = append(settled, d)
+		}
+	}
+	sort.Sort(byTag(settled))
+	for _, d := range settled {
+		delete(c.unacked, d.tag)
+		if q, ok := m.queues[d.queue]; ok {
+			q.unacked--
+		}
+	}
+	return settled, nil
+}
+
+// requeue puts the deliveries the consumer didn't acknowledge back at the head of their queues.
+func (m *Memory) requeue(c *consumer) {
+	deliveries := make([]*MemoryDelivery, 0, len(c.unacked))
+	for tag, d := range c.unacked {
+		deliveries = append(deliveries, d)
+		delete(c.unacked, tag)
+		if q, ok := m.queues[d.queue]; ok {
+			q.unacked--
+		}
+	}
+	sort.Sort(byTag(deliveries))
+	m.putBack(deliveries)
+}
+
+// putBack puts the deliveries sorted by tag back at the head of their queues.
+func (m *Memory) putBack(deliveries []*MemoryDelivery) {
+	if m.closed {
+		return
+	}
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		d := deliveries[i]
+		q, ok := m.queues[d.queue]
+		if !ok {
+			continue
+		}
+		q.ready = append([]message{d.message}, q.ready...)
+	}
+	m.cond.Broadcast()
+}
+
+// MemoryDelivery is a message delivered by a Memory message queue.
+// It implements the Delivery interface.
+type MemoryDelivery struct {
+	m     *Memory
+	c     *consumer
+	queue string
+	tag   uint64
+	message
+}
+
+// Body returns the body of the message.
+func (d *MemoryDelivery) Body() []byte {
+	return d.body
+}
+
+// Redelivered tells whether the message was delivered before and requeued.
+func (d *MemoryDelivery) Redelivered() bool {
+	return d.attempts > 1
+}
+
+// Attempts returns how many times the message was delivered, this time included.
+func (d *MemoryDelivery) Attempts() int {
+	return d.attempts
+}
+
+// Ack acknowledges the message, and the previous ones delivered to the same consumer if multiple.
+// It fails if the message was already acknowledged or requeued.
+func (d *MemoryDelivery) Ack(multiple bool) error {
+	d.m.mtx.Lock()
+	defer d.m.mtx.Unlock()
+	_, err := d.m.settle(d.c, d.tag, multiple)
+	return err
+}
+
+// Nack negatively acknowledges the message, and the previous ones delivered to the same
+// consumer if multiple. If requeue, they are put back at the head of their queues,
+// otherwise they are dropped.
+func (d *MemoryDelivery) Nack(multiple, requeue bool) error {
+	d.m.mtx.Lock()
+	defer d.m.mtx.Unlock()
+	settled, err := d.m.settle(d.c, d.tag, multiple)
+	if err != nil {
+		return err
+	}
+	if requeue {
+		d.m.putBack(settled)
+	}
+	return nil
+}
+
+// Reason returns why the message was dead-lettered.
+func (d *MemoryDelivery) Reason() string {
+	return d.reason
+}
+
+// Reject is a Nack of this message only.
+func (d *MemoryDelivery) Reject(requeue bool) error {
+	return d.Nack(false, requeue)
+}
+
+type byTag []*MemoryDelivery
+
+func (s byTag) Len() int           { return len(s) }
+func (s byTag) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTag) Less(i, j int) bool { return s[i].tag < s[j].tag }
@@ -0,0 +1,233 @@
+package mq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryPublishGet(t *testing.T) {
+	m := NewMemory()
+	if err := m.Publish("add", []byte("job")); err == nil {
+		t.Fatal("Publishing on a queue not declared should fail")
+	}
+	if err := m.DeclareQueue(""); err == nil {
+		t.Fatal("Declaring a queue without name should fail")
+	}
+	if err := m.DeclareQueue("add"); err != nil {
+		t.Fatalf("An error occured while declaring the queue: %v", err)
+	}
+	for _, body := range []string{"job1", "job2"} {
+		if err := m.Publish("add", []byte(body)); err != nil {
+			t.Fatalf("An error occured while publishing: %v", err)
+		}
+	}
+	if m.Len("add") != 2 {
+		t.Fatalf("Expected 2 messages, got %d", m.Len("add"))
+	}
+
+	d, ok, err := m.Get("add")
+	if err != nil || !ok || string(d.Body()) != "job1" || d.Redelivered() {
+		t.Fatalf("Expected the first job delivered once, got %v, %v, %v", d, ok, err)
+	}
+	if m.Len("add") != 1 || m.Unacked("add") != 1 {
+		t.Fatalf("Expected 1 message ready and 1 unacked, got %d and %d", m.Len("add"), m.Unacked("add"))
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("An error occured while acknowledging: %v", err)
+	}
+	if err := d.Ack(false); err == nil {
+		t.Fatal("Acknowledging a message twice should fail")
+	}
+	if m.Unacked("add") != 0 {
+		t.Fatalf("Expected no unacked message, got %d", m.Unacked("add"))
+	}
+}
+
+func TestMemoryNack(t *testing.T) {
+	m := NewMemory()
+	m.DeclareQueue("add")
+	for _, body := range []string{"job1", "job2", "job3"} {
+		m.Publish("add", []byte(body))
+	}
+	first, _, _ := m.Get("add")
+	second, _, _ := m.Get("add")
+
+	// Both are requeued at the head of the queue, in order
+	if err := second.Nack(true, true); err != nil {
+		t.Fatalf("An error occured while nacking: %v", err)
+	}
+	if err := first.Ack(false); err == nil {
+		t.Fatal("Acknowledging a requeued message should fail")
+	}
+	for _, expected := range []string{"job1", "job2", "job3"} {
+		d, ok, _ := m.Get("add")
+		if !ok || string(d.Body()) != expected {
+			t.Fatalf("Expected %s, got %v", expected, d)
+		}
+		if d.Redelivered() != (expected != "job3") {
+			t.Fatalf("Expected %s to be redelivered: %v", expected, expected != "job3")
+		}
+		if expected == "job1" {
+			// Requeued once more
+			d.Reject(true)
+			if d, _, _ = m.Get("add"); d.Attempts() != 3 {
+				t.Fatalf("Expected job1 to be delivered 3 times, got %d", d.Attempts())
+			}
+		}
+		// Dropped
+		d.Nack(false, false)
+	}
+	if m.Len("add") != 0 || m.Unacked("add") != 0 {
+		t.Fatalf("Expected the messages to be dropped, got %d ready and %d unacked", m.Len("add"), m.Unacked("add"))
+	}
+}
+
+func TestMemoryConsume(t *testing.T) {
+	m := NewMemory()
+	m.DeclareQueue("add")
+	m.Publish("add", []byte("job1"))
+
+	received := make(chan Delivery)
+	done := make(chan error)
+	go func() {
+		done <- m.Consume("add", func(d Delivery, stop chan bool) {
+			received <- d
+		})
+	}()
+	d := <-received
+	if string(d.Body()) != "job1" {
+		t.Fatalf("Expected job1, got %s", d.Body())
+	}
+	d.Ack(false)
+
+	// The consumer waits for the next message
+	m.Publish("add", []byte("job2"))
+	d = <-received
+	if string(d.Body()) != "job2" {
+		t.Fatalf("Expected job2, got %s", d.Body())
+	}
+	d.Nack(false, true)
+	d = <-received
+	if string(d.Body()) != "job2" || !d.(*MemoryDelivery).Redelivered() {
+		t.Fatalf("Expected job2 to be redelivered, got %s", d.Body())
+	}
+
+	m.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("An error occured while consuming: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Consume should return once the queue is closed")
+	}
+	if err := m.Publish("add", []byte("job3")); err != ErrClosed {
+		t.Fatalf("Expected %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestMemoryConsumeStop(t *testing.T) {
+	m := NewMemory()
+	m.DeclareQueue("add")
+	m.Publish("add", []byte("job1"))
+
+	// The Receiver stops the consumer without acknowledging the message, it is requeued
+	err := m.Consume("add", func(d Delivery, stop chan bool) {
+		stop <- true
+	})
+	if err != nil {
+		t.Fatalf("An error occured while consuming: %v", err)
+	}
+	d, ok, _ := m.Get("add")
+	if !ok || string(d.Body()) != "job1" || !d.Redelivered() {
+		t.Fatalf("Expected job1 to be requeued, got %v", d)
+	}
+	if err := m.Consume("update", func(d Delivery, stop chan bool) {}); err == nil {
+		t.Fatal("Consuming a queue not declared should fail")
+	}
+}
+
+func TestMemoryRetryDeadLetter(t *testing.T) {
+	m := NewMemory()
+	m.DeclareQueue("add")
+	m.Publish("add", []byte("job1"))
+	d, _, _ := m.Get("add")
+	if err := m.Retry("update", d, 0); err == nil {
+		t.Fatal("Retrying on a queue not declared should fail")
+	}
+
+	// Acked at once, back on the queue after the delay with its attempts
+	if err := m.Retry("add", d, 50*time.Millisecond); err != nil {
+		t.Fatalf("An error occured while retrying: %v", err)
+	}
+	if m.Len("add") != 0 || m.Unacked("add") != 0 {
+		t.Fatalf("The message should wait for the delay, got %d ready and %d unacked", m.Len("add"), m.Unacked("add"))
+	}
+	time.Sleep(100 * time.Millisecond)
+	d, ok, _ := m.Get("add")
+	if !ok || d.Attempts() != 2 {
+		t.Fatalf("Expected the message to be retried, got %v", d)
+	}
+
+	if err := m.DeadLetter("add", d, "Failed twice"); err != nil {
+		t.Fatalf("An error occured while dead-lettering: %v", err)
+	}
+	m.Publish("add", []byte("job2"))
+	d, _, _ = m.Get("add")
+	m.DeadLetter("add", d, "Failed once")
+	if m.Len("add") != 0 || m.Unacked("add") != 0 || m.Len(DeadLetterQueue("add")) != 2 {
+		t.Fatalf("Expected the messages to be moved, got %d dead-lettered", m.Len(DeadLetterQueue("add")))
+	}
+
+	letters, err := Inspect(m, "add", 0)
+	if err != nil || len(letters) != 2 || string(letters[0].Body) != "job1" || letters[0].Reason != "Failed twice" || letters[1].Reason != "Failed once" {
+		t.Fatalf("Expected the 2 dead letters in order, got %+v (%v)", letters, err)
+	}
+	if m.Len(DeadLetterQueue("add")) != 2 {
+		t.Fatal("The inspected messages should stay in the dead-letter queue")
+	}
+
+	if n, err := Replay(m, "add", 1); err != nil || n != 1 {
+		t.Fatalf("Expected 1 message replayed, got %d (%v)", n, err)
+	}
+	d, ok, _ = m.Get("add")
+	if !ok || string(d.Body()) != "job1" || d.Attempts() != 1 || d.Reason() != "" {
+		t.Fatalf("Expected job1 to be replayed from scratch, got %v", d)
+	}
+	if m.Len(DeadLetterQueue("add")) != 1 {
+		t.Fatalf("Expected job2 to stay in the dead-letter queue, got %d", m.Len(DeadLetterQueue("add")))
+	}
+}
+
+func TestReplayUndeclaredQueue(t *testing.T) {
+	// The services consuming the queue are not started
+	m := NewMemory()
+	m.DeclareQueue(DeadLetterQueue("add"))
+	m.Publish(DeadLetterQueue("add"), []byte("job1"))
+
+	if n, err := Replay(m, "add", 0); err != nil || n != 1 {
+		t.Fatalf("Expected 1 message replayed, got %d (%v)", n, err)
+	}
+	if d, ok, _ := m.Get("add"); !ok || string(d.Body()) != "job1" {
+		t.Fatalf("Expected job1 to be replayed on the queue, got %v", d)
+	}
+}
+
+func TestRetryQueue(t *testing.T) {
+	tests := []struct {
+		delay    time.Duration
+		expected string
+	}{
+		{0, "add.retry.1s"},
+		{time.Second, "add.retry.1s"},
+		{1500 * time.Millisecond, "add.retry.2s"},
+		{3 * time.Second, "add.retry.4s"},
+		{time.Minute, "add.retry.64s"},
+		{time.Hour, "add.retry.4096s"},
+	}
+	for _, test := range tests {
+		if name := RetryQueue("add", test.delay); name != test.expected {
+			t.Fatalf("Expected %s for %v, got %s", test.expected, test.delay, name)
+		}
+	}
+}
@@ -4,38 +4,287 @@ package mq
 
 import (
 	"fmt"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/streadway/amqp"
 )
 
+// PublishPolicy tells what the calls to a MQ do while it is disconnected from the AMQP server.
+type PublishPolicy int
+
+const (
+	// FailFast returns ErrDisconnected at once.
+	FailFast PublishPolicy = iota
+	// BlockPublish waits for the MQ to reconnect, up to the PublishTimeout.
+	BlockPublish
+)
+
+// ErrDisconnected is returned by a MQ with the FailFast policy while it is disconnected
+// from the AMQP server, or with the BlockPublish policy once the PublishTimeout is over.
+var ErrDisconnected = fmt.Errorf("Disconnected from the AMQP server")
+
+// Options of a MQ.
+// The delay before reconnecting starts at ReconnectDelay and doubles after each failure,
+// up to MaxReconnectDelay. A PublishTimeout of 0 makes BlockPublish wait as long as needed.
+// With Confirm, the channels are in confirm mode: Publish waits for the server to confirm
+// it took the message, up to the ConfirmTimeout, and fails with ErrNacked if it didn't.
+// The messages requeued, retried and dead-lettered are confirmed before being acknowledged.
+type Options struct {
+	Policy            PublishPolicy
+	PublishTimeout    time.Duration
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+	Confirm           bool
+	ConfirmTimeout    time.Duration
+}
+
+// DefaultOptions fail fast while disconnected and try to reconnect every second at first,
+// every minute at most.
+var DefaultOptions = Options{
+	Policy:            FailFast,
+	ReconnectDelay:    time.Second,
+	MaxReconnectDelay: time.Minute,
+}
+
+// nextDelay returns the delay before the next attempt to reconnect after waiting delay.
+func (o Options) nextDelay(delay time.Duration) time.Duration {
+	if delay *= 2; delay <= 0 || delay > o.MaxReconnectDelay {
+		return o.MaxReconnectDelay
+	}
+	return delay
+}
+
 // MQ struct is compliant to the MessageQueue interface.
+// It watches its connection to the AMQP server and when it is closed, by a restart of the
+// server or a network failure, it reconnects, declares the queues again and the consumers
+// started by Consume consume again. The messages they didn't acknowledge are redelivered
+// by the server. It is safe for concurrent use.
 type MQ struct {
-	Conn    *amqp.Connection
-	Channel *amqp.Channel
+	url  string
+	opts Options
+
+	mtx     sync.Mutex
+	conn    *amqp.Connection
+	channel *amqpChannel
+	// ready is closed once connected, and replaced when disconnected
+	ready chan struct{}
+	// queues are the queues declared, to declare them again when reconnecting
+	queues []string
+	closed bool
+	done   chan struct{}
+}
+
+// NewMQ connects to the AMQP server with the DefaultOptions.
+func NewMQ(amqpURL string) (*MQ, error) {
+	return Dial(amqpURL, DefaultOptions)
+}
+
+// Dial open a connection to AMQP server, open a channel of communication
+// and then return a MQ holding them, which reconnects according to the options.
+// It fails if the first connection fails.
+func Dial(amqpURL string, opts Options) (*MQ, error) {
+	mq := newMQ(amqpURL, opts)
+	if err := mq.connect(); err != nil {
+		return nil, err
+	}
+	return mq, nil
+}
+
+// newMQ returns a MQ not connected yet.
+func newMQ(amqpURL string, opts Options) *MQ {
+	return &MQ{
+		url:   amqpURL,
+		opts:  opts,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
 }
 
-// NewMQ open a connection to AMQP server, open a channel
-// of communication and then return a MQ struct
-// holding the connection and the channel.
-func NewMQ(amqpURL string) (mq MQ, err error) {
-	conn, err := amqp.Dial(amqpURL)
+// connect opens a connection and a channel, declares the queues on it and watches them.
+func (mq *MQ) connect() error {
+	conn, err := amqp.Dial(mq.url)
+	if err != nil {
+		return err
+	}
+	channel, err := conn.Channel()
+	ch := &amqpChannel{Channel: channel}
+	if err == nil {
+		err = ch.Qos(
+			1,     // prefetch count
+			0,     // prefetch size
+			false, // global
+		)
+	}
+	if err == nil && mq.opts.Confirm {
+		if err = ch.Confirm(false); err == nil {
+			ch.confirms = newConfirmer(mq.opts.ConfirmTimeout)
+			go ch.confirms.run(ch.NotifyPublish(make(chan amqp.Confirmation, 16)))
+		}
+	}
+	mq.mtx.Lock()
+	queues := append([]string(nil), mq.queues...)
+	mq.mtx.Unlock()
+	for i := 0; err == nil && i < len(queues); i++ {
+		err = declare(ch, queues[i])
+	}
 	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	mq.mtx.Lock()
+	defer mq.mtx.Unlock()
+	if mq.closed {
+		conn.Close()
+		return ErrClosed
+	}
+	mq.conn, mq.channel = conn, ch
+	close(mq.ready)
+	go mq.watch(conn, ch)
+	return nil
+}
+
+// watch waits for the connection or the channel to be closed, then reconnects
+// until it succeeds or the MQ is closed.
+func (mq *MQ) watch(conn *amqp.Connection, ch *amqpChannel) {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	var err *amqp.Error
+	select {
+	case err = <-connClosed:
+	case err = <-chClosed:
+	case <-mq.done:
 		return
 	}
-	ch, err := conn.Channel()
 	if err != nil {
+		log.Printf("The connection to the AMQP server was closed: %v", err)
+	}
+	mq.lost(ch)
+
+	delay := mq.opts.ReconnectDelay
+	for {
+		select {
+		case <-mq.done:
+			return
+		case <-time.After(delay):
+		}
+		err := mq.connect()
+		if err == nil || err == ErrClosed {
+			return
+		}
+		log.Printf("An error occured while reconnecting to the AMQP server: %v", err)
+		delay = mq.opts.nextDelay(delay)
+	}
+}
+
+// lost closes the connection of the channel if it is the current one,
+// the calls wait or fail until reconnected.
+func (mq *MQ) lost(ch *amqpChannel) {
+	mq.mtx.Lock()
+	if ch == nil || ch != mq.channel {
+		mq.mtx.Unlock()
 		return
 	}
-	mq = MQ{
-		Conn:    conn,
-		Channel: ch,
+	conn := mq.conn
+	mq.conn, mq.channel = nil, nil
+	mq.ready = make(chan struct{})
+	mq.mtx.Unlock()
+	conn.Close()
+}
+
+// current returns the channel, waiting for the connection according to the policy if block.
+func (mq *MQ) current(block bool, deadline <-chan time.Time) (*amqpChannel, error) {
+	for {
+		mq.mtx.Lock()
+		closed, ch, ready := mq.closed, mq.channel, mq.ready
+		mq.mtx.Unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+		if ch != nil {
+			return ch, nil
+		}
+		if !block {
+			return nil, ErrDisconnected
+		}
+		select {
+		case <-ready:
+		case <-mq.done:
+			return nil, ErrClosed
+		case <-deadline:
+			return nil, ErrDisconnected
+		}
+	}
+}
+
+// do calls f with the channel. With the BlockPublish policy, it waits for the connection
+// and calls f again if the channel was closed meanwhile, until the PublishTimeout.
+func (mq *MQ) do(f func(*amqpChannel) error) error {
+	block := mq.opts.Policy == BlockPublish
+	var deadline <-chan time.Time
+	if block && mq.opts.PublishTimeout > 0 {
+		timer := time.NewTimer(mq.opts.PublishTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		ch, err := mq.current(block, deadline)
+		if err != nil {
+			return err
+		}
+		err = f(ch)
+		if err != amqp.ErrClosed {
+			return err
+		}
+		mq.lost(ch)
+		if !block {
+			return ErrDisconnected
+		}
 	}
-	return
 }
 
-// DeclareQueue declare a queue an set QoS
-func (mq MQ) DeclareQueue(queueName string) error {
-	_, err := mq.Channel.QueueDeclare(
+// Close closes the connection, the consumers return.
+// Using the MQ afterwards returns ErrClosed.
+func (mq *MQ) Close() error {
+	mq.mtx.Lock()
+	if mq.closed {
+		mq.mtx.Unlock()
+		return nil
+	}
+	mq.closed = true
+	close(mq.done)
+	conn := mq.conn
+	mq.conn, mq.channel = nil, nil
+	mq.mtx.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// DeclareQueue declare a queue, declared again after reconnecting.
+func (mq *MQ) DeclareQueue(queueName string) error {
+	err := mq.do(func(ch *amqpChannel) error {
+		return declare(ch, queueName)
+	})
+	if err != nil {
+		return err
+	}
+	mq.mtx.Lock()
+	defer mq.mtx.Unlock()
+	for _, q := range mq.queues {
+		if q == queueName {
+			return nil
+		}
+	}
+	mq.queues = append(mq.queues, queueName)
+	return nil
+}
+
+func declare(ch *amqpChannel, queueName string) error {
+	_, err := ch.QueueDeclare(
 		queueName, // name
 		true,      // durable
 		false,     // delete when unused
@@ -43,37 +292,68 @@ func (mq MQ) DeclareQueue(queueName string) error {
 		false,     // no-wait
 		nil,       // arguments
 	)
-
+	if err == amqp.ErrClosed {
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("Failed to declare a queue: %v", err)
 	}
-
-	return mq.Channel.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
-	)
+	return nil
 }
 
 // Publish provide a way to publish a message containing
-// the provided body to the queue with name queueName
-func (mq MQ) Publish(queueName string, body []byte) error {
-	return mq.Channel.Publish(
-		"",        // exchange
-		queueName, // routing key
-		false,
-		false,
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         body,
-		})
+// the provided body to the queue with name queueName.
+// While disconnected, it fails or waits according to the policy.
+// With Confirm, it returns once the server confirmed it took the message.
+func (mq *MQ) Publish(queueName string, body []byte) error {
+	return mq.do(func(ch *amqpChannel) error {
+		return ch.Publish(
+			"",        // exchange
+			queueName, // routing key
+			false,
+			false,
+			amqp.Publishing{
+				DeliveryMode: amqp.Persistent,
+				ContentType:  "application/json",
+				Body:         body,
+			})
+	})
 }
 
 // Consume will start listening to the message queue using the provided queue name.
-// It will call the Receiver function every time a message arrives.
-func (mq MQ) Consume(queueName string, r Receiver) error {
-	msgs, err := mq.Channel.Consume(
+// It will call the Receiver function every time a message arrives, until the Receiver
+// sends to the channel it's passed or the MQ is closed. It fails if it can't start
+// consuming, afterwards it waits for the MQ to reconnect and consumes again.
+func (mq *MQ) Consume(queueName string, r Receiver) error {
+	ch, err := mq.current(mq.opts.Policy == BlockPublish, nil)
+	if err != nil {
+		return err
+	}
+	msgs, err := consume(ch, queueName)
+	if err != nil {
+		return err
+	}
+	// The Receiver can stop the consumer without blocking
+	stop := make(chan bool, 1)
+	for mq.receive(ch, msgs, r, stop) {
+		// The channel was closed
+		mq.lost(ch)
+		for {
+			if ch, err = mq.current(true, nil); err != nil {
+				return nil
+			}
+			if msgs, err = consume(ch, queueName); err == nil {
+				break
+			}
+			log.Printf("An error occured while consuming %s again: %v", queueName, err)
+			mq.lost(ch)
+		}
+	}
+	return nil
+}
+
+func consume(ch *amqpChannel, queueName string) (<-chan amqp.Delivery, error) {
+	return ch.Consume(
 		queueName, // queue
 		"",        // consumer
 		false,     // auto-ack
@@ -82,28 +362,132 @@ func (mq MQ) Consume(queueName string, r Receiver) error {
 		false,     // no-wait
 		nil,       // args
 	)
+}
+
+// receive calls the Receiver with the messages until they are closed with the channel,
+// then returns true, or until the consumer is stopped, then returns false.
+func (mq *MQ) receive(ch *amqpChannel, msgs <-chan amqp.Delivery, r Receiver, stop chan bool) bool {
+	for {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+		select {
+		case d, ok := <-msgs:
+			if !ok {
+				return true
+			}
+			r(Message{delivery: d, publisher: ch}, stop)
+		case <-stop:
+			return false
+		case <-mq.done:
+			return false
+		}
+	}
+}
+
+const (
+	// AttemptsHeader is the header counting the previous deliveries of a message requeued by Nack or Retry.
+	AttemptsHeader = "x-stargraph-attempts"
+	// ReasonHeader is the header with the reason why a message was dead-lettered.
+	ReasonHeader = "x-stargraph-reason"
+)
+
+// publisher publishes the messages requeued, it is an *amqpChannel.
+type publisher interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// Get delivers the next message of the queue, false if the queue is empty.
+func (mq *MQ) Get(queueName string) (Delivery, bool, error) {
+	var msg Message
+	var ok bool
+	err := mq.do(func(ch *amqpChannel) error {
+		d, got, err := ch.Get(queueName, false)
+		msg, ok = Message{delivery: d, publisher: ch}, got
+		return err
+	})
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return msg, true, nil
+}
+
+// Retry publishes the message on the RetryQueue of the queue and its delay, and acknowledges it.
+// The retry queue has no consumer: the message expires after the delay, rounded up like the name
+// of the queue, and is dead-lettered back to the queue by the broker.
+// The unused retry queues are deleted an hour after their delay.
+// It doesn't wait while disconnected: the message is redelivered by the server anyway.
+func (mq *MQ) Retry(queueName string, d Delivery, delay time.Duration) error {
+	ch, err := mq.current(false, nil)
 	if err != nil {
 		return err
 	}
-	forever := make(chan bool)
-	go func() {
-		for d := range msgs {
-			msg := Message{
-				delivery: d,
-			}
-			r(msg, forever)
+	ttl := retrySeconds(delay) * 1000
+	retryQueue := RetryQueue(queueName, delay)
+	_, err = ch.QueueDeclare(retryQueue, true, false, false, false, amqp.Table{
+		"x-message-ttl":             ttl,
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queueName,
+		"x-expires":                 ttl + int64(time.Hour/time.Millisecond),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to declare the retry queue: %v", err)
+	}
+	return move(ch, retryQueue, d, amqp.Table{AttemptsHeader: int32(d.Attempts())})
+}
+
+// DeadLetter publishes the message on the dead-letter queue of the queue with the reason
+// in the ReasonHeader, and acknowledges it. Like Retry, it doesn't wait while disconnected.
+func (mq *MQ) DeadLetter(queueName string, d Delivery, reason string) error {
+	ch, err := mq.current(false, nil)
+	if err != nil {
+		return err
+	}
+	deadQueue := DeadLetterQueue(queueName)
+	if _, err := ch.QueueDeclare(deadQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare the dead-letter queue: %v", err)
+	}
+	return move(ch, deadQueue, d, amqp.Table{ReasonHeader: reason, AttemptsHeader: nil})
+}
+
+// move publishes the message on the queue with its headers changed by the ones given,
+// removed if nil, and acknowledges it.
+func move(p publisher, queueName string, d Delivery, changes amqp.Table) error {
+	m, ok := d.(Message)
+	if !ok {
+		return fmt.Errorf("The delivery %v doesn't come from RabbitMQ", d)
+	}
+	headers := amqp.Table{}
+	for k, v := range m.delivery.Headers {
+		headers[k] = v
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(headers, k)
+		} else {
+			headers[k] = v
 		}
-		forever <- true
-	}()
-	<-forever
-	return nil
+	}
+	err := p.Publish("", queueName, false, false, amqp.Publishing{
+		Headers:      headers,
+		DeliveryMode: amqp.Persistent,
+		ContentType:  m.delivery.ContentType,
+		Body:         m.delivery.Body,
+	})
+	if err != nil {
+		return err
+	}
+	return m.delivery.Ack(false)
 }
 
 // Message is the wrapper for the Delivery struct of the github.com/streadway/amqp library.
 // Its purpose is to met the be compliant with the Delivery interface in this package (mq)
 // and help making the rest of the project testable.
 type Message struct {
-	delivery amqp.Delivery
+	delivery  amqp.Delivery
+	publisher publisher
 }
 
 // Body will return the body of the message.
@@ -114,13 +498,83 @@ func (m Message) Body() []byte {
 // Ack delivers an acknowledgment that the message has been receive and treated.
 // The multiple argument is true when the all the previous messages can be acknowledged as well.
 func (m Message) Ack(multiple bool) error {
-	return nil
+	return m.delivery.Ack(multiple)
 }
 
 // Nack delivers a negative acknowledgment signifying a failure in treating the message.
 // If multiple is true, all the previous messages that weren't aknowledged yet are going
 // to be negatively aknowledged.
 // If requeue is true, it means that the message needs to be requeued.
+// The classic queues of RabbitMQ don't count the deliveries, so a single message is
+// requeued by publishing it again at the end of its queue with the AttemptsHeader,
+// then acknowledging it.
 func (m Message) Nack(multiple, requeue bool) error {
+	if requeue && !multiple {
+		return m.requeue()
+	}
+	return m.delivery.Nack(multiple, requeue)
+}
+
+// Reject delivers a negative acknowledgment of this message only,
+// requeued like with Nack if requeue is true.
+func (m Message) Reject(requeue bool) error {
+	if requeue {
+		return m.requeue()
+	}
+	return m.delivery.Reject(false)
+}
+
+// requeue publishes the message again with its number of attempts, then acknowledges it.
+func (m Message) requeue() error {
+	if m.publisher == nil {
+		return m.delivery.Nack(false, true)
+	}
+	err := move(m.publisher, m.delivery.RoutingKey, m, amqp.Table{AttemptsHeader: int32(m.Attempts())})
+	if err != nil {
+		// Let the broker requeue it, the attempt isn't counted
+		return m.delivery.Nack(false, true)
+	}
 	return nil
 }
+
+// Redelivered tells whether the message was delivered before.
+func (m Message) Redelivered() bool {
+	return m.delivery.Redelivered || m.Attempts() > 1
+}
+
+// Attempts returns how many times the message was delivered, this time included.
+// It is counted by the quorum queues in the x-delivery-count header, and by Nack in
+// the AttemptsHeader. Otherwise it is 2 if the message was redelivered, 1 if not.
+func (m Message) Attempts() int {
+	previous := 0
+	for _, header := range []string{"x-delivery-count", AttemptsHeader} {
+		if n, ok := toInt(m.delivery.Headers[header]); ok && n > previous {
+			previous = n
+		}
+	}
+	if previous == 0 && m.delivery.Redelivered {
+		previous = 1
+	}
+	return previous + 1
+}
+
+// Reason returns why the message was dead-lettered, from the ReasonHeader.
+func (m Message) Reason() string {
+	reason, _ := m.delivery.Headers[ReasonHeader].(string)
+	return reason
+}
+
+// toInt converts the integer value of a header.
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	}
+	return 0, false
+}
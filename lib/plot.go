@@ -9,11 +9,19 @@ import (
 	"gonum.org/v1/plot/vg"
 	"gonum.org/v1/plot/vg/draw"
 	"gonum.org/v1/plot/vg/vgimg"
+
+	"github.com/evermax/stargraph/lib/history"
 )
 
 const dpi = 96
 
 func PlotGraph(title string, timestamps []int64, w io.Writer) error {
+	return PlotGraphNet(title, timestamps, nil, w)
+}
+
+// PlotGraphNet plots the net stars over time, that go down when stargazers
+// unstarred the repository, alongside the cumulative stars.
+func PlotGraphNet(title string, timestamps []int64, net []history.Point, w io.Writer) error {
 	p, err := plot.New()
 	if err != nil {
 		return err
@@ -29,7 +37,16 @@ func PlotGraph(title string, timestamps []int64, w io.Writer) error {
 		points[i].X = float64(timestamp)
 		points[i].Y = float64(i + 1)
 	}
-	plotutil.AddLinePoints(p, "Stars", points)
+	if len(net) == 0 {
+		plotutil.AddLinePoints(p, "Stars", points)
+	} else {
+		netPoints := make(plotter.XYs, len(net))
+		for i, point := range net {
+			netPoints[i].X = float64(point.Time)
+			netPoints[i].Y = float64(point.Stars)
+		}
+		plotutil.AddLinePoints(p, "Stars", points, "Net stars", netPoints)
+	}
 
 	c := vgimg.New(4*vg.Inch, 4*vg.Inch)
 	cpng := vgimg.PngCanvas{Canvas: c}
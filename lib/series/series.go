@@ -0,0 +1,309 @@
+// Package series stores the timestamps of the stars of a repository apart from it,
+// in chunks of at most ChunkSize timestamps compressed with a delta and varint encoding.
+// A sorted series of timestamps takes one or two bytes per star instead of eight,
+// and the Start, End and Count of the chunks allow to read a time range or
+// aggregates without decoding the whole series.
+package series
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// ChunkSize is the maximum number of timestamps in a chunk.
+const ChunkSize = 1000
+
+// MaxBuckets is the maximum number of buckets returned by Aggregate.
+const MaxBuckets = 1 << 20
+
+var (
+	// ErrOutOfOrder is returned when appending timestamps before the end of the series.
+	ErrOutOfOrder = fmt.Errorf("Timestamps appended before the end of the series")
+	// ErrCorrupted is returned when the data of a chunk can't be decoded.
+	ErrCorrupted = fmt.Errorf("Corrupted chunk of timestamps")
+	// ErrTooManyBuckets is returned by Aggregate when the stars span more than MaxBuckets intervals.
+	ErrTooManyBuckets = fmt.Errorf("Too many buckets, the interval is too small for the range")
+)
+
+// Chunk is a part of the series of timestamps of a repository.
+// Seq is its position in the series, starting at 0, Start and End are its
+// first and last timestamps and Data holds the Count timestamps encoded.
+type Chunk struct {
+	Seq   int
+	Start int64
+	End   int64
+	Count int
+	Data  []byte
+}
+
+// Full tells whether no more timestamps can be appended to the chunk.
+func (c Chunk) Full() bool {
+	return c.Count >= ChunkSize
+}
+
+// Timestamps decodes the timestamps of the chunk.
+func (c Chunk) Timestamps() ([]int64, error) {
+	return Decode(c.Data, c.Count)
+}
+
+// MarshalBinary encodes the chunk, Seq excepted, for the stores keeping it as a blob.
+func (c Chunk) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 3*binary.MaxVarintLen64, 3*binary.MaxVarintLen64+len(c.Data))
+	n := binary.PutUvarint(b, uint64(c.Count))
+	n += binary.PutVarint(b[n:], c.Start)
+	n += binary.PutVarint(b[n:], c.End)
+	return append(b[:n], c.Data...), nil
+}
+
+// UnmarshalBinary decodes a chunk encoded with MarshalBinary, Seq is left untouched.
+func (c *Chunk) UnmarshalBinary(b []byte) error {
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return ErrCorrupted
+	}
+	b = b[n:]
+	start, n := binary.Varint(b)
+	if n <= 0 {
+		return ErrCorrupted
+	}
+	b = b[n:]
+	end, n := binary.Varint(b)
+	if n <= 0 {
+		return ErrCorrupted
+	}
+	c.Count, c.Start, c.End = int(count), start, end
+	c.Data = append([]byte(nil), b[n:]...)
+	return nil
+}
+
+// Encode encodes sorted timestamps: the first one as a varint
+// and the others as the unsigned varint of the difference with the previous one.
+func Encode(timestamps []int64) []byte {
+	b := make([]byte, 0, len(timestamps)*2)
+	buf := make([]byte, binary.MaxVarintLen64)
+	var previous int64
+	for i, timestamp := range timestamps {
+		var n int
+		if i == 0 {
+			n = binary.PutVarint(buf, timestamp)
+		} else {
+			n = binary.PutUvarint(buf, uint64(timestamp-previous))
+		}
+		b = append(b, buf[:n]...)
+		previous = timestamp
+	}
+	return b
+}
+
+// Decode decodes the count timestamps encoded with Encode.
+func Decode(b []byte, count int) ([]int64, error) {
+	timestamps := make([]int64, 0, count)
+	var previous int64
+	for len(b) > 0 {
+		var n int
+		if len(timestamps) == 0 {
+			previous, n = binary.Varint(b)
+		} else {
+			var delta uint64
+			delta, n = binary.Uvarint(b)
+			previous += int64(delta)
+		}
+		if n <= 0 {
+			return nil, ErrCorrupted
+		}
+		timestamps = append(timestamps, previous)
+		b = b[n:]
+	}
+	if len(timestamps) != count {
+		return nil, ErrCorrupted
+	}
+	return timestamps, nil
+}
+
+// NewChunk encodes at most ChunkSize sorted timestamps in the chunk at the position seq.
+func NewChunk(seq int, timestamps []int64) Chunk {
+	return Chunk{
+		Seq:   seq,
+		Start: timestamps[0],
+		End:   timestamps[len(timestamps)-1],
+		Count: len(timestamps),
+		Data:  Encode(timestamps),
+	}
+}
+
+// Append appends the timestamps to the series ending with the last chunk, nil if the series is empty.
+// It returns the chunks to write: if the last chunk was not full, the first one replaces it,
+// the others come after it. The timestamps are sorted, but they can't be before the end
+// of the series, ErrOutOfOrder is returned if they are.
+func Append(last *Chunk, timestamps []int64) ([]Chunk, error) {
+	if len(timestamps) == 0 {
+		return nil, nil
+	}
+	sorted := append([]int64(nil), timestamps...)
+	sort.Sort(int64s(sorted))
+
+	seq := 0
+	if last != nil {
+		if sorted[0] < last.End {
+			return nil, ErrOutOfOrder
+		}
+		seq = last.Seq + 1
+		if !last.Full() {
+			// Fill the last chunk up first
+			previous, err := last.Timestamps()
+			if err != nil {
+				return nil, err
+			}
+			sorted = append(previous, sorted...)
+			seq = last.Seq
+		}
+	}
+
+	chunks := make([]Chunk, 0, len(sorted)/ChunkSize+1)
+	for len(sorted) > 0 {
+		n := len(sorted)
+		if n > ChunkSize {
+			n = ChunkSize
+		}
+		chunks = append(chunks, NewChunk(seq, sorted[:n]))
+		sorted = sorted[n:]
+		seq++
+	}
+	return chunks, nil
+}
+
+// Overlaps tells whether the chunk has timestamps in [from, to).
+func (c Chunk) Overlaps(from, to int64) bool {
+	return c.Count > 0 && c.End >= from && c.Start < to
+}
+
+// Range returns the timestamps of the chunks in [from, to), sorted.
+// Only the chunks overlapping the range are decoded.
+func Range(chunks []Chunk, from, to int64) ([]int64, error) {
+	var timestamps []int64
+	for _, chunk := range chunks {
+		if !chunk.Overlaps(from, to) {
+			continue
+		}
+		decoded, err := chunk.Timestamps()
+		if err != nil {
+			return nil, err
+		}
+		for _, timestamp := range decoded {
+			if timestamp >= from && timestamp < to {
+				timestamps = append(timestamps, timestamp)
+			}
+		}
+	}
+	return timestamps, nil
+}
+
+// Count returns the number of timestamps of the chunks in [from, to).
+// The chunks entirely in the range are counted without being decoded.
+func Count(chunks []Chunk, from, to int64) (int, error) {
+	count := 0
+	for _, chunk := range chunks {
+		if !chunk.Overlaps(from, to) {
+			continue
+		}
+		if chunk.Start >= from && chunk.End < to {
+			count += chunk.Count
+			continue
+		}
+		timestamps, err := Range([]Chunk{chunk}, from, to)
+		if err != nil {
+			return 0, err
+		}
+		count += len(timestamps)
+	}
+	return count, nil
+}
+
+// Bucket is the number of stars between Start and the Start of the next bucket.
+type Bucket struct {
+	Start int64 `json:"start"`
+	Count int   `json:"count"`
+}
+
+// Aggregate counts the timestamps of the chunks in [from, to) in buckets of interval seconds,
+// aligned on from. The empty buckets before the first star and after the last one are not returned,
+// so the range can be as wide as [store.AllFrom, store.AllTo). If the stars span more
+// than MaxBuckets intervals, it returns ErrTooManyBuckets.
+// The chunks entirely in a bucket are counted without being decoded.
+func Aggregate(chunks []Chunk, from, to, interval int64) ([]Bucket, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("The interval must be positive, got %d", interval)
+	}
+	first, last, ok := bounds(chunks, from, to)
+	if !ok {
+		return []Bucket{}, nil
+	}
+	// The differences are computed on uint64, they overflow int64 for the wide ranges
+	step := uint64(interval)
+	if first > from {
+		from = int64(uint64(from) + (uint64(first)-uint64(from))/step*step)
+	}
+	if last < to-1 {
+		to = last + 1
+	}
+	count := (uint64(to)-uint64(from)-1)/step + 1
+	if count > MaxBuckets {
+		return nil, ErrTooManyBuckets
+	}
+
+	buckets := make([]Bucket, count)
+	for i := range buckets {
+		buckets[i].Start = int64(uint64(from) + uint64(i)*step)
+	}
+	index := func(timestamp int64) uint64 {
+		return (uint64(timestamp) - uint64(from)) / step
+	}
+	for _, chunk := range chunks {
+		if !chunk.Overlaps(from, to) {
+			continue
+		}
+		if chunk.Start >= from && chunk.End < to && index(chunk.Start) == index(chunk.End) {
+			buckets[index(chunk.Start)].Count += chunk.Count
+			continue
+		}
+		timestamps, err := Range([]Chunk{chunk}, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, timestamp := range timestamps {
+			buckets[index(timestamp)].Count++
+		}
+	}
+	return buckets, nil
+}
+
+// bounds returns the first Start and the last End of the chunks overlapping [from, to),
+// clamped to the range, false if there are none.
+func bounds(chunks []Chunk, from, to int64) (first, last int64, ok bool) {
+	for _, chunk := range chunks {
+		if !chunk.Overlaps(from, to) {
+			continue
+		}
+		if !ok || chunk.Start < first {
+			first = chunk.Start
+		}
+		if !ok || chunk.End > last {
+			last = chunk.End
+		}
+		ok = true
+	}
+	if first < from {
+		first = from
+	}
+	if last >= to {
+		last = to - 1
+	}
+	return first, last, ok
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
@@ -0,0 +1,249 @@
+package series
+
+import (
+	"math"
+	"testing"
+)
+
+// stars returns n timestamps, one every step seconds from start.
+func stars(start int64, n int, step int64) []int64 {
+	timestamps := make([]int64, n)
+	for i := range timestamps {
+		timestamps[i] = start + int64(i)*step
+	}
+	return timestamps
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		timestamps []int64
+		size       int
+	}{
+		{timestamps: []int64{}, size: 0},
+		{timestamps: []int64{1446285600}, size: 5},
+		// Stars an hour apart take 2 bytes each
+		{timestamps: stars(1446285600, 100, 3600), size: 5 + 99*2},
+		// The same second twice
+		{timestamps: []int64{1446285600, 1446285600, 1446285601}, size: 7},
+		{timestamps: []int64{-10, 0, 10}, size: 3},
+	}
+	for i, test := range tests {
+		data := Encode(test.timestamps)
+		if len(data) != test.size {
+			t.Fatalf("Test %d: expected %d bytes, got %d", i, test.size, len(data))
+		}
+		decoded, err := Decode(data, len(test.timestamps))
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while decoding: %v", i, err)
+		}
+		if !equal(decoded, test.timestamps) {
+			t.Fatalf("Test %d: expected %v, got %v", i, test.timestamps, decoded)
+		}
+	}
+}
+
+func TestDecodeCorrupted(t *testing.T) {
+	data := Encode([]int64{1446285600, 1446289200})
+	if _, err := Decode(data, 3); err != ErrCorrupted {
+		t.Fatalf("Expected %v with a wrong count, got %v", ErrCorrupted, err)
+	}
+	if _, err := Decode(append(data, 0x80), 3); err != ErrCorrupted {
+		t.Fatalf("Expected %v with a truncated varint, got %v", ErrCorrupted, err)
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	chunk := NewChunk(3, stars(1446285600, 10, 60))
+	b, err := chunk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("An error occured while encoding the chunk: %v", err)
+	}
+	decoded := Chunk{Seq: 3}
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatalf("An error occured while decoding the chunk: %v", err)
+	}
+	if decoded.Start != chunk.Start || decoded.End != chunk.End || decoded.Count != chunk.Count || string(decoded.Data) != string(chunk.Data) {
+		t.Fatalf("Expected %+v, got %+v", chunk, decoded)
+	}
+	if err := decoded.UnmarshalBinary(nil); err != ErrCorrupted {
+		t.Fatalf("Expected %v, got %v", ErrCorrupted, err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	var series []Chunk
+	var expected []int64
+	appendStars := func(timestamps []int64) {
+		var last *Chunk
+		if len(series) > 0 {
+			last = &series[len(series)-1]
+		}
+		chunks, err := Append(last, timestamps)
+		if err != nil {
+			t.Fatalf("An error occured while appending: %v", err)
+		}
+		for _, chunk := range chunks {
+			if chunk.Seq < len(series) {
+				series[chunk.Seq] = chunk
+			} else {
+				series = append(series, chunk)
+			}
+		}
+		expected = append(expected, timestamps...)
+	}
+
+	appendStars(stars(1000, 10, 1))
+	if len(series) != 1 || series[0].Count != 10 {
+		t.Fatalf("Expected one chunk of 10 timestamps, got %+v", series)
+	}
+	// Fills the first chunk up and adds two
+	appendStars(stars(2000, 2*ChunkSize, 1))
+	if len(series) != 3 || !series[0].Full() || !series[1].Full() || series[2].Count != 10 {
+		t.Fatalf("Expected 2 full chunks and one of 10 timestamps, got %d chunks", len(series))
+	}
+	for i, chunk := range series {
+		if chunk.Seq != i {
+			t.Fatalf("Expected the chunk %d to have the Seq %d, got %d", i, i, chunk.Seq)
+		}
+	}
+
+	all, err := Range(series, 0, 1<<62)
+	if err != nil {
+		t.Fatalf("An error occured while reading the series: %v", err)
+	}
+	if !equal(all, expected) {
+		t.Fatalf("Expected %d timestamps, got %d", len(expected), len(all))
+	}
+
+	if _, err := Append(&series[2], []int64{1500}); err != ErrOutOfOrder {
+		t.Fatalf("Expected %v, got %v", ErrOutOfOrder, err)
+	}
+	if chunks, err := Append(&series[2], nil); err != nil || chunks != nil {
+		t.Fatalf("Appending nothing should write nothing, got %v, %v", chunks, err)
+	}
+}
+
+func TestAppendUnsorted(t *testing.T) {
+	chunks, err := Append(nil, []int64{30, 10, 20})
+	if err != nil {
+		t.Fatalf("An error occured while appending: %v", err)
+	}
+	timestamps, _ := chunks[0].Timestamps()
+	if !equal(timestamps, []int64{10, 20, 30}) || chunks[0].Start != 10 || chunks[0].End != 30 {
+		t.Fatalf("Expected the timestamps to be sorted, got %v (%+v)", timestamps, chunks[0])
+	}
+}
+
+func TestRangeCountAggregate(t *testing.T) {
+	// Two chunks: 100, 110, ..., 190 and 200, 210, ..., 290
+	series := []Chunk{NewChunk(0, stars(100, 10, 10)), NewChunk(1, stars(200, 10, 10))}
+
+	tests := []struct {
+		from, to int64
+		expected []int64
+	}{
+		{from: 0, to: 100, expected: nil},
+		{from: 0, to: 101, expected: []int64{100}},
+		{from: 185, to: 215, expected: []int64{190, 200, 210}},
+		{from: 290, to: 1000, expected: []int64{290}},
+		{from: 300, to: 1000, expected: nil},
+	}
+	for i, test := range tests {
+		timestamps, err := Range(series, test.from, test.to)
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while reading the range: %v", i, err)
+		}
+		if !equal(timestamps, test.expected) {
+			t.Fatalf("Test %d: expected %v, got %v", i, test.expected, timestamps)
+		}
+		count, err := Count(series, test.from, test.to)
+		if err != nil || count != len(test.expected) {
+			t.Fatalf("Test %d: expected a count of %d, got %d (%v)", i, len(test.expected), count, err)
+		}
+	}
+
+	buckets, err := Aggregate(series, 100, 350, 100)
+	if err != nil {
+		t.Fatalf("An error occured while aggregating: %v", err)
+	}
+	// No bucket after the last star
+	expected := []Bucket{{Start: 100, Count: 10}, {Start: 200, Count: 10}}
+	if len(buckets) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, buckets)
+	}
+	for i := range expected {
+		if buckets[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, buckets)
+		}
+	}
+
+	// Buckets across the chunks
+	buckets, _ = Aggregate(series, 150, 250, 50)
+	if len(buckets) != 2 || buckets[0].Count != 5 || buckets[1].Count != 5 {
+		t.Fatalf("Expected 2 buckets of 5 stars, got %v", buckets)
+	}
+
+	if _, err := Aggregate(series, 0, 100, 0); err == nil {
+		t.Fatal("Aggregating with a zero interval should fail")
+	}
+}
+
+func TestAggregateWideRange(t *testing.T) {
+	// Two chunks: 100, 110, ..., 190 and 200, 210, ..., 290
+	series := []Chunk{NewChunk(0, stars(100, 10, 10)), NewChunk(1, stars(200, 10, 10))}
+
+	tests := []struct {
+		from, to, interval int64
+		expected           []Bucket
+	}{
+		// All the stars, the span of the range overflows. The buckets stay aligned on from
+		{math.MinInt64, math.MaxInt64, 100, []Bucket{{Start: 92, Count: 10}, {Start: 192, Count: 10}}},
+		{-1 << 62, 1 << 62, 1000, []Bucket{{Start: 96, Count: 20}}},
+		{-1 << 62, 1 << 62, 1 << 62, []Bucket{{Start: 0, Count: 20}}},
+		{150, math.MaxInt64, 100, []Bucket{{Start: 150, Count: 10}, {Start: 250, Count: 5}}},
+		{math.MinInt64, 0, 100, []Bucket{}},
+	}
+	for i, test := range tests {
+		buckets, err := Aggregate(series, test.from, test.to, test.interval)
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while aggregating: %v", i, err)
+		}
+		if len(buckets) != len(test.expected) {
+			t.Fatalf("Test %d: expected %v, got %v", i, test.expected, buckets)
+		}
+		for j := range test.expected {
+			if buckets[j] != test.expected[j] {
+				t.Fatalf("Test %d: expected %v, got %v", i, test.expected, buckets)
+			}
+		}
+	}
+
+	// A bucket by second over the stars would be too many
+	wide := []Chunk{NewChunk(0, []int64{0, 1 << 40})}
+	if _, err := Aggregate(wide, math.MinInt64, math.MaxInt64, 1); err != ErrTooManyBuckets {
+		t.Fatalf("Expected %v, got %v", ErrTooManyBuckets, err)
+	}
+}
+
+func TestAggregateCorruptedChunk(t *testing.T) {
+	// A chunk in a single bucket is counted from its metadata, the others are decoded
+	corrupted := Chunk{Start: 100, End: 190, Count: 10, Data: []byte{0x80}}
+	if _, err := Aggregate([]Chunk{corrupted}, 0, 1000, 1000); err != nil {
+		t.Fatalf("The chunk shouldn't have been decoded, got %v", err)
+	}
+	if _, err := Aggregate([]Chunk{corrupted}, 0, 1000, 50); err != ErrCorrupted {
+		t.Fatalf("Expected %v, got %v", ErrCorrupted, err)
+	}
+}
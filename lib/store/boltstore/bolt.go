@@ -0,0 +1,702 @@
+// Package boltstore contains the implementation of the store.Store interface
+// embedded in a single local file with bbolt, so stargraph can run
+// without any external database.
+package boltstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
+	"github.com/evermax/stargraph/lib/series"
+	"github.com/evermax/stargraph/lib/store"
+)
+
+var (
+	// reposBucket holds the repositories encoded in JSON by ID.
+	reposBucket = []byte("repos")
+	// namesBucket is the index of the IDs of the repositories by name.
+	namesBucket = []byte("names")
+	// timestampsBucket holds a bucket by repository ID with the chunks
+	// of the series of its timestamps by Seq.
+	timestampsBucket = []byte("timestamps")
+	// snapshotsBucket holds a bucket by repository ID with its snapshots
+	// of the stargazers by time taken.
+	snapshotsBucket = []byte("snapshots")
+	// stargazersBucket holds a bucket by repository ID with its stargazers
+	// encoded in JSON by time of star and user ID.
+	stargazersBucket = []byte("stargazers")
+)
+
+// ID is the sequence number of a repository in the file.
+type ID uint64
+
+// String returns the ID as a decimal number.
+func (id ID) String() string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func (id ID) key() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+// Bolt holds the bbolt database and has several methods to add, get and modify
+// informations stored about Github repositories.
+// Every method runs in its own transaction, the writes being serialized by bbolt.
+type Bolt struct {
+	DB *bolt.DB
+}
+
+// Open opens the database file, creating it if needed.
+// Only one process can open the file at a time, the others get an error
+// after waiting for a second.
+func Open(path string) (Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return Bolt{}, fmt.Errorf("An error occured while opening the database %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{reposBucket, namesBucket, timestampsBucket, snapshotsBucket, stargazersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return Bolt{}, fmt.Errorf("An error occured while creating the buckets: %v", err)
+	}
+	if err := db.Update(migrate); err != nil {
+		db.Close()
+		return Bolt{}, fmt.Errorf("An error occured while migrating the repositories: %v", err)
+	}
+	return Bolt{DB: db}, nil
+}
+
+// legacyRepo is a repository as stored before the series and the stargazers buckets.
+type legacyRepo struct {
+	github.RepoInfo
+	Stargazers []github.Stargazer `json:"stargazers,omitempty"`
+}
+
+// migrate moves the timestamps and the stargazers stored along with the repositories into their buckets,
+// so the graphs of the repositories already crawled are kept. If a series already has chunks,
+// they are more recent and the timestamps are only dropped, the same goes for the stargazers.
+func migrate(tx *bolt.Tx) error {
+	legacy := make(map[ID]legacyRepo)
+	err := tx.Bucket(reposBucket).ForEach(func(k, v []byte) error {
+		var repo legacyRepo
+		if err := json.Unmarshal(v, &repo); err != nil {
+			return fmt.Errorf("An error occured while decoding the repository %d: %v", binary.BigEndian.Uint64(k), err)
+		}
+		if len(repo.Timestamps) > 0 || len(repo.Stargazers) > 0 {
+			legacy[ID(binary.BigEndian.Uint64(k))] = repo
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The buckets can't be modified while iterating on them
+	for key, repo := range legacy {
+		if len(repo.Timestamps) > 0 && tx.Bucket(timestampsBucket).Bucket(key.key()) == nil {
+			bucket, err := tx.Bucket(timestampsBucket).CreateBucket(key.key())
+			if err != nil {
+				return err
+			}
+			chunks, err := series.Append(nil, repo.Timestamps)
+			if err != nil {
+				return err
+			}
+			if err := putChunks(bucket, chunks); err != nil {
+				return err
+			}
+		}
+		if len(repo.Stargazers) > 0 && tx.Bucket(stargazersBucket).Bucket(key.key()) == nil {
+			if err := putStargazers(tx, key, repo.Stargazers); err != nil {
+				return err
+			}
+		}
+		if err := put(tx, key, repo.RepoInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database file.
+func (b Bolt) Close() error {
+	return b.DB.Close()
+}
+
+// GetRepo will fetch the data about the repo from the database.
+// If the repo exist, return the RepoInfo populated
+// If the repo doesn't exist in the database, return Repo with Exist = false
+// Return an error only if something unexepected happened.
+func (b Bolt) GetRepo(repo string) (repoInfo github.RepoInfo, id store.ID, err error) {
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(namesBucket).Get([]byte(repo))
+		if v == nil {
+			return nil
+		}
+		key := ID(binary.BigEndian.Uint64(v))
+		info, err := get(tx, key)
+		if err != nil {
+			return err
+		}
+		repoInfo, id = info, key
+		return nil
+	})
+	if err != nil {
+		return github.RepoInfo{}, nil, err
+	}
+	return repoInfo, id, nil
+}
+
+// GetRepoByID returns the repository with the id, store.ErrNotFound if there is none.
+func (b Bolt) GetRepoByID(id store.ID) (repoInfo github.RepoInfo, err error) {
+	key, err := toID(id)
+	if err != nil {
+		return github.RepoInfo{}, err
+	}
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		repoInfo, err = get(tx, key)
+		return err
+	})
+	return repoInfo, err
+}
+
+// ParseID decodes the String of an ID.
+func (b Bolt) ParseID(s string) (store.ID, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return nil, store.ErrInvalidID
+	}
+	return ID(id), nil
+}
+
+// AddRepo add a Github repository entry into the database.
+// If the repository exist, return store.ErrAlreadyExist.
+func (b Bolt) AddRepo(repoInfo github.RepoInfo) (id store.ID, err error) {
+	err = b.DB.Update(func(tx *bolt.Tx) error {
+		names := tx.Bucket(namesBucket)
+		if names.Get([]byte(repoInfo.Name)) != nil {
+			return store.ErrAlreadyExist
+		}
+		seq, err := tx.Bucket(reposBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		key := ID(seq)
+		repoInfo.Version = 1
+		if err := names.Put([]byte(repoInfo.Name), key.key()); err != nil {
+			return err
+		}
+		id = key
+		return put(tx, key, repoInfo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// PutRepo will put the informations about the Github repository in the database.
+// If there is no repository with the id, return store.ErrNotFound.
+// If it was modified since it was read, return a *store.ConflictError.
+func (b Bolt) PutRepo(repoInfo github.RepoInfo, id store.ID) error {
+	key, err := toID(id)
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		old, err := get(tx, key)
+		if err != nil {
+			return err
+		}
+		if err := store.CheckVersion(old, repoInfo); err != nil {
+			return err
+		}
+		repoInfo.Version++
+		if old.Name != repoInfo.Name {
+			// Keep the index by name up to date on a rename
+			names := tx.Bucket(namesBucket)
+			if names.Get([]byte(repoInfo.Name)) != nil {
+				return store.ErrAlreadyExist
+			}
+			if err := names.Delete([]byte(old.Name)); err != nil {
+				return err
+			}
+			if err := names.Put([]byte(repoInfo.Name), key.key()); err != nil {
+				return err
+			}
+		}
+		return put(tx, key, repoInfo)
+	})
+}
+
+// ClaimWork grants a lease on the work on the repository to the owner.
+// The lease is read and written in the same transaction, so only one
+// of concurrent claims succeeds, the others get store.ErrAlreadyWorkedOn.
+func (b Bolt) ClaimWork(repoInfo github.RepoInfo, id store.ID, owner string, ttl time.Duration) (store.Lease, error) {
+	return b.lease(id, func(info *github.RepoInfo) (store.Lease, error) {
+		return store.GrantLease(info, owner, ttl, time.Now())
+	})
+}
+
+// RenewLease extends the lease of the owner, store.ErrLeaseLost if the owner doesn't hold it anymore.
+func (b Bolt) RenewLease(id store.ID, owner string, ttl time.Duration) (store.Lease, error) {
+	return b.lease(id, func(info *github.RepoInfo) (store.Lease, error) {
+		return store.ExtendLease(info, owner, ttl, time.Now())
+	})
+}
+
+// ReleaseWork gives the work back, store.ErrLeaseLost if the owner doesn't hold the lease anymore.
+func (b Bolt) ReleaseWork(id store.ID, owner string) error {
+	_, err := b.lease(id, func(info *github.RepoInfo) (store.Lease, error) {
+		return store.Lease{}, store.ClearLease(info, owner)
+	})
+	return err
+}
+
+// lease applies the change to the lease of the repository with the id in a transaction.
+func (b Bolt) lease(id store.ID, change func(*github.RepoInfo) (store.Lease, error)) (lease store.Lease, err error) {
+	key, err := toID(id)
+	if err != nil {
+		return store.Lease{}, err
+	}
+	err = b.DB.Update(func(tx *bolt.Tx) error {
+		info, err := get(tx, key)
+		if err != nil {
+			return err
+		}
+		if lease, err = change(&info); err != nil {
+			return err
+		}
+		info.Version++
+		return put(tx, key, info)
+	})
+	if err != nil {
+		return store.Lease{}, err
+	}
+	return lease, nil
+}
+
+// AppendTimestamps appends the timestamps to the series of the repository with the id.
+func (b Bolt) AppendTimestamps(id store.ID, timestamps []int64) error {
+	key, err := toID(id)
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, key); err != nil {
+			return err
+		}
+		bucket, err := tx.Bucket(timestampsBucket).CreateBucketIfNotExists(key.key())
+		if err != nil {
+			return err
+		}
+		var last *series.Chunk
+		if k, v := bucket.Cursor().Last(); k != nil {
+			last = &series.Chunk{Seq: int(binary.BigEndian.Uint64(k))}
+			if err := last.UnmarshalBinary(v); err != nil {
+				return err
+			}
+		}
+		chunks, err := series.Append(last, timestamps)
+		if err != nil {
+			return err
+		}
+		return putChunks(bucket, chunks)
+	})
+}
+
+// putChunks writes the chunks in the bucket of a series, replacing the ones with the same Seq.
+func putChunks(bucket *bolt.Bucket, chunks []series.Chunk) error {
+	for _, chunk := range chunks {
+		v, err := chunk.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(ID(chunk.Seq).key(), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TimestampChunks returns the chunks of the series of the repository with the id overlapping [from, to).
+func (b Bolt) TimestampChunks(id store.ID, from, to int64) (chunks []series.Chunk, err error) {
+	key, err := toID(id)
+	if err != nil {
+		return nil, err
+	}
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		if _, err := get(tx, key); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(timestampsBucket).Bucket(key.key())
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			chunk := series.Chunk{Seq: int(binary.BigEndian.Uint64(k))}
+			if err := chunk.UnmarshalBinary(v); err != nil {
+				return err
+			}
+			if chunk.Overlaps(from, to) {
+				chunks = append(chunks, chunk)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// DeleteTimestamps deletes the series of the repository with the id.
+func (b Bolt) DeleteTimestamps(id store.ID) error {
+	key, err := toID(id)
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, key); err != nil {
+			return err
+		}
+		err := tx.Bucket(timestampsBucket).DeleteBucket(key.key())
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// ReplaceTimestamps replaces the series of the repository with the id with the timestamps, in a transaction.
+func (b Bolt) ReplaceTimestamps(id store.ID, timestamps []int64) error {
+	key, err := toID(id)
+	if err != nil {
+		return err
+	}
+	chunks, err := series.Append(nil, timestamps)
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, key); err != nil {
+			return err
+		}
+		timestamps := tx.Bucket(timestampsBucket)
+		if err := timestamps.DeleteBucket(key.key()); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		bucket, err := timestamps.CreateBucket(key.key())
+		if err != nil {
+			return err
+		}
+		return putChunks(bucket, chunks)
+	})
+}
+
+// ListRepos lists the repositories matching the filter by order of addition,
+// the cursor being the ID of the last repository of the previous page.
+func (b Bolt) ListRepos(filter store.RepoFilter, cursor string, limit int) (repos []store.Repo, next string, err error) {
+	var after ID
+	if cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, "", store.ErrInvalidCursor
+		}
+		after = ID(id)
+	}
+	limit = store.PageSize(limit)
+	now := time.Now()
+
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(reposBucket).Cursor()
+		var last ID
+		for k, v := c.Seek((after + 1).key()); k != nil; k, v = c.Next() {
+			repoInfo, err := decode(v)
+			if err != nil {
+				return err
+			}
+			if !filter.Match(repoInfo, now) {
+				continue
+			}
+			if len(repos) == limit {
+				// There is at least another page
+				next = last.String()
+				return nil
+			}
+			last = ID(binary.BigEndian.Uint64(k))
+			repos = append(repos, store.Repo{ID: last, Info: repoInfo})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return repos, next, nil
+}
+
+// Count counts the repositories matching the filter.
+func (b Bolt) Count(filter store.RepoFilter) (count int, err error) {
+	now := time.Now()
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(reposBucket).ForEach(func(k, v []byte) error {
+			repoInfo, err := decode(v)
+			if err != nil {
+				return err
+			}
+			if filter.Match(repoInfo, now) {
+				count++
+			}
+			return nil
+		})
+	})
+	return count, err
+}
+
+// DeleteRepo deletes the repository with the id, its name from the index, its timestamps,
+// its snapshots and its stargazers.
+func (b Bolt) DeleteRepo(id store.ID) error {
+	key, err := toID(id)
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		repoInfo, err := get(tx, key)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(namesBucket).Delete([]byte(repoInfo.Name)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(reposBucket).Delete(key.key()); err != nil {
+			return err
+		}
+		for _, name := range [][]byte{timestampsBucket, snapshotsBucket, stargazersBucket} {
+			err := tx.Bucket(name).DeleteBucket(key.key())
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddSnapshot keeps the snapshot, replacing the one taken at the same time.
+func (b Bolt) AddSnapshot(id store.ID, snapshot history.Snapshot) error {
+	key, err := toID(id)
+	if err != nil {
+		return err
+	}
+	v, err := snapshot.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, key); err != nil {
+			return err
+		}
+		bucket, err := tx.Bucket(snapshotsBucket).CreateBucketIfNotExists(key.key())
+		if err != nil {
+			return err
+		}
+		return bucket.Put(timeKey(snapshot.Taken), v)
+	})
+}
+
+// Snapshots returns the snapshots taken in [from, to).
+func (b Bolt) Snapshots(id store.ID, from, to int64) (snapshots []history.Snapshot, err error) {
+	key, err := toID(id)
+	if err != nil {
+		return nil, err
+	}
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		if _, err := get(tx, key); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(snapshotsBucket).Bucket(key.key())
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		end := timeKey(to)
+		for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			var snapshot history.Snapshot
+			if err := snapshot.UnmarshalBinary(v); err != nil {
+				return err
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// PutStargazers replaces the stargazers of the repository with the id.
+func (b Bolt) PutStargazers(id store.ID, stargazers []github.Stargazer) error {
+	key, err := toID(id)
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, key); err != nil {
+			return err
+		}
+		return putStargazers(tx, key, stargazers)
+	})
+}
+
+// ListStargazers lists the stargazers of the repository with the id by time of star,
+// the cursor being the one of the last stargazer of the previous page.
+func (b Bolt) ListStargazers(id store.ID, cursor string, limit int) (stargazers []github.Stargazer, next string, err error) {
+	key, err := toID(id)
+	if err != nil {
+		return nil, "", err
+	}
+	var after []byte
+	if cursor != "" {
+		time, userID, err := store.ParseStargazerCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = stargazerKey(time, userID)
+	}
+	limit = store.PageSize(limit)
+
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		if _, err := get(tx, key); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(stargazersBucket).Bucket(key.key())
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		k, v := c.First()
+		if after != nil {
+			if k, v = c.Seek(after); k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		var last []byte
+		for ; k != nil; k, v = c.Next() {
+			if len(stargazers) == limit {
+				// There is at least another page
+				next = store.Starred{
+					Time:      int64(binary.BigEndian.Uint64(last[:8]) ^ (1 << 63)),
+					Stargazer: stargazers[len(stargazers)-1],
+				}.Cursor()
+				return nil
+			}
+			var stargazer github.Stargazer
+			if err := json.Unmarshal(v, &stargazer); err != nil {
+				return err
+			}
+			stargazers = append(stargazers, stargazer)
+			last = k
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return stargazers, next, nil
+}
+
+// putStargazers replaces the stargazers of the repository with the id.
+func putStargazers(tx *bolt.Tx, key ID, stargazers []github.Stargazer) error {
+	starred, err := store.SortStargazers(stargazers)
+	if err != nil {
+		return err
+	}
+	parent := tx.Bucket(stargazersBucket)
+	if err := parent.DeleteBucket(key.key()); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	bucket, err := parent.CreateBucket(key.key())
+	if err != nil {
+		return err
+	}
+	for _, s := range starred {
+		v, err := json.Marshal(s.Stargazer)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(stargazerKey(s.Time, s.Stargazer.User.ID), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stargazerKey encodes the time of the star and the user ID so that the keys are sorted as listed.
+func stargazerKey(time int64, userID int) []byte {
+	return append(timeKey(time), timeKey(int64(userID))...)
+}
+
+// timeKey encodes the Unix time, or any int64, so that the keys are sorted by time, negative times included.
+func timeKey(t int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t)^(1<<63))
+	return b
+}
+
+// get reads the repository with the id, store.ErrNotFound if there is none.
+func get(tx *bolt.Tx, id ID) (github.RepoInfo, error) {
+	v := tx.Bucket(reposBucket).Get(id.key())
+	if v == nil {
+		return github.RepoInfo{}, store.ErrNotFound
+	}
+	repoInfo, err := decode(v)
+	if err != nil {
+		return repoInfo, fmt.Errorf("An error occured while decoding the repository %d: %v", id, err)
+	}
+	return repoInfo, nil
+}
+
+// decode decodes a repository read from the repos bucket.
+func decode(v []byte) (github.RepoInfo, error) {
+	var repoInfo github.RepoInfo
+	if err := json.Unmarshal(v, &repoInfo); err != nil {
+		return repoInfo, err
+	}
+	repoInfo.SetExist(true)
+	return repoInfo, nil
+}
+
+// put writes the repository, without its timestamps that are stored in the series.
+func put(tx *bolt.Tx, id ID, repoInfo github.RepoInfo) error {
+	repoInfo.Timestamps = nil
+	v, err := json.Marshal(repoInfo)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(reposBucket).Put(id.key(), v)
+}
+
+func toID(id store.ID) (ID, error) {
+	key, ok := id.(ID)
+	if !ok {
+		return 0, fmt.Errorf("The id %v is not a boltstore.ID", id)
+	}
+	return key, nil
+}
@@ -0,0 +1,206 @@
+package boltstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
+	"github.com/evermax/stargraph/lib/store"
+	"github.com/evermax/stargraph/lib/store/storetest"
+)
+
+var _ store.Store = Bolt{}
+
+func openTestDB(t *testing.T) (Bolt, string, func()) {
+	dir, err := ioutil.TempDir("", "stargraph-bolt")
+	if err != nil {
+		t.Fatalf("An error occured while creating the temporary directory: %v", err)
+	}
+	path := filepath.Join(dir, "stargraph.db")
+	b, err := Open(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("An error occured while opening the database: %v", err)
+	}
+	return b, path, func() {
+		b.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (store.Store, func()) {
+		b, _, cleanup := openTestDB(t)
+		return b, cleanup
+	})
+}
+
+func TestReopen(t *testing.T) {
+	b, path, cleanup := openTestDB(t)
+	defer cleanup()
+
+	id, err := b.AddRepo(github.RepoInfo{ID: 42, Name: "evermax/stargraph"})
+	if err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+	if err := b.AppendTimestamps(id, []int64{1446285600, 1446289200}); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+
+	// The data is still there once the file is reopened
+	b.Close()
+	b, err = Open(path)
+	if err != nil {
+		t.Fatalf("An error occured while reopening the database: %v", err)
+	}
+	defer b.Close()
+	info, gotID, err := b.GetRepo("evermax/stargraph")
+	if err != nil {
+		t.Fatalf("An error occured while getting the repository: %v", err)
+	}
+	if !info.Exist() || gotID != id {
+		t.Fatalf("Expected the repository %v, got %v (%+v)", id, gotID, info)
+	}
+	timestamps, err := store.Timestamps(b, id, store.AllFrom, store.AllTo)
+	if err != nil || len(timestamps) != 2 {
+		t.Fatalf("Expected the 2 timestamps, got %v (%v)", timestamps, err)
+	}
+}
+
+func TestMoveTimestamps(t *testing.T) {
+	b, path, cleanup := openTestDB(t)
+	defer cleanup()
+
+	id, err := b.AddRepo(github.RepoInfo{ID: 42, Name: "evermax/stargraph"})
+	if err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+	// A repository crawled before the series has its timestamps along with it
+	err = b.DB.Update(func(tx *bolt.Tx) error {
+		v, err := json.Marshal(github.RepoInfo{
+			ID:         42,
+			Name:       "evermax/stargraph",
+			LastUpdate: "2016-01-01T00:00:00Z",
+			Version:    1,
+			Timestamps: []int64{1446285620, 1446285600, 1446285610},
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(reposBucket).Put(id.(ID).key(), v)
+	})
+	if err != nil {
+		t.Fatalf("An error occured while writing the repository: %v", err)
+	}
+
+	b.Close()
+	b, err = Open(path)
+	if err != nil {
+		t.Fatalf("An error occured while reopening the database: %v", err)
+	}
+	defer b.Close()
+	timestamps, err := store.Timestamps(b, id, store.AllFrom, store.AllTo)
+	if err != nil {
+		t.Fatalf("An error occured while reading the timestamps: %v", err)
+	}
+	expected := []int64{1446285600, 1446285610, 1446285620}
+	if fmt.Sprint(timestamps) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the timestamps %v to be moved to the series, got %v", expected, timestamps)
+	}
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		var repoInfo github.RepoInfo
+		if err := json.Unmarshal(tx.Bucket(reposBucket).Get(id.(ID).key()), &repoInfo); err != nil {
+			return err
+		}
+		if repoInfo.Timestamps != nil {
+			return fmt.Errorf("The timestamps %v are still stored with the repository", repoInfo.Timestamps)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoveStargazers(t *testing.T) {
+	b, path, cleanup := openTestDB(t)
+	defer cleanup()
+
+	id, err := b.AddRepo(github.RepoInfo{ID: 42, Name: "evermax/stargraph"})
+	if err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+	// A repository crawled before the stargazers bucket has its stargazers along with it
+	err = b.DB.Update(func(tx *bolt.Tx) error {
+		v, err := json.Marshal(legacyRepo{
+			RepoInfo: github.RepoInfo{ID: 42, Name: "evermax/stargraph", LastUpdate: "2016-01-01T00:00:00Z", Version: 1},
+			Stargazers: []github.Stargazer{
+				{Timestamp: "2015-10-31T10:00:20Z", User: github.User{ID: 2, Login: "second"}},
+				{Timestamp: "2015-10-31T10:00:00Z", User: github.User{ID: 1, Login: "first"}},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(reposBucket).Put(id.(ID).key(), v)
+	})
+	if err != nil {
+		t.Fatalf("An error occured while writing the repository: %v", err)
+	}
+
+	b.Close()
+	b, err = Open(path)
+	if err != nil {
+		t.Fatalf("An error occured while reopening the database: %v", err)
+	}
+	defer b.Close()
+	stargazers, err := store.AllStargazers(b, id)
+	if err != nil {
+		t.Fatalf("An error occured while listing the stargazers: %v", err)
+	}
+	if len(stargazers) != 2 || stargazers[0].User.Login != "first" || stargazers[1].User.Login != "second" {
+		t.Fatalf("Expected the stargazers first and second to be moved to their bucket, got %v", stargazers)
+	}
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		var repo legacyRepo
+		if err := json.Unmarshal(tx.Bucket(reposBucket).Get(id.(ID).key()), &repo); err != nil {
+			return err
+		}
+		if repo.Stargazers != nil {
+			return fmt.Errorf("The stargazers %v are still stored with the repository", repo.Stargazers)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	b, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	if err := b.PutRepo(github.RepoInfo{Name: "evermax/stargraph"}, ID(1000)); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if _, err := b.ClaimWork(github.RepoInfo{Name: "evermax/stargraph"}, ID(1000), "test", time.Minute); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if err := b.AppendTimestamps(ID(1000), []int64{1446285600}); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if _, err := b.TimestampChunks(ID(1000), store.AllFrom, store.AllTo); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if err := b.AddSnapshot(ID(1000), history.Snapshot{Taken: 1446285600}); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+}
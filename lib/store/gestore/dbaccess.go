@@ -1,91 +1,1056 @@
 // Package gestore contains the implementation of the store.Store interface
-// for the Google Datastore.
+// for Google Cloud Datastore (or Firestore in Datastore mode).
+// It runs anywhere, not only on App Engine, and uses the local emulator
+// when the DATASTORE_EMULATOR_HOST environment variable is set.
 package gestore
 
 import (
-	"golang.org/x/net/context"
-	"google.golang.org/appengine/datastore"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
 
 	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
+	"github.com/evermax/stargraph/lib/series"
 	"github.com/evermax/stargraph/lib/store"
 )
 
 const (
-	kind     = "RepoInfo"
-	stringID = "default_repoinfo"
+	kind = "RepoInfo"
+	// chunkKind is the kind of the chunks of the series of timestamps,
+	// children of the entity of their repository.
+	chunkKind = "TimestampChunk"
+	// snapshotKind is the kind of the parts of the snapshots of the stargazers,
+	// children of the entity of their repository.
+	snapshotKind = "SnapshotPart"
+	// stargazerKind is the kind of the stargazers, children of the entity of their repository
+	// keyed by the id of the user.
+	stargazerKind = "Stargazer"
+	// nameKind is the kind of the names of the renamed repositories: a repository keeps the key
+	// it was added with, the entity keyed by its new name holds that key.
+	nameKind = "RepoName"
+	// snapshotPartSize is the maximum number of stars in a part of a snapshot,
+	// so that a part takes less than the 1MiB limit of an entity.
+	snapshotPartSize = 50000
+	// deleteBatch is the maximum number of entities deleted at once.
+	deleteBatch = 500
+	// maxAttempts is the number of times a transaction is tried
+	// when it conflicts with another one.
+	maxAttempts = 10
 )
 
-// Datastore is a simple struct that hold the context to access the datastore
+// ID wraps the key of the repository entity.
+type ID struct {
+	Key *datastore.Key
+}
+
+// String returns the encoded key.
+func (id ID) String() string {
+	if id.Key == nil {
+		return ""
+	}
+	return id.Key.Encode()
+}
+
+// Datastore is a simple struct that hold the client and the context to access the datastore
 // and has several methods to add, get and modify informations stored about Github repositories.
+// The entities are keyed by the name the repository was added with, in the Namespace if not empty.
+// A renamed repository keeps its key and is found by its new name through a nameEntity.
 type Datastore struct {
-	Context context.Context
+	Context   context.Context
+	Client    *datastore.Client
+	Namespace string
 }
 
-// NewDatastore create a new Datastore with a default context.
+// NewDatastore creates a Datastore connected to the project, with a default context.
 // It actually use context.Background().
-func NewDatastore() Datastore {
+func NewDatastore(projectID string) (Datastore, error) {
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return Datastore{}, fmt.Errorf("An error occured while connecting to the datastore: %v", err)
+	}
 	return Datastore{
-		Context: context.Background(),
+		Context: ctx,
+		Client:  client,
+	}, nil
+}
+
+// Close closes the connection to the datastore.
+func (db Datastore) Close() error {
+	return db.Client.Close()
+}
+
+// entity is how a github.RepoInfo is stored.
+// The timestamps are stored in chunks and the stargazers in their own entities,
+// Timestamps and Stargazers are only set on the entities stored before.
+// The timestamps are moved into the chunks the first time the entity is read or written,
+// see migrate, and the stargazers the first time they are listed, see migrateStargazers.
+type entity struct {
+	ID           int
+	Name         string
+	FullName     string
+	Count        int
+	CreationDate string
+	LastStarDate string
+	LastUpdate   string
+	WorkedOn     bool
+	LeaseOwner   string
+	LeaseExpiry  time.Time
+	StarsURL     string
+	Version      int64
+	Timestamps   []int64 `datastore:",noindex"`
+	Stargazers   []byte  `datastore:",noindex"`
+}
+
+func toEntity(repoInfo github.RepoInfo) *entity {
+	return &entity{
+		ID:           repoInfo.ID,
+		Name:         repoInfo.Name,
+		FullName:     repoInfo.FullName,
+		Count:        repoInfo.Count,
+		CreationDate: repoInfo.CreationDate,
+		LastStarDate: repoInfo.LastStarDate,
+		LastUpdate:   repoInfo.LastUpdate,
+		WorkedOn:     repoInfo.WorkedOn,
+		LeaseOwner:   repoInfo.LeaseOwner,
+		LeaseExpiry:  repoInfo.LeaseExpiry,
+		StarsURL:     repoInfo.StarsURL,
+		Version:      repoInfo.Version,
+	}
+}
+
+func (e *entity) repoInfo() github.RepoInfo {
+	repoInfo := github.RepoInfo{
+		ID:           e.ID,
+		Name:         e.Name,
+		FullName:     e.FullName,
+		Count:        e.Count,
+		CreationDate: e.CreationDate,
+		LastStarDate: e.LastStarDate,
+		LastUpdate:   e.LastUpdate,
+		WorkedOn:     e.WorkedOn,
+		LeaseOwner:   e.LeaseOwner,
+		LeaseExpiry:  e.LeaseExpiry,
+		StarsURL:     e.StarsURL,
+		Version:      e.Version,
 	}
+	repoInfo.SetExist(true)
+	return repoInfo
 }
 
 // GetRepo will fetch the data about the repo from the database.
 // If the repo exist, return the RepoInfo populated
 // If the repo doesn't exist in the database, return Repo with Exist = false
 // Return an error only if something unexepected happened.
-func (db Datastore) GetRepo(repo string) (github.RepoInfo, *datastore.Key, error) {
-	var repoInfo github.RepoInfo
-	key := new(datastore.Key)
-	q := datastore.NewQuery(kind).Ancestor(repoInfoKey(db.Context)).Filter("Name =", repo)
-	for t := q.Run(db.Context); ; {
-		var info github.RepoInfo
+func (db Datastore) GetRepo(repo string) (github.RepoInfo, store.ID, error) {
+	key, e, err := db.lookup(db.get, repo)
+	if err != nil || key == nil {
+		return github.RepoInfo{}, nil, err
+	}
+	return e.repoInfo(), ID{Key: key}, nil
+}
+
+// nameEntity points the name of a renamed repository to the entity of the repository.
+type nameEntity struct {
+	Repo *datastore.Key
+}
+
+// getter is the Get of a datastore.Transaction, or of the Client, see get.
+type getter func(key *datastore.Key, dst interface{}) error
+
+// lookup returns the key and the entity of the repository with the name, a nil key if there is none.
+// The nameEntity of the name is read first, the entity keyed by the name might have been renamed since.
+func (db Datastore) lookup(get getter, name string) (*datastore.Key, *entity, error) {
+	key := db.repoKey(name)
+	var renamed nameEntity
+	err := get(db.nameKey(name), &renamed)
+	if err == nil {
+		key = renamed.Repo
+	} else if err != datastore.ErrNoSuchEntity {
+		return nil, nil, err
+	}
+	var e entity
+	err = get(key, &e)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.Name != name {
+		// Renamed, the name is free
+		return nil, nil, nil
+	}
+	return key, &e, nil
+}
+
+// GetRepoByID returns the repository with the id, store.ErrNotFound if there is none.
+func (db Datastore) GetRepoByID(id store.ID) (github.RepoInfo, error) {
+	key, err := toKey(id)
+	if err != nil {
+		return github.RepoInfo{}, err
+	}
+	var e entity
+	err = db.Client.Get(db.ctx(), key, &e)
+	if err == datastore.ErrNoSuchEntity {
+		return github.RepoInfo{}, store.ErrNotFound
+	}
+	if err != nil {
+		return github.RepoInfo{}, err
+	}
+	return e.repoInfo(), nil
+}
+
+// ParseID decodes the String of an ID, the key of a repository in the Namespace.
+func (db Datastore) ParseID(s string) (store.ID, error) {
+	key, err := datastore.DecodeKey(s)
+	if err != nil || key.Kind != kind || key.Parent != nil || key.Namespace != db.Namespace {
+		return nil, store.ErrInvalidID
+	}
+	return ID{Key: key}, nil
+}
+
+// AddRepo add a Github repository entry into the database.
+// If the repository exist, return store.ErrAlreadyExist.
+// Return an eventual error from the communication with the database.
+// If the key of the name is still the one of a repository renamed since, the repository
+// gets a key of its own and is found by its name through a nameEntity.
+func (db Datastore) AddRepo(repoInfo github.RepoInfo) (store.ID, error) {
+	repoInfo.Version = 1
+	e := toEntity(repoInfo)
+	var key *datastore.Key
+	_, err := db.Client.RunInTransaction(db.ctx(), func(tx *datastore.Transaction) error {
+		existing, _, err := db.lookup(tx.Get, repoInfo.Name)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return store.ErrAlreadyExist
+		}
+		key = db.repoKey(repoInfo.Name)
+		var renamed entity
+		err = tx.Get(key, &renamed)
+		if err == nil {
+			keys, err := db.Client.AllocateIDs(db.ctx(), []*datastore.Key{db.incompleteKey()})
+			if err != nil {
+				return err
+			}
+			key = keys[0]
+			if _, err := tx.Put(db.nameKey(repoInfo.Name), &nameEntity{Repo: key}); err != nil {
+				return err
+			}
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		_, err = tx.Put(key, e)
+		return err
+	}, datastore.MaxAttempts(maxAttempts))
+	if err != nil {
+		return nil, err
+	}
+	return ID{Key: key}, nil
+}
+
+// PutRepo will put the informations about the Github repository in the database.
+// If there is no repository with the id, return store.ErrNotFound.
+// If it was modified since it was read, return a *store.ConflictError.
+// The key of the entity stays the one it was added with, a new name is pointed to it,
+// store.ErrAlreadyExist if another repository has the name.
+func (db Datastore) PutRepo(repoInfo github.RepoInfo, id store.ID) error {
+	key, err := toKey(id)
+	if err != nil {
+		return err
+	}
+	put := repoInfo
+	put.Version++
+	e := toEntity(put)
+	_, err = db.Client.RunInTransaction(db.ctx(), func(tx *datastore.Transaction) error {
+		var existing entity
+		if err := tx.Get(key, &existing); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return store.ErrNotFound
+			}
+			return err
+		}
+		if existing.Version != repoInfo.Version {
+			return &store.ConflictError{Name: repoInfo.Name, Expected: repoInfo.Version, Actual: existing.Version}
+		}
+		if existing.Name != repoInfo.Name {
+			if err := db.rename(tx, key, existing.Name, repoInfo.Name); err != nil {
+				return err
+			}
+		}
+		if err := db.migrate(tx, key, &existing); err != nil {
+			return err
+		}
+		// The stargazers not moved yet are kept
+		e.Stargazers = existing.Stargazers
+		_, err := tx.Put(key, e)
+		return err
+	}, datastore.MaxAttempts(maxAttempts))
+	return err
+}
+
+// rename points the new name to the repository with the key in the transaction and frees the old one.
+func (db Datastore) rename(tx *datastore.Transaction, key *datastore.Key, oldName, newName string) error {
+	other, _, err := db.lookup(tx.Get, newName)
+	if err != nil {
+		return err
+	}
+	if other != nil {
+		return store.ErrAlreadyExist
+	}
+	if err := tx.Delete(db.nameKey(oldName)); err != nil {
+		return err
+	}
+	if key.Equal(db.repoKey(newName)) {
+		// Back to the name it was added with
+		return nil
+	}
+	_, err = tx.Put(db.nameKey(newName), &nameEntity{Repo: key})
+	return err
+}
+
+// ClaimWork grants a lease on the work on the repository to the owner.
+// The lease is read and written in a transaction, so only one
+// of concurrent claims succeeds, the others get store.ErrAlreadyWorkedOn.
+func (db Datastore) ClaimWork(repoInfo github.RepoInfo, id store.ID, owner string, ttl time.Duration) (store.Lease, error) {
+	return db.lease(id, func(info *github.RepoInfo) (store.Lease, error) {
+		return store.GrantLease(info, owner, ttl, time.Now())
+	})
+}
+
+// RenewLease extends the lease of the owner, store.ErrLeaseLost if the owner doesn't hold it anymore.
+func (db Datastore) RenewLease(id store.ID, owner string, ttl time.Duration) (store.Lease, error) {
+	return db.lease(id, func(info *github.RepoInfo) (store.Lease, error) {
+		return store.ExtendLease(info, owner, ttl, time.Now())
+	})
+}
+
+// ReleaseWork gives the work back, store.ErrLeaseLost if the owner doesn't hold the lease anymore.
+func (db Datastore) ReleaseWork(id store.ID, owner string) error {
+	_, err := db.lease(id, func(info *github.RepoInfo) (store.Lease, error) {
+		return store.Lease{}, store.ClearLease(info, owner)
+	})
+	return err
+}
+
+// lease applies the change to the lease of the repository with the id in a transaction.
+func (db Datastore) lease(id store.ID, change func(*github.RepoInfo) (store.Lease, error)) (store.Lease, error) {
+	key, err := toKey(id)
+	if err != nil {
+		return store.Lease{}, err
+	}
+	var lease store.Lease
+	_, err = db.Client.RunInTransaction(db.ctx(), func(tx *datastore.Transaction) error {
+		var e entity
+		if err := tx.Get(key, &e); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return store.ErrNotFound
+			}
+			return err
+		}
+		if err := db.migrate(tx, key, &e); err != nil {
+			return err
+		}
+		info := e.repoInfo()
 		var err error
-		key, err = t.Next(&info)
-		if err == datastore.Done {
-			break
+		if lease, err = change(&info); err != nil {
+			return err
+		}
+		info.Version++
+		updated := toEntity(info)
+		// The stargazers not moved yet are kept
+		updated.Stargazers = e.Stargazers
+		_, err = tx.Put(key, updated)
+		return err
+	}, datastore.MaxAttempts(maxAttempts))
+	if err != nil {
+		return store.Lease{}, err
+	}
+	return lease, nil
+}
+
+// chunkEntity is how a series.Chunk is stored, its key is the Seq + 1 as IDs can't be 0.
+type chunkEntity struct {
+	Seq   int
+	Start int64
+	End   int64
+	Count int
+	Data  []byte `datastore:",noindex"`
+}
+
+func (c chunkEntity) chunk() series.Chunk {
+	return series.Chunk{Seq: c.Seq, Start: c.Start, End: c.End, Count: c.Count, Data: c.Data}
+}
+
+// AppendTimestamps appends the timestamps to the series of the repository with the id.
+// The chunks are in the entity group of the repository, so the last one is read
+// and the new ones written in a transaction.
+// The queries on the chunks need the indexes in index.yaml.
+func (db Datastore) AppendTimestamps(id store.ID, timestamps []int64) error {
+	key, err := toKey(id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Client.RunInTransaction(db.ctx(), func(tx *datastore.Transaction) error {
+		var e entity
+		if err := tx.Get(key, &e); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return store.ErrNotFound
+			}
+			return err
+		}
+		if len(e.Timestamps) > 0 {
+			// The transaction doesn't see its own writes, the timestamps
+			// of the entity are appended along with the new ones
+			legacy, err := db.legacyTimestamps(tx, key, &e)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Put(key, &e); err != nil {
+				return err
+			}
+			timestamps = append(legacy, timestamps...)
+		}
+		var stored []chunkEntity
+		q := db.chunkQuery(key).Order("-Seq").Limit(1).Transaction(tx)
+		if _, err := db.Client.GetAll(db.ctx(), q, &stored); err != nil {
+			return err
 		}
+		var last *series.Chunk
+		if len(stored) > 0 {
+			chunk := stored[0].chunk()
+			last = &chunk
+		}
+		chunks, err := series.Append(last, timestamps)
 		if err != nil {
-			return repoInfo, nil, err
+			return err
+		}
+		return db.putChunks(tx, key, chunks)
+	}, datastore.MaxAttempts(maxAttempts))
+	return err
+}
+
+// putChunks writes the chunks of the series of the repository with the key in the transaction,
+// replacing the ones with the same Seq.
+func (db Datastore) putChunks(tx *datastore.Transaction, repoKey *datastore.Key, chunks []series.Chunk) error {
+	for _, chunk := range chunks {
+		c := &chunkEntity{Seq: chunk.Seq, Start: chunk.Start, End: chunk.End, Count: chunk.Count, Data: chunk.Data}
+		if _, err := tx.Put(db.chunkKey(repoKey, chunk.Seq), c); err != nil {
+			return err
 		}
-		repoInfo = info
-		repoInfo.SetExist(true)
 	}
-	return repoInfo, key, nil
+	return nil
 }
 
-// AddRepo add a Github repository entry into the database.
-// If the repository exist, return AlreadyExistError.
-// Return an eventual error from the communication with the database.
-func (db Datastore) AddRepo(repoInfo github.RepoInfo) (*datastore.Key, error) {
-	info, _, err := db.GetRepo(repoInfo.Name)
+// legacyTimestamps clears the timestamps of an entity stored before the series and returns them,
+// to be appended to its series in the transaction. If the series already has chunks, they are
+// more recent and nothing is returned.
+func (db Datastore) legacyTimestamps(tx *datastore.Transaction, repoKey *datastore.Key, e *entity) ([]int64, error) {
+	legacy := e.Timestamps
+	e.Timestamps = nil
+	if len(legacy) == 0 {
+		return nil, nil
+	}
+	q := db.chunkQuery(repoKey).KeysOnly().Limit(1).Transaction(tx)
+	existing, err := db.Client.GetAll(db.ctx(), q, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, nil
+	}
+	return legacy, nil
+}
+
+// migrate moves the timestamps of an entity stored before the series into its chunks,
+// in the transaction that writes the entity.
+func (db Datastore) migrate(tx *datastore.Transaction, repoKey *datastore.Key, e *entity) error {
+	legacy, err := db.legacyTimestamps(tx, repoKey, e)
+	if err != nil || len(legacy) == 0 {
+		return err
+	}
+	chunks, err := series.Append(nil, legacy)
 	if err != nil {
+		return err
+	}
+	return db.putChunks(tx, repoKey, chunks)
+}
+
+// migrateRepo moves the timestamps of the repository with the key into its chunks
+// if it was stored before the series, so they can be read.
+func (db Datastore) migrateRepo(repoKey *datastore.Key) error {
+	var e entity
+	err := db.Client.Get(db.ctx(), repoKey, &e)
+	if err == datastore.ErrNoSuchEntity {
+		return store.ErrNotFound
+	}
+	if err != nil || len(e.Timestamps) == 0 {
+		return err
+	}
+	_, err = db.Client.RunInTransaction(db.ctx(), func(tx *datastore.Transaction) error {
+		var e entity
+		if err := tx.Get(repoKey, &e); err != nil {
+			return err
+		}
+		if len(e.Timestamps) == 0 {
+			// Moved in the meantime
+			return nil
+		}
+		if err := db.migrate(tx, repoKey, &e); err != nil {
+			return err
+		}
+		_, err := tx.Put(repoKey, &e)
+		return err
+	}, datastore.MaxAttempts(maxAttempts))
+	return err
+}
+
+// TimestampChunks returns the chunks of the series of the repository with the id overlapping [from, to).
+// Only the chunks ending after from are queried.
+func (db Datastore) TimestampChunks(id store.ID, from, to int64) ([]series.Chunk, error) {
+	key, err := toKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.migrateRepo(key); err != nil {
+		return nil, err
+	}
+	var stored []chunkEntity
+	q := db.chunkQuery(key).Filter("End >=", from).Order("End")
+	if _, err := db.Client.GetAll(db.ctx(), q, &stored); err != nil {
 		return nil, err
 	}
-	if info.Exist() {
-		return nil, store.ErrAlreadyExist
+	var chunks []series.Chunk
+	for _, c := range stored {
+		chunk := c.chunk()
+		if !chunk.Overlaps(from, to) {
+			// The chunks are sorted, the next ones start even later
+			break
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// DeleteTimestamps deletes the series of the repository with the id, by batches of deleteBatch chunks.
+// The timestamps of an entity stored before the series are moved first, so they are deleted too.
+func (db Datastore) DeleteTimestamps(id store.ID) error {
+	key, err := toKey(id)
+	if err != nil {
+		return err
+	}
+	if err := db.migrateRepo(key); err != nil {
+		return err
+	}
+	return db.deleteChildren(db.chunkQuery(key))
+}
+
+// ReplaceTimestamps replaces the series of the repository with the id with the timestamps.
+// The series of the big repositories don't fit in a transaction: the chunks of the new series
+// are written over the old ones by batches of deleteBatch, then the old chunks past its end
+// are deleted, so the series is never empty while it is replaced.
+func (db Datastore) ReplaceTimestamps(id store.ID, timestamps []int64) error {
+	key, err := toKey(id)
+	if err != nil {
+		return err
+	}
+	// The timestamps of an entity stored before the series would be appended to the new series
+	if err := db.migrateRepo(key); err != nil {
+		return err
+	}
+	chunks, err := series.Append(nil, timestamps)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(chunks); start += deleteBatch {
+		end := start + deleteBatch
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		keys := make([]*datastore.Key, 0, end-start)
+		entities := make([]*chunkEntity, 0, end-start)
+		for _, chunk := range chunks[start:end] {
+			keys = append(keys, db.chunkKey(key, chunk.Seq))
+			entities = append(entities, &chunkEntity{Seq: chunk.Seq, Start: chunk.Start, End: chunk.End, Count: chunk.Count, Data: chunk.Data})
+		}
+		if _, err := db.Client.PutMulti(db.ctx(), keys, entities); err != nil {
+			return err
+		}
+	}
+	return db.deleteChildren(db.chunkQuery(key).Filter("Seq >=", len(chunks)).Order("-Seq"))
+}
+
+// deleteChildren deletes the entities of the query, a query of the children of a repository.
+func (db Datastore) deleteChildren(q *datastore.Query) error {
+	for {
+		keys, err := db.Client.GetAll(db.ctx(), q.KeysOnly().Limit(deleteBatch), nil)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := db.Client.DeleteMulti(db.ctx(), keys); err != nil {
+			return err
+		}
+	}
+}
+
+// ListRepos lists the repositories matching the filter by order of key, so of the name they were added with,
+// the cursor being a Datastore cursor. The filter is applied on the entities
+// read, as the Datastore doesn't allow inequality filters on several properties.
+func (db Datastore) ListRepos(filter store.RepoFilter, cursor string, limit int) ([]store.Repo, string, error) {
+	q := datastore.NewQuery(kind).Namespace(db.Namespace).Order("__key__")
+	if cursor != "" {
+		c, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", store.ErrInvalidCursor
+		}
+		q = q.Start(c)
+	}
+	limit = store.PageSize(limit)
+	now := time.Now()
+
+	var repos []store.Repo
+	var next string
+	it := db.Client.Run(db.ctx(), q)
+	for {
+		var e entity
+		key, err := it.Next(&e)
+		if err == iterator.Done {
+			// This was the last page
+			return repos, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		repoInfo := e.repoInfo()
+		if !filter.Match(repoInfo, now) {
+			continue
+		}
+		if len(repos) == limit {
+			// There is at least another page
+			return repos, next, nil
+		}
+		repos = append(repos, store.Repo{ID: ID{Key: key}, Info: repoInfo})
+		if len(repos) == limit {
+			c, err := it.Cursor()
+			if err != nil {
+				return nil, "", err
+			}
+			next = c.String()
+		}
+	}
+}
+
+// Count counts the repositories matching the filter,
+// reading them all unless the filter selects them all.
+func (db Datastore) Count(filter store.RepoFilter) (int, error) {
+	q := datastore.NewQuery(kind).Namespace(db.Namespace)
+	if filter == (store.RepoFilter{}) {
+		return db.Client.Count(db.ctx(), q)
+	}
+	now := time.Now()
+	count := 0
+	it := db.Client.Run(db.ctx(), q)
+	for {
+		var e entity
+		_, err := it.Next(&e)
+		if err == iterator.Done {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if filter.Match(e.repoInfo(), now) {
+			count++
+		}
+	}
+}
+
+// DeleteRepo deletes the chunks of timestamps, the snapshots and the stargazers of the repository with the id,
+// then the repository and the nameEntity of its name if it was renamed.
+func (db Datastore) DeleteRepo(id store.ID) error {
+	key, err := toKey(id)
+	if err != nil {
+		return err
+	}
+	var e entity
+	err = db.Client.Get(db.ctx(), key, &e)
+	if err == datastore.ErrNoSuchEntity {
+		return store.ErrNotFound
 	}
-	return datastore.Put(db.Context, repoInfoKey(db.Context), &repoInfo)
+	if err != nil {
+		return err
+	}
+	if err := db.deleteChildren(db.chunkQuery(key)); err != nil {
+		return err
+	}
+	if err := db.deleteChildren(db.snapshotQuery(key)); err != nil {
+		return err
+	}
+	if err := db.deleteChildren(db.stargazerQuery(key)); err != nil {
+		return err
+	}
+	keys := []*datastore.Key{key}
+	if !key.Equal(db.repoKey(e.Name)) {
+		keys = append(keys, db.nameKey(e.Name))
+	}
+	return db.Client.DeleteMulti(db.ctx(), keys)
+}
+
+// snapshotPart is how a part of a history.Snapshot is stored, the snapshots of the big repositories
+// don't fit in an entity. Its key is named after the time the snapshot was taken and the part.
+type snapshotPart struct {
+	Taken int64
+	Part  int
+	Data  []byte `datastore:",noindex"`
 }
 
-// PutRepo will put the informations about the Github repository in the database
-func (db Datastore) PutRepo(repoInfo github.RepoInfo, key *datastore.Key) (*datastore.Key, error) {
-	return datastore.Put(db.Context, key, &repoInfo)
+// AddSnapshot keeps the snapshot in parts of snapshotPartSize stars, replacing the one taken at the same time.
+// The parts are in the entity group of the repository and written in a transaction.
+func (db Datastore) AddSnapshot(id store.ID, snapshot history.Snapshot) error {
+	key, err := toKey(id)
+	if err != nil {
+		return err
+	}
+	var parts []*snapshotPart
+	for part, stars := 0, snapshot.Stars; part == 0 || len(stars) > 0; part++ {
+		n := len(stars)
+		if n > snapshotPartSize {
+			n = snapshotPartSize
+		}
+		data, err := history.Snapshot{Taken: snapshot.Taken, Stars: stars[:n]}.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		parts = append(parts, &snapshotPart{Taken: snapshot.Taken, Part: part, Data: data})
+		stars = stars[n:]
+	}
+
+	_, err = db.Client.RunInTransaction(db.ctx(), func(tx *datastore.Transaction) error {
+		var e entity
+		if err := tx.Get(key, &e); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return store.ErrNotFound
+			}
+			return err
+		}
+		// Replace all the parts of the snapshot taken at the same time
+		q := db.snapshotQuery(key).Filter("Taken =", snapshot.Taken).KeysOnly().Transaction(tx)
+		old, err := db.Client.GetAll(db.ctx(), q, nil)
+		if err != nil {
+			return err
+		}
+		for _, k := range old {
+			if err := tx.Delete(k); err != nil {
+				return err
+			}
+		}
+		for _, part := range parts {
+			k := datastore.NameKey(snapshotKind, fmt.Sprintf("%d-%d", part.Taken, part.Part), key)
+			k.Namespace = db.Namespace
+			if _, err := tx.Put(k, part); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, datastore.MaxAttempts(maxAttempts))
+	return err
 }
 
-// ClaimWork set the WorkedOn flag of the repoInfo to true and persist it.
-func (db Datastore) ClaimWork(repoInfo github.RepoInfo, key *datastore.Key) (*datastore.Key, error) {
-	info, _, err := db.GetRepo(repoInfo.Name)
+// Snapshots returns the snapshots taken in [from, to), joining their parts.
+// The query needs the indexes in index.yaml.
+func (db Datastore) Snapshots(id store.ID, from, to int64) ([]history.Snapshot, error) {
+	key, err := toKey(id)
 	if err != nil {
 		return nil, err
 	}
-	if info.WorkedOn {
-		return nil, store.ErrAlreadyWorkedOn
+	if err := db.checkExists(key); err != nil {
+		return nil, err
+	}
+	var parts []snapshotPart
+	q := db.snapshotQuery(key).Filter("Taken >=", from).Filter("Taken <", to).Order("Taken").Order("Part")
+	if _, err := db.Client.GetAll(db.ctx(), q, &parts); err != nil {
+		return nil, err
+	}
+	var snapshots []history.Snapshot
+	for _, part := range parts {
+		var decoded history.Snapshot
+		if err := decoded.UnmarshalBinary(part.Data); err != nil {
+			return nil, err
+		}
+		if part.Part > 0 && len(snapshots) > 0 && snapshots[len(snapshots)-1].Taken == part.Taken {
+			last := &snapshots[len(snapshots)-1]
+			last.Stars = append(last.Stars, decoded.Stars...)
+			continue
+		}
+		snapshots = append(snapshots, decoded)
+	}
+	return snapshots, nil
+}
+
+// stargazerEntity is how a github.Stargazer is stored, with the Unix time of its star.
+type stargazerEntity struct {
+	StarredAt int64
+	UserID    int
+	Login     string `datastore:",noindex"`
+	Type      string `datastore:",noindex"`
+	AvatarURL string `datastore:",noindex"`
+	HTMLURL   string `datastore:",noindex"`
+}
+
+func (s stargazerEntity) stargazer() github.Stargazer {
+	return github.Stargazer{
+		Timestamp: time.Unix(s.StarredAt, 0).UTC().Format(time.RFC3339),
+		User: github.User{
+			Login:     s.Login,
+			ID:        s.UserID,
+			Type:      s.Type,
+			AvatarURL: s.AvatarURL,
+			HTMLURL:   s.HTMLURL,
+		},
+	}
+}
+
+// PutStargazers replaces the stargazers of the repository with the id.
+// The stargazers of a repository can be too many for a transaction,
+// they are deleted then written by batches of deleteBatch.
+func (db Datastore) PutStargazers(id store.ID, stargazers []github.Stargazer) error {
+	key, err := toKey(id)
+	if err != nil {
+		return err
+	}
+	starred, err := store.SortStargazers(stargazers)
+	if err != nil {
+		return err
+	}
+	var e entity
+	err = db.Client.Get(db.ctx(), key, &e)
+	if err == datastore.ErrNoSuchEntity {
+		return store.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := db.deleteChildren(db.stargazerQuery(key)); err != nil {
+		return err
+	}
+	if err := db.putStargazers(key, starred); err != nil {
+		return err
+	}
+	if len(e.Stargazers) > 0 {
+		// The stargazers stored with the entity are replaced too
+		return db.clearStargazers(key)
+	}
+	return nil
+}
+
+// putStargazers writes the stargazers of the repository with the key by batches of deleteBatch.
+func (db Datastore) putStargazers(repoKey *datastore.Key, starred []store.Starred) error {
+	for len(starred) > 0 {
+		n := len(starred)
+		if n > deleteBatch {
+			n = deleteBatch
+		}
+		keys := make([]*datastore.Key, 0, n)
+		entities := make([]*stargazerEntity, 0, n)
+		for _, s := range starred[:n] {
+			user := s.Stargazer.User
+			k := datastore.NameKey(stargazerKind, strconv.Itoa(user.ID), repoKey)
+			k.Namespace = db.Namespace
+			keys = append(keys, k)
+			entities = append(entities, &stargazerEntity{
+				StarredAt: s.Time,
+				UserID:    user.ID,
+				Login:     user.Login,
+				Type:      user.Type,
+				AvatarURL: user.AvatarURL,
+				HTMLURL:   user.HTMLURL,
+			})
+		}
+		if _, err := db.Client.PutMulti(db.ctx(), keys, entities); err != nil {
+			return err
+		}
+		starred = starred[n:]
+	}
+	return nil
+}
+
+// migrateStargazers moves the stargazers of the repository with the key into their entities
+// if they were stored along with the repository. If the repository already has stargazer entities,
+// they are more recent and the stargazers of the repository are only dropped.
+func (db Datastore) migrateStargazers(repoKey *datastore.Key) error {
+	var e entity
+	err := db.Client.Get(db.ctx(), repoKey, &e)
+	if err == datastore.ErrNoSuchEntity {
+		return store.ErrNotFound
+	}
+	if err != nil || len(e.Stargazers) == 0 {
+		return err
+	}
+	existing, err := db.Client.GetAll(db.ctx(), db.stargazerQuery(repoKey).KeysOnly().Limit(1), nil)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		var stargazers []github.Stargazer
+		if err := json.Unmarshal(e.Stargazers, &stargazers); err != nil {
+			return fmt.Errorf("An error occured while decoding the stargazers of %s: %v", e.Name, err)
+		}
+		starred, err := store.SortStargazers(stargazers)
+		if err != nil {
+			return err
+		}
+		if err := db.putStargazers(repoKey, starred); err != nil {
+			return err
+		}
+	}
+	return db.clearStargazers(repoKey)
+}
+
+// clearStargazers removes the stargazers stored along with the repository with the key.
+func (db Datastore) clearStargazers(repoKey *datastore.Key) error {
+	_, err := db.Client.RunInTransaction(db.ctx(), func(tx *datastore.Transaction) error {
+		var e entity
+		if err := tx.Get(repoKey, &e); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return store.ErrNotFound
+			}
+			return err
+		}
+		if len(e.Stargazers) == 0 {
+			// Cleared in the meantime
+			return nil
+		}
+		e.Stargazers = nil
+		_, err := tx.Put(repoKey, &e)
+		return err
+	}, datastore.MaxAttempts(maxAttempts))
+	return err
+}
+
+// ListStargazers lists the stargazers of the repository with the id by time of star,
+// the cursor being a Datastore cursor. The query needs the indexes in index.yaml.
+func (db Datastore) ListStargazers(id store.ID, cursor string, limit int) ([]github.Stargazer, string, error) {
+	key, err := toKey(id)
+	if err != nil {
+		return nil, "", err
+	}
+	q := db.stargazerQuery(key).Order("StarredAt").Order("UserID")
+	if cursor != "" {
+		c, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", store.ErrInvalidCursor
+		}
+		q = q.Start(c)
+	}
+	limit = store.PageSize(limit)
+	if err := db.migrateStargazers(key); err != nil {
+		return nil, "", err
+	}
+
+	var stargazers []github.Stargazer
+	var next string
+	it := db.Client.Run(db.ctx(), q)
+	for {
+		var s stargazerEntity
+		_, err := it.Next(&s)
+		if err == iterator.Done {
+			// This was the last page
+			return stargazers, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if len(stargazers) == limit {
+			// There is at least another page
+			return stargazers, next, nil
+		}
+		stargazers = append(stargazers, s.stargazer())
+		if len(stargazers) == limit {
+			c, err := it.Cursor()
+			if err != nil {
+				return nil, "", err
+			}
+			next = c.String()
+		}
+	}
+}
+
+// checkExists returns store.ErrNotFound if there is no repository with the key.
+func (db Datastore) checkExists(key *datastore.Key) error {
+	var e entity
+	err := db.Client.Get(db.ctx(), key, &e)
+	if err == datastore.ErrNoSuchEntity {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (db Datastore) chunkQuery(repoKey *datastore.Key) *datastore.Query {
+	return datastore.NewQuery(chunkKind).Namespace(db.Namespace).Ancestor(repoKey)
+}
+
+func (db Datastore) snapshotQuery(repoKey *datastore.Key) *datastore.Query {
+	return datastore.NewQuery(snapshotKind).Namespace(db.Namespace).Ancestor(repoKey)
+}
+
+func (db Datastore) stargazerQuery(repoKey *datastore.Key) *datastore.Query {
+	return datastore.NewQuery(stargazerKind).Namespace(db.Namespace).Ancestor(repoKey)
+}
+
+func (db Datastore) chunkKey(repoKey *datastore.Key, seq int) *datastore.Key {
+	key := datastore.IDKey(chunkKind, int64(seq)+1, repoKey)
+	key.Namespace = db.Namespace
+	return key
+}
+
+// repoKey returns the key of the entity of the repository added with the name.
+func (db Datastore) repoKey(name string) *datastore.Key {
+	key := datastore.NameKey(kind, name, nil)
+	key.Namespace = db.Namespace
+	return key
+}
+
+// incompleteKey returns a key to allocate for the entity of a repository.
+func (db Datastore) incompleteKey() *datastore.Key {
+	key := datastore.IncompleteKey(kind, nil)
+	key.Namespace = db.Namespace
+	return key
+}
+
+// nameKey returns the key of the nameEntity of the name.
+func (db Datastore) nameKey(name string) *datastore.Key {
+	key := datastore.NameKey(nameKind, name, nil)
+	key.Namespace = db.Namespace
+	return key
+}
+
+// get gets the entity with the key outside of a transaction.
+func (db Datastore) get(key *datastore.Key, dst interface{}) error {
+	return db.Client.Get(db.ctx(), key, dst)
+}
+
+func (db Datastore) ctx() context.Context {
+	if db.Context == nil {
+		return context.Background()
 	}
-	repoInfo.WorkedOn = true
-	return datastore.Put(db.Context, key, &repoInfo)
+	return db.Context
 }
 
-// repoInfoKey returns the key used for all repoInfo entries.
-func repoInfoKey(c context.Context) *datastore.Key {
-	return datastore.NewKey(c, kind, stringID, 0, nil)
+func toKey(id store.ID) (*datastore.Key, error) {
+	key, ok := id.(ID)
+	if !ok || key.Key == nil {
+		return nil, fmt.Errorf("The id %v is not a gestore.ID", id)
+	}
+	return key.Key, nil
 }
@@ -0,0 +1,173 @@
+package gestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
+	"github.com/evermax/stargraph/lib/store"
+	"github.com/evermax/stargraph/lib/store/storetest"
+)
+
+// The tests run against the local emulator, started with
+// gcloud beta emulators datastore start --consistency=1.0
+// and $(gcloud beta emulators datastore env-init) to set DATASTORE_EMULATOR_HOST.
+const emulatorEnv = "DATASTORE_EMULATOR_HOST"
+
+var _ store.Store = Datastore{}
+
+var namespaces int64
+
+// openTestDB returns a Datastore in a namespace of its own, so the tests don't see each other's repositories.
+func openTestDB(t *testing.T) Datastore {
+	if os.Getenv(emulatorEnv) == "" {
+		t.Skipf("%s is not set, skipping the Datastore tests", emulatorEnv)
+	}
+	db, err := NewDatastore("stargraph-test")
+	if err != nil {
+		t.Fatalf("An error occured while connecting to the emulator: %v", err)
+	}
+	db.Namespace = fmt.Sprintf("test-%d-%d", time.Now().UnixNano(), atomic.AddInt64(&namespaces, 1))
+	return db
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (store.Store, func()) {
+		db := openTestDB(t)
+		return db, func() { db.Close() }
+	})
+}
+
+func TestNotFound(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	id := ID{Key: db.repoKey("evermax/nothing")}
+	if err := db.PutRepo(github.RepoInfo{Name: "evermax/nothing"}, id); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if _, err := db.ClaimWork(github.RepoInfo{Name: "evermax/nothing"}, id, "test", time.Minute); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if err := db.AppendTimestamps(id, []int64{1446285600}); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if _, err := db.TimestampChunks(id, store.AllFrom, store.AllTo); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if err := db.AddSnapshot(id, history.Snapshot{Taken: 1446285600}); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+}
+
+func TestMoveTimestamps(t *testing.T) {
+	legacy := []int64{1446285620, 1446285600, 1446285610}
+	expected := []int64{1446285600, 1446285610, 1446285620}
+	tests := []struct {
+		name     string
+		move     func(db Datastore, id store.ID) error
+		expected []int64
+	}{
+		{"read", func(db Datastore, id store.ID) error { return nil }, expected},
+		{"lease", func(db Datastore, id store.ID) error {
+			_, err := db.ClaimWork(github.RepoInfo{}, id, "test", time.Minute)
+			return err
+		}, expected},
+		{"put", func(db Datastore, id store.ID) error {
+			return db.PutRepo(github.RepoInfo{Name: "evermax/stargraph", Version: 1}, id)
+		}, expected},
+		{"append", func(db Datastore, id store.ID) error {
+			return db.AppendTimestamps(id, []int64{1446285630})
+		}, append(expected, 1446285630)},
+		{"delete", func(db Datastore, id store.ID) error {
+			return db.DeleteTimestamps(id)
+		}, nil},
+	}
+	for _, test := range tests {
+		db := openTestDB(t)
+		// An entity stored before the series has its timestamps along with it
+		key := db.repoKey("evermax/stargraph")
+		e := &entity{Name: "evermax/stargraph", LastUpdate: "2016-01-01T00:00:00Z", Version: 1, Timestamps: legacy}
+		if _, err := db.Client.Put(db.ctx(), key, e); err != nil {
+			t.Fatalf("%s: An error occured while writing the entity: %v", test.name, err)
+		}
+		id := ID{Key: key}
+		if err := test.move(db, id); err != nil {
+			t.Fatalf("%s: An error occured: %v", test.name, err)
+		}
+		timestamps, err := store.Timestamps(db, id, store.AllFrom, store.AllTo)
+		if err != nil {
+			t.Fatalf("%s: An error occured while reading the timestamps: %v", test.name, err)
+		}
+		if fmt.Sprint(timestamps) != fmt.Sprint(test.expected) {
+			t.Fatalf("%s: Expected the timestamps %v, got %v", test.name, test.expected, timestamps)
+		}
+		var stored entity
+		if err := db.Client.Get(db.ctx(), key, &stored); err != nil {
+			t.Fatalf("%s: An error occured while reading the entity: %v", test.name, err)
+		}
+		if stored.Timestamps != nil {
+			t.Fatalf("%s: The timestamps %v are still stored with the repository", test.name, stored.Timestamps)
+		}
+		db.Close()
+	}
+}
+
+func TestMoveStargazers(t *testing.T) {
+	first := github.Stargazer{Timestamp: "2015-10-31T10:00:00Z", User: github.User{Login: "first", ID: 1}}
+	second := github.Stargazer{Timestamp: "2015-10-31T10:00:20Z", User: github.User{Login: "second", ID: 2}}
+	tests := []struct {
+		name     string
+		move     func(db Datastore, id store.ID) error
+		expected []github.Stargazer
+	}{
+		{"list", func(db Datastore, id store.ID) error { return nil }, []github.Stargazer{first, second}},
+		{"lease", func(db Datastore, id store.ID) error {
+			_, err := db.ClaimWork(github.RepoInfo{}, id, "test", time.Minute)
+			return err
+		}, []github.Stargazer{first, second}},
+		{"put repository", func(db Datastore, id store.ID) error {
+			return db.PutRepo(github.RepoInfo{Name: "evermax/stargraph", Version: 1}, id)
+		}, []github.Stargazer{first, second}},
+		{"put stargazers", func(db Datastore, id store.ID) error {
+			return db.PutStargazers(id, []github.Stargazer{second})
+		}, []github.Stargazer{second}},
+	}
+	for _, test := range tests {
+		db := openTestDB(t)
+		// An entity stored before the stargazer entities has its stargazers along with it
+		key := db.repoKey("evermax/stargraph")
+		legacy, err := json.Marshal([]github.Stargazer{second, first})
+		if err != nil {
+			t.Fatalf("%s: An error occured while encoding the stargazers: %v", test.name, err)
+		}
+		e := &entity{Name: "evermax/stargraph", LastUpdate: "2016-01-01T00:00:00Z", Version: 1, Stargazers: legacy}
+		if _, err := db.Client.Put(db.ctx(), key, e); err != nil {
+			t.Fatalf("%s: An error occured while writing the entity: %v", test.name, err)
+		}
+		id := ID{Key: key}
+		if err := test.move(db, id); err != nil {
+			t.Fatalf("%s: An error occured: %v", test.name, err)
+		}
+		stargazers, err := store.AllStargazers(db, id)
+		if err != nil {
+			t.Fatalf("%s: An error occured while listing the stargazers: %v", test.name, err)
+		}
+		if fmt.Sprint(stargazers) != fmt.Sprint(test.expected) {
+			t.Fatalf("%s: Expected the stargazers %v, got %v", test.name, test.expected, stargazers)
+		}
+		var stored entity
+		if err := db.Client.Get(db.ctx(), key, &stored); err != nil {
+			t.Fatalf("%s: An error occured while reading the entity: %v", test.name, err)
+		}
+		if stored.Stargazers != nil {
+			t.Fatalf("%s: The stargazers %s are still stored with the repository", test.name, stored.Stargazers)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,420 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
+	"github.com/evermax/stargraph/lib/series"
+)
+
+// MemoryID is the ID of a repository in a Memory store.
+type MemoryID int
+
+// String returns the ID as a decimal number.
+func (id MemoryID) String() string {
+	return strconv.Itoa(int(id))
+}
+
+// Memory is a Store keeping the repositories in memory, for the tests and the CLI.
+// It has the same semantics as the database backends and is safe for concurrent use.
+type Memory struct {
+	mtx   sync.Mutex
+	seq   MemoryID
+	repos map[MemoryID]github.RepoInfo
+	names map[string]MemoryID
+	// chunks are the series of timestamps of the repositories
+	chunks map[MemoryID][]series.Chunk
+	// snapshots are the snapshots of the stargazers, sorted by time
+	snapshots map[MemoryID][]history.Snapshot
+	// stargazers are who starred the repositories and when, sorted as listed
+	stargazers map[MemoryID][]Starred
+}
+
+// NewMemory creates an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		repos:      make(map[MemoryID]github.RepoInfo),
+		names:      make(map[string]MemoryID),
+		chunks:     make(map[MemoryID][]series.Chunk),
+		snapshots:  make(map[MemoryID][]history.Snapshot),
+		stargazers: make(map[MemoryID][]Starred),
+	}
+}
+
+// GetRepo returns the repository with the name,
+// a RepoInfo with Exist = false if there is none.
+func (m *Memory) GetRepo(repo string) (github.RepoInfo, ID, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	id, ok := m.names[repo]
+	if !ok {
+		return github.RepoInfo{}, nil, nil
+	}
+	repoInfo := clone(m.repos[id])
+	repoInfo.SetExist(true)
+	return repoInfo, id, nil
+}
+
+// GetRepoByID returns the repository with the id, ErrNotFound if there is none.
+func (m *Memory) GetRepoByID(id ID) (github.RepoInfo, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return github.RepoInfo{}, err
+	}
+	repoInfo := clone(m.repos[key])
+	repoInfo.SetExist(true)
+	return repoInfo, nil
+}
+
+// ParseID decodes the String of a MemoryID.
+func (m *Memory) ParseID(s string) (ID, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return nil, ErrInvalidID
+	}
+	return MemoryID(id), nil
+}
+
+// AddRepo adds the repository, ErrAlreadyExist if there is already one with the same name.
+func (m *Memory) AddRepo(repoInfo github.RepoInfo) (ID, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if _, ok := m.names[repoInfo.Name]; ok {
+		return nil, ErrAlreadyExist
+	}
+	m.seq++
+	repoInfo.Version = 1
+	m.names[repoInfo.Name] = m.seq
+	m.repos[m.seq] = clone(repoInfo)
+	return m.seq, nil
+}
+
+// PutRepo replaces the repository with the id, ErrNotFound if there is none
+// and a *ConflictError if it was modified since it was read.
+func (m *Memory) PutRepo(repoInfo github.RepoInfo, id ID) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return err
+	}
+	old := m.repos[key]
+	if err := CheckVersion(old, repoInfo); err != nil {
+		return err
+	}
+	repoInfo.Version++
+	if old.Name != repoInfo.Name {
+		if _, ok := m.names[repoInfo.Name]; ok {
+			return ErrAlreadyExist
+		}
+		delete(m.names, old.Name)
+		m.names[repoInfo.Name] = key
+	}
+	m.repos[key] = clone(repoInfo)
+	return nil
+}
+
+// ClaimWork grants a lease on the work on the repository with the id to the owner,
+// ErrAlreadyWorkedOn if somebody else holds an unexpired lease.
+func (m *Memory) ClaimWork(repoInfo github.RepoInfo, id ID, owner string, ttl time.Duration) (Lease, error) {
+	return m.lease(id, func(info *github.RepoInfo) (Lease, error) {
+		return GrantLease(info, owner, ttl, time.Now())
+	})
+}
+
+// RenewLease extends the lease of the owner, ErrLeaseLost if the owner doesn't hold it anymore.
+func (m *Memory) RenewLease(id ID, owner string, ttl time.Duration) (Lease, error) {
+	return m.lease(id, func(info *github.RepoInfo) (Lease, error) {
+		return ExtendLease(info, owner, ttl, time.Now())
+	})
+}
+
+// ReleaseWork gives the work back, ErrLeaseLost if the owner doesn't hold the lease anymore.
+func (m *Memory) ReleaseWork(id ID, owner string) error {
+	_, err := m.lease(id, func(info *github.RepoInfo) (Lease, error) {
+		return Lease{}, ClearLease(info, owner)
+	})
+	return err
+}
+
+// lease applies the change to the lease of the repository with the id.
+func (m *Memory) lease(id ID, change func(*github.RepoInfo) (Lease, error)) (Lease, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return Lease{}, err
+	}
+	info := m.repos[key]
+	lease, err := change(&info)
+	if err != nil {
+		return Lease{}, err
+	}
+	info.Version++
+	m.repos[key] = info
+	return lease, nil
+}
+
+// AppendTimestamps appends the timestamps to the series of the repository with the id.
+func (m *Memory) AppendTimestamps(id ID, timestamps []int64) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return err
+	}
+	stored := m.chunks[key]
+	var last *series.Chunk
+	if len(stored) > 0 {
+		last = &stored[len(stored)-1]
+	}
+	chunks, err := series.Append(last, timestamps)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if chunk.Seq < len(stored) {
+			stored[chunk.Seq] = chunk
+		} else {
+			stored = append(stored, chunk)
+		}
+	}
+	m.chunks[key] = stored
+	return nil
+}
+
+// TimestampChunks returns the chunks of the series of the repository with the id overlapping [from, to).
+func (m *Memory) TimestampChunks(id ID, from, to int64) ([]series.Chunk, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []series.Chunk
+	for _, chunk := range m.chunks[key] {
+		if chunk.Overlaps(from, to) {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks, nil
+}
+
+// DeleteTimestamps deletes the series of the repository with the id.
+func (m *Memory) DeleteTimestamps(id ID) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return err
+	}
+	delete(m.chunks, key)
+	return nil
+}
+
+// ReplaceTimestamps replaces the series of the repository with the id with the timestamps.
+func (m *Memory) ReplaceTimestamps(id ID, timestamps []int64) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return err
+	}
+	chunks, err := series.Append(nil, timestamps)
+	if err != nil {
+		return err
+	}
+	m.chunks[key] = chunks
+	return nil
+}
+
+// AddSnapshot keeps the snapshot, replacing the one taken at the same time.
+func (m *Memory) AddSnapshot(id ID, snapshot history.Snapshot) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return err
+	}
+	snapshot.Stars = append([]history.Star(nil), snapshot.Stars...)
+	snapshots := m.snapshots[key]
+	i := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i].Taken >= snapshot.Taken
+	})
+	if i < len(snapshots) && snapshots[i].Taken == snapshot.Taken {
+		snapshots[i] = snapshot
+		return nil
+	}
+	snapshots = append(snapshots, history.Snapshot{})
+	copy(snapshots[i+1:], snapshots[i:])
+	snapshots[i] = snapshot
+	m.snapshots[key] = snapshots
+	return nil
+}
+
+// Snapshots returns the snapshots taken in [from, to).
+func (m *Memory) Snapshots(id ID, from, to int64) ([]history.Snapshot, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []history.Snapshot
+	for _, snapshot := range m.snapshots[key] {
+		if snapshot.Taken >= from && snapshot.Taken < to {
+			snapshot.Stars = append([]history.Star(nil), snapshot.Stars...)
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+// ListRepos lists the repositories matching the filter by order of addition,
+// the cursor being the ID of the last repository of the previous page.
+func (m *Memory) ListRepos(filter RepoFilter, cursor string, limit int) ([]Repo, string, error) {
+	var after MemoryID
+	if cursor != "" {
+		id, err := strconv.Atoi(cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		after = MemoryID(id)
+	}
+	limit = PageSize(limit)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	ids := make([]int, 0, len(m.repos))
+	for id := range m.repos {
+		if id > after {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+	now := time.Now()
+	var repos []Repo
+	var last MemoryID
+	for _, id := range ids {
+		repoInfo := m.repos[MemoryID(id)]
+		if !filter.Match(repoInfo, now) {
+			continue
+		}
+		if len(repos) == limit {
+			// There is at least another page
+			return repos, last.String(), nil
+		}
+		repoInfo = clone(repoInfo)
+		repoInfo.SetExist(true)
+		repos = append(repos, Repo{ID: MemoryID(id), Info: repoInfo})
+		last = MemoryID(id)
+	}
+	return repos, "", nil
+}
+
+// Count counts the repositories matching the filter.
+func (m *Memory) Count(filter RepoFilter) (int, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	now := time.Now()
+	count := 0
+	for _, repoInfo := range m.repos {
+		if filter.Match(repoInfo, now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteRepo deletes the repository with the id, its timestamps, its snapshots and its stargazers.
+func (m *Memory) DeleteRepo(id ID) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return err
+	}
+	delete(m.names, m.repos[key].Name)
+	delete(m.repos, key)
+	delete(m.chunks, key)
+	delete(m.snapshots, key)
+	delete(m.stargazers, key)
+	return nil
+}
+
+// PutStargazers replaces the stargazers of the repository with the id.
+func (m *Memory) PutStargazers(id ID, stargazers []github.Stargazer) error {
+	starred, err := SortStargazers(stargazers)
+	if err != nil {
+		return err
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return err
+	}
+	m.stargazers[key] = starred
+	return nil
+}
+
+// ListStargazers lists the stargazers of the repository with the id by time of star,
+// the cursor being the one of the last stargazer of the previous page.
+func (m *Memory) ListStargazers(id ID, cursor string, limit int) ([]github.Stargazer, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key, err := m.key(id)
+	if err != nil {
+		return nil, "", err
+	}
+	starred := m.stargazers[key]
+	start := 0
+	if cursor != "" {
+		time, userID, err := ParseStargazerCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(starred), func(i int) bool {
+			return starred[i].After(time, userID)
+		})
+	}
+	limit = PageSize(limit)
+	end := start + limit
+	if end > len(starred) {
+		end = len(starred)
+	}
+	stargazers := make([]github.Stargazer, 0, end-start)
+	for _, s := range starred[start:end] {
+		stargazers = append(stargazers, s.Stargazer)
+	}
+	if end == len(starred) {
+		return stargazers, "", nil
+	}
+	return stargazers, starred[end-1].Cursor(), nil
+}
+
+// key checks that the id is the one of a repository of the store.
+func (m *Memory) key(id ID) (MemoryID, error) {
+	key, ok := id.(MemoryID)
+	if !ok {
+		return 0, fmt.Errorf("The id %v is not a store.MemoryID", id)
+	}
+	if _, ok := m.repos[key]; !ok {
+		return 0, ErrNotFound
+	}
+	return key, nil
+}
+
+// clone returns the repository as stored, the timestamps are not kept with the repository.
+func clone(repoInfo github.RepoInfo) github.RepoInfo {
+	repoInfo.Timestamps = nil
+	repoInfo.SetExist(false)
+	return repoInfo
+}
@@ -0,0 +1,16 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/evermax/stargraph/lib/store"
+	"github.com/evermax/stargraph/lib/store/storetest"
+)
+
+var _ store.Store = store.NewMemory()
+
+func TestMemoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (store.Store, func()) {
+		return store.NewMemory(), func() {}
+	})
+}
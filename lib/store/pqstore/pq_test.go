@@ -0,0 +1,221 @@
+package pqstore
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
+	"github.com/evermax/stargraph/lib/store"
+	"github.com/evermax/stargraph/lib/store/storetest"
+)
+
+// postgresEnv is the environment variable holding the URL of the database to test against,
+// for instance postgres://postgres@localhost/stargraph_test?sslmode=disable.
+// The tables of the database are dropped.
+const postgresEnv = "STARGRAPH_POSTGRES_URL"
+
+func openTestDB(t *testing.T) Postgres {
+	url := os.Getenv(postgresEnv)
+	if url == "" {
+		t.Skipf("%s is not set, skipping the PostgreSQL tests", postgresEnv)
+	}
+	p, err := Open(url)
+	if err != nil {
+		t.Fatalf("An error occured while opening the database: %v", err)
+	}
+	dropTables(t, p)
+	if err := p.Migrate(); err != nil {
+		t.Fatalf("An error occured while migrating the database: %v", err)
+	}
+	return p
+}
+
+// dropTables drops the tables of the schema, the ones referencing the repositories first.
+func dropTables(t *testing.T, p Postgres) {
+	if _, err := p.DB.Exec(`DROP TABLE IF EXISTS stargazers, snapshots, timestamp_chunks, repos, schema_migrations`); err != nil {
+		t.Fatalf("An error occured while cleaning the database: %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	p := openTestDB(t)
+	defer p.Close()
+
+	// Migrating again doesn't apply the migrations twice
+	if err := p.Migrate(); err != nil {
+		t.Fatalf("An error occured while migrating again: %v", err)
+	}
+	var version int
+	if err := p.DB.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatalf("An error occured while reading the version: %v", err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("Expected version %d, got %d", len(migrations), version)
+	}
+}
+
+// migrateTo recreates the schema at the version, and returns the transaction applying it
+// so the test can add the rows of that version before committing.
+func migrateTo(t *testing.T, p Postgres, version int) *sql.Tx {
+	dropTables(t, p)
+	if _, err := p.DB.Exec(`CREATE TABLE schema_migrations (version INTEGER NOT NULL)`); err != nil {
+		t.Fatalf("An error occured while creating the migrations table: %v", err)
+	}
+	tx, err := p.DB.Begin()
+	if err != nil {
+		t.Fatalf("An error occured while beginning the transaction: %v", err)
+	}
+	for i, migration := range migrations[:version] {
+		if err := migration(tx); err != nil {
+			tx.Rollback()
+			t.Fatalf("An error occured while applying the migration %d: %v", i+1, err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		tx.Rollback()
+		t.Fatalf("An error occured while setting the version: %v", err)
+	}
+	return tx
+}
+
+func TestMigrateTimestamps(t *testing.T) {
+	p := openTestDB(t)
+	defer p.Close()
+
+	// A database with the repositories crawled before the series, at the version 3
+	tx := migrateTo(t, p, 3)
+	defer tx.Rollback()
+	var id ID
+	err := tx.QueryRow(`INSERT INTO repos (name, last_update, timestamps) VALUES ($1, $2, $3) RETURNING id`,
+		"evermax/stargraph", "2016-01-01T00:00:00Z", pq.Array([]int64{1446285620, 1446285600, 1446285610})).Scan(&id)
+	if err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO repos (name) VALUES ('evermax/empty')`); err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("An error occured while committing: %v", err)
+	}
+
+	if err := p.Migrate(); err != nil {
+		t.Fatalf("An error occured while migrating the database: %v", err)
+	}
+	timestamps, err := store.Timestamps(p, id, store.AllFrom, store.AllTo)
+	if err != nil {
+		t.Fatalf("An error occured while reading the timestamps: %v", err)
+	}
+	expected := []int64{1446285600, 1446285610, 1446285620}
+	if fmt.Sprint(timestamps) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the timestamps %v to be moved to the series, got %v", expected, timestamps)
+	}
+}
+
+func TestMigrateStargazers(t *testing.T) {
+	p := openTestDB(t)
+	defer p.Close()
+
+	// A database with the stargazers stored along with the repositories, at the version 6
+	tx := migrateTo(t, p, 6)
+	defer tx.Rollback()
+	var id ID
+	err := tx.QueryRow(`INSERT INTO repos (name, last_update, stargazers) VALUES ($1, $2, $3) RETURNING id`,
+		"evermax/stargraph", "2016-01-01T00:00:00Z",
+		`[{"starred_at":"2015-10-31T10:00:20Z","user":{"login":"second","id":2,"type":"User"}},
+		{"starred_at":"2015-10-31T10:00:00Z","user":{"login":"first","id":1,"type":"User"}}]`).Scan(&id)
+	if err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO repos (name) VALUES ('evermax/empty')`); err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("An error occured while committing: %v", err)
+	}
+
+	if err := p.Migrate(); err != nil {
+		t.Fatalf("An error occured while migrating the database: %v", err)
+	}
+	stargazers, err := store.AllStargazers(p, id)
+	if err != nil {
+		t.Fatalf("An error occured while listing the stargazers: %v", err)
+	}
+	expected := []github.Stargazer{
+		{Timestamp: "2015-10-31T10:00:00Z", User: github.User{Login: "first", ID: 1, Type: "User"}},
+		{Timestamp: "2015-10-31T10:00:20Z", User: github.User{Login: "second", ID: 2, Type: "User"}},
+	}
+	if fmt.Sprint(stargazers) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the stargazers %v to be moved to their table, got %v", expected, stargazers)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (store.Store, func()) {
+		p := openTestDB(t)
+		return p, func() { p.Close() }
+	})
+}
+
+func TestNotFound(t *testing.T) {
+	p := openTestDB(t)
+	defer p.Close()
+
+	if err := p.PutRepo(github.RepoInfo{Name: "evermax/stargraph"}, ID(-1)); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if _, err := p.ClaimWork(github.RepoInfo{Name: "evermax/stargraph"}, ID(-1), "test", time.Minute); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if err := p.AppendTimestamps(ID(-1), []int64{1446285600}); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if _, err := p.TimestampChunks(ID(-1), store.AllFrom, store.AllTo); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if err := p.AddSnapshot(ID(-1), history.Snapshot{Taken: 1446285600}); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+}
+
+func TestListReposNeverUpdated(t *testing.T) {
+	p := openTestDB(t)
+	defer p.Close()
+
+	// Mostly repositories never updated, whose last update can't be cast to a time
+	for i := 0; i < 100; i++ {
+		if _, err := p.AddRepo(github.RepoInfo{Name: fmt.Sprintf("evermax/never-%d", i)}); err != nil {
+			t.Fatalf("An error occured while adding the repository: %v", err)
+		}
+	}
+	updated := time.Date(2015, 10, 31, 10, 0, 0, 0, time.UTC)
+	if _, err := p.AddRepo(github.RepoInfo{Name: "evermax/stargraph", LastUpdate: updated.Format(time.RFC3339)}); err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+
+	tests := []struct {
+		before   time.Time
+		expected int
+	}{
+		{updated, 100},
+		{updated.Add(time.Second), 101},
+	}
+	for i, test := range tests {
+		filter := store.RepoFilter{UpdatedBefore: test.before}
+		count, err := p.Count(filter)
+		if err != nil || count != test.expected {
+			t.Fatalf("Test %d: expected %d repositories, got %d (%v)", i, test.expected, count, err)
+		}
+		repos, _, err := p.ListRepos(filter, "", 200)
+		if err != nil || len(repos) != test.expected {
+			t.Fatalf("Test %d: expected to list %d repositories, got %d (%v)", i, test.expected, len(repos), err)
+		}
+	}
+}
+
+var _ store.Store = Postgres{}
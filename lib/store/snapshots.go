@@ -0,0 +1,36 @@
+package store
+
+import (
+	"time"
+
+	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
+)
+
+// TakeSnapshot keeps the snapshot of the stargazers of the repository with the id at the time taken.
+func TakeSnapshot(s Store, id ID, taken time.Time, stargazers []github.Stargazer) error {
+	snapshot, err := history.NewSnapshot(taken, stargazers)
+	if err != nil {
+		return err
+	}
+	return s.AddSnapshot(id, snapshot)
+}
+
+// NetStars returns the net stars over time of the repository with the id,
+// computed from all its snapshots, so going down when stargazers unstarred it.
+func NetStars(s Store, id ID) ([]history.Point, error) {
+	snapshots, err := s.Snapshots(id, AllFrom, AllTo)
+	if err != nil {
+		return nil, err
+	}
+	return history.Net(snapshots), nil
+}
+
+// Unstars returns the unstars of the repository with the id found between its snapshots.
+func Unstars(s Store, id ID) ([]history.Unstar, error) {
+	snapshots, err := s.Snapshots(id, AllFrom, AllTo)
+	if err != nil {
+		return nil, err
+	}
+	return history.Unstars(snapshots), nil
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/evermax/stargraph/github"
+)
+
+// Starred is a stargazer along with the Unix time of its star.
+// The stores list the stargazers by Time, then by id of the user.
+type Starred struct {
+	Time      int64
+	Stargazer github.Stargazer
+}
+
+// Cursor returns the cursor of the page of ListStargazers following the stargazer,
+// for the stores paging by time and id of the user.
+func (s Starred) Cursor() string {
+	return fmt.Sprintf("%d:%d", s.Time, s.Stargazer.User.ID)
+}
+
+// After tells whether the stargazer is listed after the one of the time and user id.
+func (s Starred) After(time int64, userID int) bool {
+	return s.Time > time || (s.Time == time && s.Stargazer.User.ID > userID)
+}
+
+// ParseStargazerCursor decodes a cursor given by Starred.Cursor,
+// ErrInvalidCursor if it isn't one.
+func ParseStargazerCursor(cursor string) (time int64, userID int, err error) {
+	parts := strings.SplitN(cursor, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidCursor
+	}
+	if time, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	if userID, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	return time, userID, nil
+}
+
+// SortStargazers returns the stargazers with the time of their star, sorted as listed by ListStargazers.
+// A user is only kept once, with its first star: the pages of Github can shift during a crawl.
+func SortStargazers(stargazers []github.Stargazer) ([]Starred, error) {
+	starred := make([]Starred, 0, len(stargazers))
+	for _, stargazer := range stargazers {
+		time, err := stargazer.GetTimestamp()
+		if err != nil {
+			return nil, err
+		}
+		starred = append(starred, Starred{Time: time, Stargazer: stargazer})
+	}
+	sort.Stable(byTime(starred))
+
+	seen := make(map[int]bool, len(starred))
+	unique := starred[:0]
+	for _, s := range starred {
+		if seen[s.Stargazer.User.ID] {
+			continue
+		}
+		seen[s.Stargazer.User.ID] = true
+		unique = append(unique, s)
+	}
+	return unique, nil
+}
+
+type byTime []Starred
+
+func (s byTime) Len() int      { return len(s) }
+func (s byTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byTime) Less(i, j int) bool {
+	return s[j].After(s[i].Time, s[i].Stargazer.User.ID)
+}
+
+// AllStargazers returns all the stargazers of the repository with the id, reading all the pages.
+func AllStargazers(s Store, id ID) ([]github.Stargazer, error) {
+	var stargazers []github.Stargazer
+	cursor := ""
+	for {
+		page, next, err := s.ListStargazers(id, cursor, 0)
+		if err != nil {
+			return nil, err
+		}
+		stargazers = append(stargazers, page...)
+		if next == "" {
+			return stargazers, nil
+		}
+		cursor = next
+	}
+}
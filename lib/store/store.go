@@ -2,8 +2,11 @@ package store
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
+	"github.com/evermax/stargraph/lib/series"
 )
 
 var (
@@ -11,13 +14,55 @@ var (
 	ErrAlreadyExist = fmt.Errorf("Repository already stored in database")
 	// ErrAlreadyWorkedOn express the fact that the repository is already claim to work on.
 	ErrAlreadyWorkedOn = fmt.Errorf("Repository already claimed in database")
+	// ErrNotFound is returned when the repository to modify is not in the database.
+	ErrNotFound = fmt.Errorf("Repository not found in database")
+	// ErrLeaseLost is returned when renewing or releasing a lease that the owner doesn't hold anymore,
+	// because it expired and the work was claimed by someone else.
+	ErrLeaseLost = fmt.Errorf("Lease on the repository lost")
+	// ErrInvalidCursor is returned by ListRepos and ListStargazers when the cursor wasn't returned by the store.
+	ErrInvalidCursor = fmt.Errorf("Invalid cursor to list the repositories or the stargazers")
+	// ErrInvalidID is returned by ParseID when the string is not the ID of a repository of the store.
+	ErrInvalidID = fmt.Errorf("Invalid id of a repository")
 )
 
+// ConflictError is returned by PutRepo when the repository was modified since it was read,
+// so its stored Version is not the one of the RepoInfo put anymore.
+// The repository must be read again before retrying.
+type ConflictError struct {
+	Name     string
+	Expected int64
+	Actual   int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("Repository %s modified concurrently, expected the version %d, got %d", e.Name, e.Expected, e.Actual)
+}
+
+// IsConflict tells whether the error is a *ConflictError.
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// CheckVersion returns a *ConflictError if the version of the repository put is not the stored one.
+// It is meant for the backends that read and write the whole RepoInfo in a transaction.
+func CheckVersion(stored, put github.RepoInfo) error {
+	if stored.Version != put.Version {
+		return &ConflictError{Name: put.Name, Expected: put.Version, Actual: stored.Version}
+	}
+	return nil
+}
+
+// DefaultPageSize is the number of repositories or stargazers listed by ListRepos and ListStargazers
+// when the limit is not positive.
+const DefaultPageSize = 100
+
 // ID interface holds whatever system of id the underlying
-// database uses.
-// TODO complete this to something that actually makes sense
+// database uses. String encodes it and the ParseID method of the store
+// it comes from decodes it, so that it can be logged, put in the messages
+// and sent through the API.
 type ID interface {
-	Test() string
+	String() string
 }
 
 // Store interface allows this service to rely on different types of databases.
@@ -25,10 +70,154 @@ type ID interface {
 // At first, you might what to deploy everthing on a simple computer with a postgres instance.
 // Then, the easiest might be to migrate to AWS or Google Cloud and have different type of database.
 // In any case, the rest of the project only needs a simple interface for that, the Store interface.
-// TODO add a RemoveRepo method.
+//
+// ParseID decodes the String of an ID given by the store, ErrInvalidID if it isn't one,
+// and GetRepoByID returns the repository with the id, ErrNotFound if there is none.
+//
+// The repositories are versioned: AddRepo stores them with the Version 1 and every write,
+// the leases included, increments it. PutRepo only writes the repository if its Version
+// is still the stored one, otherwise it returns a *ConflictError.
+//
+// The work on a repository is claimed with a lease: ClaimWork grants it to an owner
+// for a while, returning ErrAlreadyWorkedOn while somebody else holds an unexpired lease.
+// Long crawls renew their lease with RenewLease, and the work is given back with
+// ReleaseWork or a PutRepo clearing the lease. If the owner crashes, its lease
+// expires and the work can be claimed again.
+//
+// The timestamps of the stars are not stored with the repository: AddRepo and PutRepo
+// ignore RepoInfo.Timestamps and GetRepo doesn't fill it. They are appended to a series
+// of chunks with AppendTimestamps and the chunks overlapping a time range are read with
+// TimestampChunks, see the Timestamps, CountTimestamps and AggregateTimestamps helpers.
+// ReplaceTimestamps replaces the series in one operation, the readers never see it empty
+// while it is replaced, and DeleteTimestamps deletes it.
+//
+// ListRepos lists the repositories matching the filter with their ids by pages of at most limit repositories,
+// in an order that is up to the store. It returns the cursor of the next page, empty for the last one.
+// Count counts the repositories matching the filter and DeleteRepo removes a repository
+// and everything stored about it, ErrNotFound if there is none with the id.
+//
+// AddSnapshot keeps a snapshot of the stargazers of a repository, replacing the one taken
+// at the same time if any, and Snapshots returns the snapshots taken in [from, to)
+// sorted by the time they were taken. See the NetStars helper.
+//
+// Who starred a repository and when isn't stored with the repository either: PutStargazers
+// replaces the stargazers of a repository and ListStargazers lists them like ListRepos,
+// sorted by time of star then by id of the user (see SortStargazers). A user is listed once.
+// The snapshots and the stargazers are deleted along with the repository.
 type Store interface {
 	AddRepo(github.RepoInfo) (ID, error)
 	GetRepo(string) (github.RepoInfo, ID, error)
+	GetRepoByID(id ID) (github.RepoInfo, error)
+	ParseID(s string) (ID, error)
 	PutRepo(github.RepoInfo, ID) error
-	ClaimWork(github.RepoInfo, ID) error
+	ClaimWork(repo github.RepoInfo, id ID, owner string, ttl time.Duration) (Lease, error)
+	RenewLease(id ID, owner string, ttl time.Duration) (Lease, error)
+	ReleaseWork(id ID, owner string) error
+	AppendTimestamps(id ID, timestamps []int64) error
+	TimestampChunks(id ID, from, to int64) ([]series.Chunk, error)
+	DeleteTimestamps(id ID) error
+	ReplaceTimestamps(id ID, timestamps []int64) error
+	ListRepos(filter RepoFilter, cursor string, limit int) ([]Repo, string, error)
+	Count(filter RepoFilter) (int, error)
+	DeleteRepo(id ID) error
+	AddSnapshot(id ID, snapshot history.Snapshot) error
+	Snapshots(id ID, from, to int64) ([]history.Snapshot, error)
+	PutStargazers(id ID, stargazers []github.Stargazer) error
+	ListStargazers(id ID, cursor string, limit int) ([]github.Stargazer, string, error)
+}
+
+// Repo is a repository listed by ListRepos along with its id in the store.
+type Repo struct {
+	ID   ID
+	Info github.RepoInfo
+}
+
+// RepoFilter selects the repositories to list or count, the zero value selects them all.
+type RepoFilter struct {
+	// UpdatedBefore, if not zero, selects the repositories updated before it or never updated.
+	UpdatedBefore time.Time
+	// WorkedOn, if not nil, selects the repositories with an unexpired lease on the work on them if true,
+	// the others if false.
+	WorkedOn *bool
+	// MinStars and MaxStars select the repositories with a star count in the range,
+	// there is no maximum if MaxStars is 0.
+	MinStars int
+	MaxStars int
+}
+
+// Match tells whether the repository is selected by the filter at the time now.
+// It is meant for the backends that can't filter in their queries.
+func (f RepoFilter) Match(repoInfo github.RepoInfo, now time.Time) bool {
+	if !f.UpdatedBefore.IsZero() && repoInfo.LastUpdate != "" {
+		lastUpdate, err := time.Parse(time.RFC3339, repoInfo.LastUpdate)
+		if err == nil && !lastUpdate.Before(f.UpdatedBefore) {
+			return false
+		}
+	}
+	if f.WorkedOn != nil && *f.WorkedOn != Leased(repoInfo, now) {
+		return false
+	}
+	if repoInfo.Count < f.MinStars || (f.MaxStars > 0 && repoInfo.Count > f.MaxStars) {
+		return false
+	}
+	return true
+}
+
+// Leased tells whether somebody holds an unexpired lease on the work on the repository at the time now.
+func Leased(repoInfo github.RepoInfo, now time.Time) bool {
+	return repoInfo.WorkedOn && now.Before(repoInfo.LeaseExpiry)
+}
+
+// PageSize returns the limit of ListRepos and ListStargazers, DefaultPageSize if it is not positive.
+func PageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	return limit
+}
+
+// Lease is the right of Owner to work on a repository until Expiry.
+type Lease struct {
+	Owner  string
+	Expiry time.Time
+}
+
+// GrantLease gives the work on the repository to the owner until now + ttl.
+// It returns ErrAlreadyWorkedOn if somebody holds an unexpired lease on it.
+// It is meant for the backends that read and write the whole RepoInfo in a transaction.
+func GrantLease(repoInfo *github.RepoInfo, owner string, ttl time.Duration, now time.Time) (Lease, error) {
+	// The repositories claimed before the leases have no expiry, they are reclaimed too
+	if Leased(*repoInfo, now) {
+		return Lease{}, ErrAlreadyWorkedOn
+	}
+	return setLease(repoInfo, owner, now.Add(ttl)), nil
+}
+
+// ExtendLease extends the lease of the owner on the repository until now + ttl.
+// It returns ErrLeaseLost if the owner doesn't hold the lease.
+// An expired lease can still be extended if nobody claimed the work since.
+func ExtendLease(repoInfo *github.RepoInfo, owner string, ttl time.Duration, now time.Time) (Lease, error) {
+	if !repoInfo.WorkedOn || repoInfo.LeaseOwner != owner {
+		return Lease{}, ErrLeaseLost
+	}
+	return setLease(repoInfo, owner, now.Add(ttl)), nil
+}
+
+// ClearLease gives the work on the repository back if the owner holds the lease,
+// otherwise it returns ErrLeaseLost.
+func ClearLease(repoInfo *github.RepoInfo, owner string) error {
+	if !repoInfo.WorkedOn || repoInfo.LeaseOwner != owner {
+		return ErrLeaseLost
+	}
+	repoInfo.WorkedOn = false
+	repoInfo.LeaseOwner = ""
+	repoInfo.LeaseExpiry = time.Time{}
+	return nil
+}
+
+func setLease(repoInfo *github.RepoInfo, owner string, expiry time.Time) Lease {
+	repoInfo.WorkedOn = true
+	repoInfo.LeaseOwner = owner
+	repoInfo.LeaseExpiry = expiry
+	return Lease{Owner: owner, Expiry: expiry}
 }
@@ -0,0 +1,1124 @@
+// Package storetest contains the conformance tests every store.Store implementation must pass.
+// In the tests of a backend, call Run with a function opening an empty store:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) (store.Store, func()) {
+//			db := openEmptyDB(t)
+//			return db, func() { db.Close() }
+//		})
+//	}
+package storetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/history"
+	"github.com/evermax/stargraph/lib/series"
+	"github.com/evermax/stargraph/lib/store"
+)
+
+// Opener opens an empty store for one test, and returns it
+// along with a function to close it once the test is done.
+type Opener func(t *testing.T) (store.Store, func())
+
+// Run runs the conformance tests on the stores given by open, each in a subtest.
+func Run(t *testing.T, open Opener) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s store.Store)
+	}{
+		{"GetMissingRepo", testGetMissingRepo},
+		{"AddGetRepo", testAddGetRepo},
+		{"AddRepoAlreadyExist", testAddRepoAlreadyExist},
+		{"ParseID", testParseID},
+		{"GetRepoByID", testGetRepoByID},
+		{"PutRepo", testPutRepo},
+		{"PutRepoUnknownID", testPutRepoUnknownID},
+		{"PutRepoConflict", testPutRepoConflict},
+		{"PutRepoRename", testPutRepoRename},
+		{"PutRepoConcurrent", testPutRepoConcurrent},
+		{"ClaimWork", testClaimWork},
+		{"ClaimWorkConcurrent", testClaimWorkConcurrent},
+		{"ClaimWorkAfterPut", testClaimWorkAfterPut},
+		{"ClaimWorkExpired", testClaimWorkExpired},
+		{"ClaimWorkLegacy", testClaimWorkLegacy},
+		{"RenewLease", testRenewLease},
+		{"ReleaseWork", testReleaseWork},
+		{"AppendTimestamps", testAppendTimestamps},
+		{"AppendTimestampsOutOfOrder", testAppendTimestampsOutOfOrder},
+		{"TimestampsRange", testTimestampsRange},
+		{"DeleteTimestamps", testDeleteTimestamps},
+		{"ReplaceTimestamps", testReplaceTimestamps},
+		{"TimestampsUnknownID", testTimestampsUnknownID},
+		{"ListRepos", testListRepos},
+		{"ListReposFilter", testListReposFilter},
+		{"ListReposInvalidCursor", testListReposInvalidCursor},
+		{"DeleteRepo", testDeleteRepo},
+		{"Snapshots", testSnapshots},
+		{"SnapshotsUnknownID", testSnapshotsUnknownID},
+		{"SnapshotsDelete", testSnapshotsDelete},
+		{"Stargazers", testStargazers},
+		{"StargazersPages", testStargazersPages},
+		{"StargazersDelete", testStargazersDelete},
+		{"StargazersUnknownID", testStargazersUnknownID},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			s, closer := open(t)
+			defer closer()
+			test.test(t, s)
+		})
+	}
+}
+
+// foreignID is an ID that no store gave, using it must fail.
+type foreignID struct{}
+
+func (id foreignID) String() string {
+	return "foreign"
+}
+
+func sampleRepo() github.RepoInfo {
+	return github.RepoInfo{
+		ID:           42,
+		Name:         "evermax/stargraph",
+		FullName:     "evermax/stargraph",
+		Count:        2,
+		CreationDate: "2015-10-30T10:00:00Z",
+		StarsURL:     "https://api.github.com/repositories/42/stargazers",
+	}
+}
+
+func mustAdd(t *testing.T, s store.Store, repoInfo github.RepoInfo) store.ID {
+	id, err := s.AddRepo(repoInfo)
+	if err != nil {
+		t.Fatalf("An error occured while adding %s: %v", repoInfo.Name, err)
+	}
+	if id == nil {
+		t.Fatalf("AddRepo returned no id for %s", repoInfo.Name)
+	}
+	return id
+}
+
+func mustGet(t *testing.T, s store.Store, name string) (github.RepoInfo, store.ID) {
+	repoInfo, id, err := s.GetRepo(name)
+	if err != nil {
+		t.Fatalf("An error occured while getting %s: %v", name, err)
+	}
+	if !repoInfo.Exist() {
+		t.Fatalf("The repository %s should exist", name)
+	}
+	return repoInfo, id
+}
+
+func testGetMissingRepo(t *testing.T, s store.Store) {
+	repoInfo, _, err := s.GetRepo("evermax/nothing")
+	if err != nil {
+		t.Fatalf("A missing repository shouldn't be an error, got %v", err)
+	}
+	if repoInfo.Exist() {
+		t.Fatal("A missing repository shouldn't exist")
+	}
+}
+
+func testAddGetRepo(t *testing.T, s store.Store) {
+	expected := sampleRepo()
+	id := mustAdd(t, s, expected)
+
+	repoInfo, gotID := mustGet(t, s, expected.Name)
+	if gotID.String() != id.String() {
+		t.Fatalf("Expected the id %s, got %s", id, gotID)
+	}
+	if repoInfo.ID != expected.ID || repoInfo.FullName != expected.FullName || repoInfo.Count != expected.Count ||
+		repoInfo.CreationDate != expected.CreationDate || repoInfo.StarsURL != expected.StarsURL || repoInfo.WorkedOn {
+		t.Fatalf("Expected %+v, got %+v", expected, repoInfo)
+	}
+}
+
+func testAddRepoAlreadyExist(t *testing.T, s store.Store) {
+	mustAdd(t, s, sampleRepo())
+	if _, err := s.AddRepo(sampleRepo()); err != store.ErrAlreadyExist {
+		t.Fatalf("Expected %v, got %v", store.ErrAlreadyExist, err)
+	}
+
+	other := sampleRepo()
+	other.ID, other.Name = 43, "evermax/other"
+	mustAdd(t, s, other)
+}
+
+func testParseID(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	parsed, err := s.ParseID(id.String())
+	if err != nil {
+		t.Fatalf("An error occured while parsing the id %s: %v", id, err)
+	}
+	if parsed.String() != id.String() {
+		t.Fatalf("Expected the id %s, got %s", id, parsed)
+	}
+	// The parsed id can be used in place of the one given by the store
+	if err := s.AppendTimestamps(parsed, []int64{1446285600}); err != nil {
+		t.Fatalf("An error occured while using the parsed id: %v", err)
+	}
+
+	for _, invalid := range []string{"", "evermax/stargraph", "-1", "0"} {
+		if _, err := s.ParseID(invalid); err != store.ErrInvalidID {
+			t.Fatalf("Parsing %q: expected %v, got %v", invalid, store.ErrInvalidID, err)
+		}
+	}
+}
+
+func testGetRepoByID(t *testing.T, s store.Store) {
+	expected := sampleRepo()
+	id := mustAdd(t, s, expected)
+
+	repoInfo, err := s.GetRepoByID(id)
+	if err != nil {
+		t.Fatalf("An error occured while getting the repository %s: %v", id, err)
+	}
+	byName, _ := mustGet(t, s, expected.Name)
+	if !repoInfo.Exist() || repoInfo.Name != expected.Name || repoInfo.ID != expected.ID || repoInfo.Version != byName.Version {
+		t.Fatalf("Expected %+v, got %+v", byName, repoInfo)
+	}
+
+	if _, err := s.GetRepoByID(foreignID{}); err == nil {
+		t.Fatal("Getting a repository with an id the store didn't give should fail")
+	}
+	if err := s.DeleteRepo(id); err != nil {
+		t.Fatalf("An error occured while deleting the repository: %v", err)
+	}
+	if _, err := s.GetRepoByID(id); err != store.ErrNotFound {
+		t.Fatalf("Expected %v once deleted, got %v", store.ErrNotFound, err)
+	}
+}
+
+func testPutRepo(t *testing.T, s store.Store) {
+	mustAdd(t, s, sampleRepo())
+
+	expected, id := mustGet(t, s, sampleRepo().Name)
+	if expected.Version != 1 {
+		t.Fatalf("Expected an added repository to have the version 1, got %d", expected.Version)
+	}
+	expected.Count = 3
+	expected.Timestamps = []int64{1446285600, 1446289200, 1446292800}
+	expected.LastStarDate = "2015-10-31T12:00:00Z"
+	expected.LastUpdate = "2015-11-01T10:00:00Z"
+	if err := s.PutRepo(expected, id); err != nil {
+		t.Fatalf("An error occured while putting the repository: %v", err)
+	}
+
+	repoInfo, _ := mustGet(t, s, expected.Name)
+	if repoInfo.Count != expected.Count || repoInfo.LastStarDate != expected.LastStarDate || repoInfo.LastUpdate != expected.LastUpdate {
+		t.Fatalf("Expected %+v, got %+v", expected, repoInfo)
+	}
+	if repoInfo.Version != expected.Version+1 {
+		t.Fatalf("Expected PutRepo to increment the version to %d, got %d", expected.Version+1, repoInfo.Version)
+	}
+	// The timestamps are stored in the series, not with the repository
+	if repoInfo.Timestamps != nil {
+		t.Fatalf("The timestamps shouldn't be stored with the repository, got %v", repoInfo.Timestamps)
+	}
+}
+
+func testPutRepoUnknownID(t *testing.T, s store.Store) {
+	mustAdd(t, s, sampleRepo())
+	if err := s.PutRepo(sampleRepo(), foreignID{}); err == nil {
+		t.Fatal("Putting a repository with an id the store didn't give should fail")
+	}
+}
+
+func testPutRepoConflict(t *testing.T, s store.Store) {
+	mustAdd(t, s, sampleRepo())
+
+	// Two workers read the same version, the second put conflicts
+	first, id := mustGet(t, s, sampleRepo().Name)
+	second, _ := mustGet(t, s, sampleRepo().Name)
+	first.Count = 3
+	if err := s.PutRepo(first, id); err != nil {
+		t.Fatalf("An error occured while putting the repository: %v", err)
+	}
+	second.Count = 4
+	err := s.PutRepo(second, id)
+	conflict, ok := err.(*store.ConflictError)
+	if !ok {
+		t.Fatalf("Expected a *store.ConflictError, got %v", err)
+	}
+	if conflict.Expected != second.Version || conflict.Actual != second.Version+1 {
+		t.Fatalf("Expected a conflict between the versions %d and %d, got %+v", second.Version, second.Version+1, conflict)
+	}
+	if repoInfo, _ := mustGet(t, s, sampleRepo().Name); repoInfo.Count != 3 {
+		t.Fatalf("The conflicting put shouldn't have been written, got the count %d", repoInfo.Count)
+	}
+
+	// The leases are writes too
+	current, _ := mustGet(t, s, sampleRepo().Name)
+	if _, err := s.ClaimWork(current, id, "worker1", time.Minute); err != nil {
+		t.Fatalf("An error occured while claiming the work: %v", err)
+	}
+	if err := s.PutRepo(current, id); !store.IsConflict(err) {
+		t.Fatalf("Expected a conflict after ClaimWork, got %v", err)
+	}
+}
+
+func testPutRepoConcurrent(t *testing.T, s store.Store) {
+	repoInfo := sampleRepo()
+	repoInfo.Count = 0
+	mustAdd(t, s, repoInfo)
+
+	// Every worker increments the count, re-reading the repository on conflict
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				current, id, err := s.GetRepo(repoInfo.Name)
+				if err != nil {
+					errs <- err
+					return
+				}
+				current.Count++
+				err = s.PutRepo(current, id)
+				if store.IsConflict(err) {
+					continue
+				}
+				errs <- err
+				return
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("An error occured while putting the repository: %v", err)
+		}
+	}
+
+	if stored, _ := mustGet(t, s, repoInfo.Name); stored.Count != cap(errs) || stored.Version != int64(cap(errs))+1 {
+		t.Fatalf("Expected the count %d at the version %d, got %d at %d", cap(errs), cap(errs)+1, stored.Count, stored.Version)
+	}
+}
+
+func testPutRepoRename(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	other := sampleRepo()
+	other.ID, other.Name = 43, "evermax/other"
+	mustAdd(t, s, other)
+
+	renamed, _ := mustGet(t, s, sampleRepo().Name)
+	renamed.Name = other.Name
+	if err := s.PutRepo(renamed, id); err != store.ErrAlreadyExist {
+		t.Fatalf("Expected %v, got %v", store.ErrAlreadyExist, err)
+	}
+	renamed.Name = "evermax/stargraph2"
+	if err := s.PutRepo(renamed, id); err != nil {
+		t.Fatalf("An error occured while renaming the repository: %v", err)
+	}
+	if info, _, _ := s.GetRepo(sampleRepo().Name); info.Exist() {
+		t.Fatal("The old name shouldn't be found anymore")
+	}
+	repoInfo, gotID := mustGet(t, s, "evermax/stargraph2")
+	if repoInfo.ID != sampleRepo().ID || gotID.String() != id.String() {
+		t.Fatalf("The repository should be found with its new name and its id %s, got %+v with %s", id, repoInfo, gotID)
+	}
+	if repoInfo, err := s.GetRepoByID(id); err != nil || repoInfo.Name != "evermax/stargraph2" {
+		t.Fatalf("Expected the repository with its new name, got %+v (%v)", repoInfo, err)
+	}
+
+	// The old name is free, and taken again
+	added := mustAdd(t, s, sampleRepo())
+	if added.String() == id.String() {
+		t.Fatalf("The repository added with the old name should have an id of its own, got %s", added)
+	}
+	if repoInfo, gotID := mustGet(t, s, sampleRepo().Name); repoInfo.Version != 1 || gotID.String() != added.String() {
+		t.Fatalf("Expected the repository added with the old name, got %+v with %s", repoInfo, gotID)
+	}
+	repoInfo.Name = sampleRepo().Name
+	if err := s.PutRepo(repoInfo, id); err != store.ErrAlreadyExist {
+		t.Fatalf("Renaming to the taken old name: expected %v, got %v", store.ErrAlreadyExist, err)
+	}
+
+	// Renamed again, the intermediate name is free
+	repoInfo.Name = "evermax/stargraph3"
+	if err := s.PutRepo(repoInfo, id); err != nil {
+		t.Fatalf("An error occured while renaming the repository: %v", err)
+	}
+	if info, _, _ := s.GetRepo("evermax/stargraph2"); info.Exist() {
+		t.Fatal("The intermediate name shouldn't be found anymore")
+	}
+	if _, gotID := mustGet(t, s, "evermax/stargraph3"); gotID.String() != id.String() {
+		t.Fatalf("Expected the id %s with the last name, got %s", id, gotID)
+	}
+
+	// Deleted, its name is free
+	if err := s.DeleteRepo(id); err != nil {
+		t.Fatalf("An error occured while deleting the repository: %v", err)
+	}
+	if info, _, _ := s.GetRepo("evermax/stargraph3"); info.Exist() {
+		t.Fatal("The deleted repository shouldn't be found")
+	}
+	renamed = sampleRepo()
+	renamed.Name = "evermax/stargraph3"
+	mustAdd(t, s, renamed)
+}
+
+func testClaimWork(t *testing.T, s store.Store) {
+	repoInfo := sampleRepo()
+	id := mustAdd(t, s, repoInfo)
+
+	lease, err := s.ClaimWork(repoInfo, id, "worker1", time.Minute)
+	if err != nil {
+		t.Fatalf("An error occured while claiming the work: %v", err)
+	}
+	if lease.Owner != "worker1" || !lease.Expiry.After(time.Now()) {
+		t.Fatalf("Expected a lease of worker1 expiring in the future, got %+v", lease)
+	}
+	if _, err := s.ClaimWork(repoInfo, id, "worker2", time.Minute); err != store.ErrAlreadyWorkedOn {
+		t.Fatalf("Expected %v, got %v", store.ErrAlreadyWorkedOn, err)
+	}
+	claimed, _ := mustGet(t, s, repoInfo.Name)
+	if !claimed.WorkedOn || claimed.LeaseOwner != "worker1" || claimed.LeaseExpiry.IsZero() {
+		t.Fatalf("The repository should be worked on by worker1, got %+v", claimed)
+	}
+	if _, err := s.ClaimWork(repoInfo, foreignID{}, "worker1", time.Minute); err == nil {
+		t.Fatal("Claiming a repository with an id the store didn't give should fail")
+	}
+}
+
+func testClaimWorkConcurrent(t *testing.T, s store.Store) {
+	repoInfo := sampleRepo()
+	id := mustAdd(t, s, repoInfo)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.ClaimWork(repoInfo, id, fmt.Sprintf("worker%d", i), time.Minute)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	claimed := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			claimed++
+		case store.ErrAlreadyWorkedOn:
+		default:
+			t.Fatalf("An error occured while claiming the work: %v", err)
+		}
+	}
+	if claimed != 1 {
+		t.Fatalf("Expected the work to be claimed once, got %d", claimed)
+	}
+}
+
+func testClaimWorkAfterPut(t *testing.T, s store.Store) {
+	repoInfo := sampleRepo()
+	id := mustAdd(t, s, repoInfo)
+	if _, err := s.ClaimWork(repoInfo, id, "worker1", time.Minute); err != nil {
+		t.Fatalf("An error occured while claiming the work: %v", err)
+	}
+
+	// Putting the repository once the work is done releases it
+	repoInfo, _ = mustGet(t, s, repoInfo.Name)
+	repoInfo.WorkedOn = false
+	if err := s.PutRepo(repoInfo, id); err != nil {
+		t.Fatalf("An error occured while putting the repository: %v", err)
+	}
+	if _, err := s.ClaimWork(repoInfo, id, "worker2", time.Minute); err != nil {
+		t.Fatalf("The work should be claimable again, got %v", err)
+	}
+}
+
+func testClaimWorkExpired(t *testing.T, s store.Store) {
+	repoInfo := sampleRepo()
+	id := mustAdd(t, s, repoInfo)
+	if _, err := s.ClaimWork(repoInfo, id, "worker1", 50*time.Millisecond); err != nil {
+		t.Fatalf("An error occured while claiming the work: %v", err)
+	}
+
+	// The owner crashed, its lease expires and somebody else takes the work over
+	time.Sleep(100 * time.Millisecond)
+	if _, err := s.ClaimWork(repoInfo, id, "worker2", time.Minute); err != nil {
+		t.Fatalf("The work should be claimable once the lease expired, got %v", err)
+	}
+	if _, err := s.RenewLease(id, "worker1", time.Minute); err != store.ErrLeaseLost {
+		t.Fatalf("Expected %v, got %v", store.ErrLeaseLost, err)
+	}
+	if err := s.ReleaseWork(id, "worker1"); err != store.ErrLeaseLost {
+		t.Fatalf("Expected %v, got %v", store.ErrLeaseLost, err)
+	}
+	if claimed, _ := mustGet(t, s, repoInfo.Name); claimed.LeaseOwner != "worker2" {
+		t.Fatalf("The repository should be worked on by worker2, got %q", claimed.LeaseOwner)
+	}
+}
+
+func testClaimWorkLegacy(t *testing.T, s store.Store) {
+	// Claimed before the leases existed, by a service that may have crashed
+	repoInfo := sampleRepo()
+	repoInfo.WorkedOn = true
+	id := mustAdd(t, s, repoInfo)
+
+	if _, err := s.ClaimWork(repoInfo, id, "worker1", time.Minute); err != nil {
+		t.Fatalf("A claim without expiry should be claimable, got %v", err)
+	}
+}
+
+func testRenewLease(t *testing.T, s store.Store) {
+	repoInfo := sampleRepo()
+	id := mustAdd(t, s, repoInfo)
+	lease, err := s.ClaimWork(repoInfo, id, "worker1", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("An error occured while claiming the work: %v", err)
+	}
+
+	renewed, err := s.RenewLease(id, "worker1", time.Minute)
+	if err != nil {
+		t.Fatalf("An error occured while renewing the lease: %v", err)
+	}
+	if !renewed.Expiry.After(lease.Expiry) {
+		t.Fatalf("Expected the lease to be extended after %v, got %v", lease.Expiry, renewed.Expiry)
+	}
+	// The renewed lease outlives the first one
+	time.Sleep(150 * time.Millisecond)
+	if _, err := s.ClaimWork(repoInfo, id, "worker2", time.Minute); err != store.ErrAlreadyWorkedOn {
+		t.Fatalf("Expected %v, got %v", store.ErrAlreadyWorkedOn, err)
+	}
+	if _, err := s.RenewLease(id, "worker2", time.Minute); err != store.ErrLeaseLost {
+		t.Fatalf("Expected %v, got %v", store.ErrLeaseLost, err)
+	}
+	if _, err := s.RenewLease(foreignID{}, "worker1", time.Minute); err == nil {
+		t.Fatal("Renewing a lease with an id the store didn't give should fail")
+	}
+}
+
+func testReleaseWork(t *testing.T, s store.Store) {
+	repoInfo := sampleRepo()
+	id := mustAdd(t, s, repoInfo)
+	if _, err := s.ClaimWork(repoInfo, id, "worker1", time.Minute); err != nil {
+		t.Fatalf("An error occured while claiming the work: %v", err)
+	}
+
+	if err := s.ReleaseWork(id, "worker2"); err != store.ErrLeaseLost {
+		t.Fatalf("Expected %v, got %v", store.ErrLeaseLost, err)
+	}
+	if err := s.ReleaseWork(id, "worker1"); err != nil {
+		t.Fatalf("An error occured while releasing the work: %v", err)
+	}
+	released, _ := mustGet(t, s, repoInfo.Name)
+	if released.WorkedOn || released.LeaseOwner != "" || !released.LeaseExpiry.IsZero() {
+		t.Fatalf("The lease should be cleared, got %+v", released)
+	}
+	if _, err := s.ClaimWork(repoInfo, id, "worker2", time.Minute); err != nil {
+		t.Fatalf("The work should be claimable once released, got %v", err)
+	}
+}
+
+// stars returns n timestamps, one every step seconds from start.
+func stars(start int64, n int, step int64) []int64 {
+	timestamps := make([]int64, n)
+	for i := range timestamps {
+		timestamps[i] = start + int64(i)*step
+	}
+	return timestamps
+}
+
+func equalTimestamps(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func mustTimestamps(t *testing.T, s store.Store, id store.ID, from, to int64) []int64 {
+	timestamps, err := store.Timestamps(s, id, from, to)
+	if err != nil {
+		t.Fatalf("An error occured while reading the timestamps: %v", err)
+	}
+	return timestamps
+}
+
+func testAppendTimestamps(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	if timestamps := mustTimestamps(t, s, id, store.AllFrom, store.AllTo); len(timestamps) != 0 {
+		t.Fatalf("A new repository shouldn't have timestamps, got %v", timestamps)
+	}
+
+	// The appends fill the last chunk up and go on in new ones
+	expected := stars(1446285600, series.ChunkSize+10, 60)
+	appends := [][]int64{expected[:10], expected[10 : series.ChunkSize+5], expected[series.ChunkSize+5:]}
+	for _, timestamps := range appends {
+		if err := s.AppendTimestamps(id, timestamps); err != nil {
+			t.Fatalf("An error occured while appending the timestamps: %v", err)
+		}
+	}
+
+	timestamps := mustTimestamps(t, s, id, store.AllFrom, store.AllTo)
+	if len(timestamps) != len(expected) {
+		t.Fatalf("Expected %d timestamps, got %d", len(expected), len(timestamps))
+	}
+	for i := range expected {
+		if timestamps[i] != expected[i] {
+			t.Fatalf("Expected the timestamp %d to be %d, got %d", i, expected[i], timestamps[i])
+		}
+	}
+	chunks, err := s.TimestampChunks(id, store.AllFrom, store.AllTo)
+	if err != nil {
+		t.Fatalf("An error occured while reading the chunks: %v", err)
+	}
+	if len(chunks) != 2 || chunks[0].Seq != 0 || chunks[0].Count != series.ChunkSize || chunks[1].Seq != 1 || chunks[1].Count != 10 {
+		t.Fatalf("Expected a full chunk and one of 10 timestamps, got %d chunks", len(chunks))
+	}
+	if repoInfo, _ := mustGet(t, s, sampleRepo().Name); repoInfo.Timestamps != nil {
+		t.Fatal("GetRepo shouldn't load the timestamps")
+	}
+}
+
+func testAppendTimestampsOutOfOrder(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	if err := s.AppendTimestamps(id, []int64{1446289200, 1446285600}); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+	if err := s.AppendTimestamps(id, []int64{1446285000}); err != series.ErrOutOfOrder {
+		t.Fatalf("Expected %v, got %v", series.ErrOutOfOrder, err)
+	}
+	// The same second as the last star is fine
+	if err := s.AppendTimestamps(id, []int64{1446289200}); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+	timestamps := mustTimestamps(t, s, id, store.AllFrom, store.AllTo)
+	if len(timestamps) != 3 || timestamps[0] != 1446285600 || timestamps[2] != 1446289200 {
+		t.Fatalf("Expected the timestamps to be sorted, got %v", timestamps)
+	}
+}
+
+func testTimestampsRange(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	// A star every minute during a day, the second day is in another chunk
+	day := int64(24 * 3600)
+	if err := s.AppendTimestamps(id, stars(0, 2*24*60, 60)); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+
+	timestamps := mustTimestamps(t, s, id, day, day+3600)
+	if len(timestamps) != 60 || timestamps[0] != day || timestamps[59] != day+3540 {
+		t.Fatalf("Expected the 60 timestamps of the first hour of the second day, got %d", len(timestamps))
+	}
+	chunks, err := s.TimestampChunks(id, 2*day-60, 2*day)
+	if err != nil {
+		t.Fatalf("An error occured while reading the chunks: %v", err)
+	}
+	if len(chunks) != 1 {
+		t.Fatalf("Expected only the last chunk to be read, got %d chunks", len(chunks))
+	}
+
+	count, err := store.CountTimestamps(s, id, 0, day)
+	if err != nil || count != 24*60 {
+		t.Fatalf("Expected %d stars the first day, got %d (%v)", 24*60, count, err)
+	}
+	buckets, err := store.AggregateTimestamps(s, id, 0, 3*day, day)
+	if err != nil {
+		t.Fatalf("An error occured while aggregating the timestamps: %v", err)
+	}
+	// The day without stars after the last one isn't returned
+	if len(buckets) != 2 || buckets[0].Count != 24*60 || buckets[1].Count != 24*60 || buckets[1].Start != day {
+		t.Fatalf("Expected 2 days of %d stars, got %v", 24*60, buckets)
+	}
+	// The buckets of the whole series are aligned on AllFrom, the stars are over 3 of them
+	buckets, err = store.AggregateTimestamps(s, id, store.AllFrom, store.AllTo, day)
+	if err != nil || len(buckets) != 3 || buckets[0].Count+buckets[1].Count+buckets[2].Count != 2*24*60 {
+		t.Fatalf("Expected the %d stars of all the series in 3 days, got %v (%v)", 2*24*60, buckets, err)
+	}
+}
+
+func testDeleteTimestamps(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	if err := s.AppendTimestamps(id, stars(1446285600, 10, 60)); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+	if err := s.DeleteTimestamps(id); err != nil {
+		t.Fatalf("An error occured while deleting the timestamps: %v", err)
+	}
+	if timestamps := mustTimestamps(t, s, id, store.AllFrom, store.AllTo); len(timestamps) != 0 {
+		t.Fatalf("Expected no timestamps once deleted, got %v", timestamps)
+	}
+	// The series starts over
+	if err := s.AppendTimestamps(id, []int64{1000}); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+	if timestamps := mustTimestamps(t, s, id, store.AllFrom, store.AllTo); len(timestamps) != 1 {
+		t.Fatalf("Expected the timestamp appended after the delete, got %v", timestamps)
+	}
+	if err := s.DeleteTimestamps(id); err != nil {
+		t.Fatalf("An error occured while deleting the timestamps: %v", err)
+	}
+	if err := s.DeleteTimestamps(id); err != nil {
+		t.Fatalf("Deleting an empty series shouldn't fail, got %v", err)
+	}
+}
+
+func testReplaceTimestamps(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	if err := s.AppendTimestamps(id, stars(1446285600, 2*series.ChunkSize+10, 60)); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+	tests := []struct {
+		timestamps []int64
+	}{
+		// A shorter series, the chunks past its end are deleted
+		{timestamps: stars(1446285000, 10, 60)},
+		// A longer series
+		{timestamps: stars(1446280000, series.ChunkSize+10, 60)},
+		// No series at all
+		{timestamps: nil},
+	}
+	for i, test := range tests {
+		if err := s.ReplaceTimestamps(id, test.timestamps); err != nil {
+			t.Fatalf("Test %d: an error occured while replacing the timestamps: %v", i, err)
+		}
+		timestamps := mustTimestamps(t, s, id, store.AllFrom, store.AllTo)
+		if !equalTimestamps(timestamps, test.timestamps) {
+			t.Fatalf("Test %d: expected the %d timestamps of the new series, got %d", i, len(test.timestamps), len(timestamps))
+		}
+	}
+	// The series goes on from the new one
+	if err := s.ReplaceTimestamps(id, []int64{1000}); err != nil {
+		t.Fatalf("An error occured while replacing the timestamps: %v", err)
+	}
+	if err := s.AppendTimestamps(id, []int64{2000}); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+	if timestamps := mustTimestamps(t, s, id, store.AllFrom, store.AllTo); !equalTimestamps(timestamps, []int64{1000, 2000}) {
+		t.Fatalf("Expected the timestamp appended after the new series, got %v", timestamps)
+	}
+}
+
+func testTimestampsUnknownID(t *testing.T, s store.Store) {
+	mustAdd(t, s, sampleRepo())
+	if err := s.AppendTimestamps(foreignID{}, []int64{1000}); err == nil {
+		t.Fatal("Appending timestamps with an id the store didn't give should fail")
+	}
+	if _, err := s.TimestampChunks(foreignID{}, store.AllFrom, store.AllTo); err == nil {
+		t.Fatal("Reading timestamps with an id the store didn't give should fail")
+	}
+	if err := s.DeleteTimestamps(foreignID{}); err == nil {
+		t.Fatal("Deleting timestamps with an id the store didn't give should fail")
+	}
+	if err := s.ReplaceTimestamps(foreignID{}, []int64{1000}); err == nil {
+		t.Fatal("Replacing timestamps with an id the store didn't give should fail")
+	}
+}
+
+// listAll lists all the repositories matching the filter by pages of limit repositories,
+// checking that their ids are the ones of the repositories.
+func listAll(t *testing.T, s store.Store, filter store.RepoFilter, limit int) []store.Repo {
+	var all []store.Repo
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("ListRepos doesn't stop returning a cursor")
+		}
+		repos, next, err := s.ListRepos(filter, cursor, limit)
+		if err != nil {
+			t.Fatalf("An error occured while listing the repositories: %v", err)
+		}
+		if len(repos) > limit {
+			t.Fatalf("Expected at most %d repositories, got %d", limit, len(repos))
+		}
+		for _, repo := range repos {
+			if !repo.Info.Exist() {
+				t.Fatalf("The listed repository %s should exist", repo.Info.Name)
+			}
+			if stored, err := s.GetRepoByID(repo.ID); err != nil || stored.Name != repo.Info.Name {
+				t.Fatalf("Expected the id of %s to be listed with it, got %s (%v)", repo.Info.Name, stored.Name, err)
+			}
+		}
+		all = append(all, repos...)
+		if next == "" {
+			return all
+		}
+		cursor = next
+	}
+}
+
+func names(repos []store.Repo) map[string]bool {
+	names := make(map[string]bool)
+	for _, repo := range repos {
+		names[repo.Info.Name] = true
+	}
+	return names
+}
+
+func testListRepos(t *testing.T, s store.Store) {
+	if repos := listAll(t, s, store.RepoFilter{}, 2); len(repos) != 0 {
+		t.Fatalf("An empty store shouldn't list repositories, got %v", repos)
+	}
+	for i := 0; i < 5; i++ {
+		repoInfo := sampleRepo()
+		repoInfo.ID, repoInfo.Name = 100+i, fmt.Sprintf("evermax/repo%d", i)
+		mustAdd(t, s, repoInfo)
+	}
+
+	for _, limit := range []int{1, 2, 5, 10} {
+		repos := listAll(t, s, store.RepoFilter{}, limit)
+		if len(repos) != 5 || len(names(repos)) != 5 {
+			t.Fatalf("Expected the 5 repositories listed once by pages of %d, got %d", limit, len(repos))
+		}
+	}
+	if repos, _, err := s.ListRepos(store.RepoFilter{}, "", 0); err != nil || len(repos) != 5 {
+		t.Fatalf("Expected the 5 repositories with the default page size, got %d (%v)", len(repos), err)
+	}
+	if count, err := s.Count(store.RepoFilter{}); err != nil || count != 5 {
+		t.Fatalf("Expected 5 repositories, got %d (%v)", count, err)
+	}
+}
+
+func testListReposFilter(t *testing.T, s store.Store) {
+	now := time.Now()
+	repos := []github.RepoInfo{
+		{ID: 1, Name: "evermax/new", Count: 10},
+		{ID: 2, Name: "evermax/old", Count: 100, LastUpdate: now.Add(-48 * time.Hour).Format(time.RFC3339)},
+		{ID: 3, Name: "evermax/fresh", Count: 1000, LastUpdate: now.Add(-time.Hour).Format(time.RFC3339)},
+		{ID: 4, Name: "evermax/crawled", Count: 10000},
+	}
+	for _, repoInfo := range repos {
+		id := mustAdd(t, s, repoInfo)
+		if repoInfo.Name == "evermax/crawled" {
+			if _, err := s.ClaimWork(repoInfo, id, "worker1", time.Minute); err != nil {
+				t.Fatalf("An error occured while claiming the work: %v", err)
+			}
+		}
+	}
+
+	workedOn, notWorkedOn := true, false
+	tests := []struct {
+		filter   store.RepoFilter
+		expected []string
+	}{
+		{filter: store.RepoFilter{}, expected: []string{"evermax/new", "evermax/old", "evermax/fresh", "evermax/crawled"}},
+		{filter: store.RepoFilter{UpdatedBefore: now.Add(-24 * time.Hour)}, expected: []string{"evermax/new", "evermax/old", "evermax/crawled"}},
+		{filter: store.RepoFilter{WorkedOn: &workedOn}, expected: []string{"evermax/crawled"}},
+		{filter: store.RepoFilter{WorkedOn: &notWorkedOn}, expected: []string{"evermax/new", "evermax/old", "evermax/fresh"}},
+		{filter: store.RepoFilter{MinStars: 100, MaxStars: 1000}, expected: []string{"evermax/old", "evermax/fresh"}},
+		{filter: store.RepoFilter{MinStars: 1000}, expected: []string{"evermax/fresh", "evermax/crawled"}},
+		{filter: store.RepoFilter{MinStars: 50, UpdatedBefore: now, WorkedOn: &notWorkedOn}, expected: []string{"evermax/old", "evermax/fresh"}},
+		{filter: store.RepoFilter{MaxStars: 5}, expected: nil},
+	}
+	for i, test := range tests {
+		listed := names(listAll(t, s, test.filter, 1))
+		if len(listed) != len(test.expected) {
+			t.Fatalf("Test %d: expected %v, got %v", i, test.expected, listed)
+		}
+		for _, name := range test.expected {
+			if !listed[name] {
+				t.Fatalf("Test %d: expected %v, got %v", i, test.expected, listed)
+			}
+		}
+		count, err := s.Count(test.filter)
+		if err != nil || count != len(test.expected) {
+			t.Fatalf("Test %d: expected a count of %d, got %d (%v)", i, len(test.expected), count, err)
+		}
+	}
+}
+
+func testListReposInvalidCursor(t *testing.T, s store.Store) {
+	mustAdd(t, s, sampleRepo())
+	if _, _, err := s.ListRepos(store.RepoFilter{}, "not a cursor", 10); err != store.ErrInvalidCursor {
+		t.Fatalf("Expected %v, got %v", store.ErrInvalidCursor, err)
+	}
+}
+
+func testDeleteRepo(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	if err := s.AppendTimestamps(id, stars(1446285600, 10, 60)); err != nil {
+		t.Fatalf("An error occured while appending the timestamps: %v", err)
+	}
+	other := sampleRepo()
+	other.ID, other.Name = 43, "evermax/other"
+	mustAdd(t, s, other)
+
+	if err := s.DeleteRepo(id); err != nil {
+		t.Fatalf("An error occured while deleting the repository: %v", err)
+	}
+	if repoInfo, _, err := s.GetRepo(sampleRepo().Name); err != nil || repoInfo.Exist() {
+		t.Fatalf("The repository shouldn't exist anymore, got %+v (%v)", repoInfo, err)
+	}
+	if _, err := s.TimestampChunks(id, store.AllFrom, store.AllTo); err != store.ErrNotFound {
+		t.Fatalf("Expected %v for the timestamps, got %v", store.ErrNotFound, err)
+	}
+	if err := s.DeleteRepo(id); err != store.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", store.ErrNotFound, err)
+	}
+	if count, _ := s.Count(store.RepoFilter{}); count != 1 {
+		t.Fatalf("Expected the other repository to be left, got %d", count)
+	}
+
+	// The name can be added again, without the old timestamps
+	newID := mustAdd(t, s, sampleRepo())
+	if timestamps := mustTimestamps(t, s, newID, store.AllFrom, store.AllTo); len(timestamps) != 0 {
+		t.Fatalf("Expected no timestamps for the new repository, got %v", timestamps)
+	}
+	if err := s.DeleteRepo(foreignID{}); err == nil {
+		t.Fatal("Deleting a repository with an id the store didn't give should fail")
+	}
+}
+
+func testSnapshots(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	if snapshots, err := s.Snapshots(id, store.AllFrom, store.AllTo); err != nil || len(snapshots) != 0 {
+		t.Fatalf("A new repository shouldn't have snapshots, got %v (%v)", snapshots, err)
+	}
+
+	octocat := github.Stargazer{Timestamp: "2015-10-31T10:00:00Z", User: github.User{Login: "octocat", ID: 583231}}
+	other := github.Stargazer{Timestamp: "2015-10-31T11:00:00Z", User: github.User{Login: "other", ID: 42}}
+	day := 24 * time.Hour
+	first := time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC)
+	snapshots := []struct {
+		taken      time.Time
+		stargazers []github.Stargazer
+	}{
+		// Taken out of order, the one of the third day is replaced
+		{taken: first.Add(2 * day), stargazers: []github.Stargazer{octocat, other}},
+		{taken: first, stargazers: []github.Stargazer{octocat, other}},
+		{taken: first.Add(day), stargazers: []github.Stargazer{octocat}},
+		{taken: first.Add(2 * day), stargazers: []github.Stargazer{octocat}},
+	}
+	for _, snapshot := range snapshots {
+		if err := store.TakeSnapshot(s, id, snapshot.taken, snapshot.stargazers); err != nil {
+			t.Fatalf("An error occured while taking the snapshot: %v", err)
+		}
+	}
+
+	all, err := s.Snapshots(id, store.AllFrom, store.AllTo)
+	if err != nil {
+		t.Fatalf("An error occured while reading the snapshots: %v", err)
+	}
+	if len(all) != 3 || all[0].Taken != first.Unix() || all[2].Taken != first.Add(2*day).Unix() {
+		t.Fatalf("Expected the 3 snapshots sorted by time, got %+v", all)
+	}
+	if len(all[0].Stars) != 2 || all[0].Stars[0] != (history.Star{UserID: 42, StarredAt: 1446289200}) {
+		t.Fatalf("Expected the 2 stars of the first snapshot, got %v", all[0].Stars)
+	}
+	if len(all[2].Stars) != 1 {
+		t.Fatalf("Expected the last snapshot to be replaced, got %v", all[2].Stars)
+	}
+
+	ranged, err := s.Snapshots(id, first.Add(day).Unix(), first.Add(2*day).Unix())
+	if err != nil || len(ranged) != 1 || ranged[0].Taken != first.Add(day).Unix() {
+		t.Fatalf("Expected the snapshot of the second day, got %+v (%v)", ranged, err)
+	}
+
+	// The other stargazer unstarred the second day
+	net, err := store.NetStars(s, id)
+	if err != nil {
+		t.Fatalf("An error occured while computing the net stars: %v", err)
+	}
+	expected := []history.Point{{Time: 1446285600, Stars: 1}, {Time: 1446289200, Stars: 2}, {Time: first.Add(day).Unix(), Stars: 1}}
+	if len(net) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, net)
+	}
+	for i := range expected {
+		if net[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, net)
+		}
+	}
+	unstars, err := store.Unstars(s, id)
+	if err != nil || len(unstars) != 1 || unstars[0].UserID != 42 {
+		t.Fatalf("Expected the unstar of the other stargazer, got %v (%v)", unstars, err)
+	}
+}
+
+func testSnapshotsUnknownID(t *testing.T, s store.Store) {
+	mustAdd(t, s, sampleRepo())
+	if err := s.AddSnapshot(foreignID{}, history.Snapshot{Taken: 1000}); err == nil {
+		t.Fatal("Adding a snapshot with an id the store didn't give should fail")
+	}
+	if _, err := s.Snapshots(foreignID{}, store.AllFrom, store.AllTo); err == nil {
+		t.Fatal("Reading the snapshots with an id the store didn't give should fail")
+	}
+}
+
+func testSnapshotsDelete(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	octocat := github.Stargazer{Timestamp: "2015-10-31T10:00:00Z", User: github.User{Login: "octocat", ID: 583231}}
+	if err := store.TakeSnapshot(s, id, time.Unix(1446336000, 0), []github.Stargazer{octocat}); err != nil {
+		t.Fatalf("An error occured while taking the snapshot: %v", err)
+	}
+
+	// The crawls replace the series, the snapshots tell who unstarred since the previous ones
+	if err := s.DeleteTimestamps(id); err != nil {
+		t.Fatalf("An error occured while deleting the timestamps: %v", err)
+	}
+	if snapshots, err := s.Snapshots(id, store.AllFrom, store.AllTo); err != nil || len(snapshots) != 1 {
+		t.Fatalf("Expected the snapshot to be kept with the timestamps deleted, got %v (%v)", snapshots, err)
+	}
+
+	if err := s.DeleteRepo(id); err != nil {
+		t.Fatalf("An error occured while deleting the repository: %v", err)
+	}
+	if _, err := s.Snapshots(id, store.AllFrom, store.AllTo); err != store.ErrNotFound {
+		t.Fatalf("Expected %v for the snapshots, got %v", store.ErrNotFound, err)
+	}
+	// The name can be added again, without the old snapshots
+	newID := mustAdd(t, s, sampleRepo())
+	if snapshots, err := s.Snapshots(newID, store.AllFrom, store.AllTo); err != nil || len(snapshots) != 0 {
+		t.Fatalf("Expected no snapshots for the new repository, got %v (%v)", snapshots, err)
+	}
+}
+
+func testStargazers(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	if stargazers, next, err := s.ListStargazers(id, "", 10); err != nil || len(stargazers) != 0 || next != "" {
+		t.Fatalf("A new repository shouldn't have stargazers, got %v %q (%v)", stargazers, next, err)
+	}
+
+	octocat := github.Stargazer{
+		Timestamp: "2015-10-31T10:00:00Z",
+		User: github.User{
+			Login:     "octocat",
+			ID:        583231,
+			Type:      "User",
+			AvatarURL: "https://avatars.githubusercontent.com/u/583231?v=4",
+			HTMLURL:   "https://github.com/octocat",
+		},
+	}
+	bot := github.Stargazer{Timestamp: "2015-10-31T11:00:00Z", User: github.User{Login: "dependabot[bot]", ID: 49699333, Type: "Bot"}}
+	early := github.Stargazer{Timestamp: "2015-10-31T10:00:00Z", User: github.User{Login: "early", ID: 42, Type: "User"}}
+	// Out of order, and the pages of Github shifted during the crawl
+	if err := s.PutStargazers(id, []github.Stargazer{bot, octocat, early, bot}); err != nil {
+		t.Fatalf("An error occured while putting the stargazers: %v", err)
+	}
+	stargazers, next, err := s.ListStargazers(id, "", 10)
+	if err != nil {
+		t.Fatalf("An error occured while listing the stargazers: %v", err)
+	}
+	expected := []github.Stargazer{early, octocat, bot}
+	if len(stargazers) != len(expected) || next != "" {
+		t.Fatalf("Expected the stargazers %v by time of star then user id, got %v %q", expected, stargazers, next)
+	}
+	for i := range expected {
+		if stargazers[i] != expected[i] {
+			t.Fatalf("Expected the stargazers %v by time of star then user id, got %v", expected, stargazers)
+		}
+	}
+
+	// The crawls replace the stargazers
+	if err := s.PutStargazers(id, []github.Stargazer{octocat}); err != nil {
+		t.Fatalf("An error occured while putting the stargazers: %v", err)
+	}
+	if stargazers, err := store.AllStargazers(s, id); err != nil || len(stargazers) != 1 || stargazers[0] != octocat {
+		t.Fatalf("Expected the stargazers to be replaced by %v, got %v (%v)", octocat, stargazers, err)
+	}
+
+	// The stargazers are not stored with the repository
+	repoInfo, _ := mustGet(t, s, sampleRepo().Name)
+	if b, err := json.Marshal(repoInfo); err != nil || strings.Contains(string(b), "octocat") {
+		t.Fatalf("The stargazers shouldn't be with the repository, got %s (%v)", b, err)
+	}
+
+	if err := s.PutStargazers(id, []github.Stargazer{{Timestamp: "yesterday"}}); err == nil {
+		t.Fatal("Putting a stargazer without a valid time of star should fail")
+	}
+}
+
+func testStargazersPages(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	// Stars every minute, two users by minute
+	var stargazers []github.Stargazer
+	for i := 0; i < 25; i++ {
+		timestamp := time.Unix(1446285600+int64(i/2)*60, 0).UTC().Format(time.RFC3339)
+		stargazers = append(stargazers, github.Stargazer{Timestamp: timestamp, User: github.User{Login: fmt.Sprintf("user%d", i), ID: i + 1}})
+	}
+	if err := s.PutStargazers(id, stargazers); err != nil {
+		t.Fatalf("An error occured while putting the stargazers: %v", err)
+	}
+
+	var listed []github.Stargazer
+	cursor := ""
+	for pages := 1; ; pages++ {
+		page, next, err := s.ListStargazers(id, cursor, 10)
+		if err != nil {
+			t.Fatalf("An error occured while listing the stargazers: %v", err)
+		}
+		if len(page) > 10 {
+			t.Fatalf("Expected at most 10 stargazers by page, got %d", len(page))
+		}
+		listed = append(listed, page...)
+		if next == "" {
+			if pages != 3 {
+				t.Fatalf("Expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		if pages == 3 {
+			t.Fatal("Expected the third page to be the last one")
+		}
+		cursor = next
+	}
+	if len(listed) != len(stargazers) {
+		t.Fatalf("Expected the %d stargazers, got %d", len(stargazers), len(listed))
+	}
+	for i := range stargazers {
+		if listed[i] != stargazers[i] {
+			t.Fatalf("Expected the stargazer %d to be %v, got %v", i, stargazers[i], listed[i])
+		}
+	}
+
+	if _, _, err := s.ListStargazers(id, "not a cursor", 10); err != store.ErrInvalidCursor {
+		t.Fatalf("Expected %v, got %v", store.ErrInvalidCursor, err)
+	}
+}
+
+func testStargazersDelete(t *testing.T, s store.Store) {
+	id := mustAdd(t, s, sampleRepo())
+	octocat := github.Stargazer{Timestamp: "2015-10-31T10:00:00Z", User: github.User{Login: "octocat", ID: 583231}}
+	if err := s.PutStargazers(id, []github.Stargazer{octocat}); err != nil {
+		t.Fatalf("An error occured while putting the stargazers: %v", err)
+	}
+	if err := s.DeleteTimestamps(id); err != nil {
+		t.Fatalf("An error occured while deleting the timestamps: %v", err)
+	}
+	if stargazers, err := store.AllStargazers(s, id); err != nil || len(stargazers) != 1 {
+		t.Fatalf("Expected the stargazers to be kept with the timestamps deleted, got %v (%v)", stargazers, err)
+	}
+
+	if err := s.DeleteRepo(id); err != nil {
+		t.Fatalf("An error occured while deleting the repository: %v", err)
+	}
+	if _, _, err := s.ListStargazers(id, "", 10); err != store.ErrNotFound {
+		t.Fatalf("Expected %v for the stargazers, got %v", store.ErrNotFound, err)
+	}
+	// The name can be added again, without the old stargazers
+	newID := mustAdd(t, s, sampleRepo())
+	if stargazers, err := store.AllStargazers(s, newID); err != nil || len(stargazers) != 0 {
+		t.Fatalf("Expected no stargazers for the new repository, got %v (%v)", stargazers, err)
+	}
+}
+
+func testStargazersUnknownID(t *testing.T, s store.Store) {
+	mustAdd(t, s, sampleRepo())
+	if err := s.PutStargazers(foreignID{}, nil); err == nil {
+		t.Fatal("Putting the stargazers with an id the store didn't give should fail")
+	}
+	if _, _, err := s.ListStargazers(foreignID{}, "", 10); err == nil {
+		t.Fatal("Listing the stargazers with an id the store didn't give should fail")
+	}
+}
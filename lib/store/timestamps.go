@@ -0,0 +1,41 @@
+package store
+
+import (
+	"math"
+
+	"github.com/evermax/stargraph/lib/series"
+)
+
+// AllFrom and AllTo are the widest time range, to read the whole series of timestamps.
+const (
+	AllFrom int64 = math.MinInt64
+	AllTo   int64 = math.MaxInt64
+)
+
+// Timestamps returns the timestamps of the stars of the repository with the id in [from, to).
+func Timestamps(s Store, id ID, from, to int64) ([]int64, error) {
+	chunks, err := s.TimestampChunks(id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return series.Range(chunks, from, to)
+}
+
+// CountTimestamps returns the number of stars of the repository with the id in [from, to).
+func CountTimestamps(s Store, id ID, from, to int64) (int, error) {
+	chunks, err := s.TimestampChunks(id, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return series.Count(chunks, from, to)
+}
+
+// AggregateTimestamps counts the stars of the repository with the id in [from, to)
+// in buckets of interval seconds aligned on from, see series.Aggregate.
+func AggregateTimestamps(s Store, id ID, from, to, interval int64) ([]series.Bucket, error) {
+	chunks, err := s.TimestampChunks(id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return series.Aggregate(chunks, from, to, interval)
+}
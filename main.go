@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"time"
 
 	"github.com/evermax/stargraph/example"
@@ -11,36 +14,114 @@ import (
 	"github.com/evermax/stargraph/lib"
 )
 
+const tokensEnv = "STARGRAPH_TOKENS"
+
 var (
 	repo, token string
+	tokensFile  string
+	appKey      string
+	appID       int64
+	appInstall  int64
+	apiURL      string
+	cacheDir    string
 	batch       int
 	concurrent  bool
+	graphql     bool
+	stargazers  bool
 )
 
 func init() {
 	flag.StringVar(&repo, "r", "evermax/stargraph", "Github Project repository using format :username/:repo. Default: evermax/stargraph")
 	flag.StringVar(&token, "t", "", "Github API token\nYou can go on to the following link to know how to get one: https://github.com/blog/1509-personal-api-tokens")
+	flag.StringVar(&tokensFile, "tokens-file", "", "File with one Github API token per line, the requests are spread on them.\nThe tokens can also be provided, separated by commas, in the "+tokensEnv+" environment variable")
+	flag.Int64Var(&appID, "app-id", 0, "ID of the Github App to authenticate as, instead of using tokens. Requires -app-installation and -app-key")
+	flag.Int64Var(&appInstall, "app-installation", 0, "ID of the installation of the Github App")
+	flag.StringVar(&appKey, "app-key", "", "File with the PEM encoded private key of the Github App")
+	flag.StringVar(&apiURL, "api-url", github.DefaultBaseURL, "Base URL of the Github API, for Github Enterprise Server it looks like https://ghe.example.com/api/v3/. Default: "+github.DefaultBaseURL)
 	flag.IntVar(&batch, "n", 100, "Number of stars per request. Default: 100")
 	flag.BoolVar(&concurrent, "c", true, "Whether you want to run the requests concurrently or not. Default: true")
+	flag.BoolVar(&graphql, "graphql", false, "Whether to use the GraphQL API, needed for repositories with more than 40000 stars. Requires a token. Default: false")
+	flag.BoolVar(&stargazers, "stargazers", false, "Whether to also write who starred the repository and when in stargazers.json. Default: false")
+	flag.StringVar(&cacheDir, "cache", "", "Directory where to cache the Github responses, unchanged pages won't count against the rate limit. Default: no cache")
 }
 
 func main() {
 	flag.Parse()
 
+	client := github.NewClient(token)
+	if err := client.SetBaseURL(apiURL); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if tokensFile != "" || os.Getenv(tokensEnv) != "" {
+		var pool *github.TokenPool
+		var err error
+		if tokensFile != "" {
+			pool, err = github.TokenPoolFromFile(tokensFile)
+		} else {
+			pool, err = github.TokenPoolFromEnv(tokensEnv)
+		}
+		if err != nil {
+			fmt.Printf("An error occured while loading the tokens: %v\n", err)
+			return
+		}
+		pool.Add(token)
+		client.Tokens = pool
+	}
+	if appID != 0 {
+		key, err := github.LoadPrivateKey(appKey)
+		if err != nil {
+			fmt.Printf("An error occured while loading the private key of the app: %v\n", err)
+			return
+		}
+		client.Tokens = github.NewAppTokenSource(client, appID, appInstall, key)
+	}
+	if cacheDir != "" {
+		cache, err := github.NewDiskCache(cacheDir)
+		if err != nil {
+			fmt.Printf("An error occured while creating the cache directory: %v\n", err)
+			return
+		}
+		client.Cache = cache
+	}
+
+	// Stop crawling cleanly on Ctrl-C
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		fmt.Println("Interrupted, stopping...")
+		cancel()
+	}()
+
 	fmt.Printf("Starting github star graph of %s\n", repo)
 	startDate := time.Now()
-	repoInfo, err := github.GetRepoInfo(token, repo)
+	repoInfo, err := client.GetRepoInfoContext(ctx, repo)
 	if err != nil {
 		fmt.Printf("An error occured while getting the repository info: %v\n", err)
 		return
 	}
 	var timestamps []int64
+	var starredBy []github.Stargazer
 	/*if concurrent {
 		timestamps, err = lib.GetTimestampsDistributed(repoInfo.Count, batch, repoInfo.GetUrl(), token)
 	} else {
 		timestamps, err = lib.GetTimestamps(batch, repoUrl, token)
 	}*/
-	timestamps, err = example.GetTimestamps(batch, repoInfo.URL(), token)
+	var fetcher github.StargazerFetcher = example.Fetcher{PerPage: batch}
+	if graphql {
+		fetcher = github.GraphQLFetcher{PerPage: batch}
+	}
+	if stargazers {
+		starredBy, err = fetcher.FetchStargazers(ctx, client, repoInfo)
+		if err == nil {
+			timestamps, err = github.Timestamps(starredBy)
+		}
+	} else {
+		timestamps, err = fetcher.FetchTimestamps(ctx, client, repoInfo)
+	}
 	if err != nil {
 		fmt.Printf("An error occured while getting the stars from Github: %v\n", err)
 		return
@@ -49,6 +130,21 @@ func main() {
 	duration := endDate.Sub(startDate)
 	fmt.Printf("Timestamps gotten in %v\n", duration)
 
+	if stargazers {
+		fmt.Println("Persisting the stargazers...")
+		stargazersFile, err := os.Create("stargazers.json")
+		if err != nil {
+			fmt.Printf("An error occured when creating the stargazers file %v\n", err)
+			return
+		}
+		defer stargazersFile.Close()
+		if err = json.NewEncoder(stargazersFile).Encode(starredBy); err != nil {
+			fmt.Printf("An error occured when writing the stargazers file %v\n", err)
+			return
+		}
+		fmt.Println("Done.")
+	}
+
 	fmt.Println("Persisting them into canvas format...")
 	canvasFile, err := os.Create("canvasdb.json")
 	if err != nil {
@@ -3,10 +3,14 @@
 package creator
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/evermax/stargraph/api"
@@ -19,20 +23,79 @@ import (
 // Creator contains the database to use, the type of service (creator)
 // and the job queue to send job to workers. It implements the service.SWorker interface.
 type Creator struct {
-	t         string
-	db        store.Store
-	messageQ  mq.MessageQueue
-	jobQueue  chan service.Job
-	queueName string
+	// Github is the client used to talk to the Github API.
+	// If it has a token pool, the crawls are spread on its tokens,
+	// otherwise the token of each job is set on a copy of it.
+	Github *github.Client
+	// Fetcher is used to get the timestamps of the stars.
+	// If nil, the REST API pages are requested by the workers of the job queue, see Fetcher.
+	Fetcher github.StarFetcher
+	// Stargazers tells whether to keep who starred the repositories along with the timestamps.
+	// It is only possible when the Fetcher is a github.StargazerFetcher, otherwise
+	// the crawls fail with ErrStargazersUnsupported.
+	Stargazers bool
+	// Owner identifies this creator in the leases on the work on the repositories.
+	Owner string
+	// LeaseTTL is how long the work on a repository is claimed for.
+	// The lease is renewed every third of it during the crawl, so if the creator
+	// crashes, another one can take the work over once it expired.
+	LeaseTTL time.Duration
+	// Queue is the name of the queue the jobs are consumed from, the AddQueue of the api.Conf.
+	Queue string
+	// MaxAttempts is the number of times a job is tried before being moved to the dead-letter queue,
+	// so that a job that always fails isn't retried forever.
+	MaxAttempts int
+	// RetryBackoff computes the delay before retrying a failed job, its Retries is ignored.
+	RetryBackoff service.Backoff
+
+	t        string
+	db       store.Store
+	messageQ mq.MessageQueue
+	jobQueue chan service.Job
 }
 
-// NewCreator creates a new creator
+const (
+	// DefaultLeaseTTL is the LeaseTTL of the creators created with NewCreator.
+	DefaultLeaseTTL = 2 * time.Minute
+	// DefaultMaxAttempts is the MaxAttempts of the creators created with NewCreator.
+	DefaultMaxAttempts = 5
+)
+
+// ErrStargazersUnsupported is returned by Crawl when the Stargazers are to be kept
+// but the Fetcher only gets the timestamps of the stars.
+var ErrStargazersUnsupported = fmt.Errorf("The Fetcher can't tell who starred the repositories, it isn't a github.StargazerFetcher")
+
+// ErrNoJobQueue is returned when the pages of stars are to be requested by the workers
+// of a job queue, but there is none.
+var ErrNoJobQueue = fmt.Errorf("There is no job queue to request the pages of stars")
+
+// DefaultRetryBackoff is the RetryBackoff of the creators created with NewCreator,
+// long enough for the rate limit of Github to be reset.
+var DefaultRetryBackoff = service.Backoff{
+	Initial: 10 * time.Second,
+	Max:     10 * time.Minute,
+}
+
+// NewCreator creates a new creator, owning its leases as hostname:pid.
 func NewCreator(db store.Store, queue mq.MessageQueue) Creator {
 	return Creator{
-		t:        service.CreatorName,
-		db:       db,
-		messageQ: queue,
+		Github:       github.NewClient(""),
+		Owner:        defaultOwner(),
+		LeaseTTL:     DefaultLeaseTTL,
+		MaxAttempts:  DefaultMaxAttempts,
+		RetryBackoff: DefaultRetryBackoff,
+		t:            service.CreatorName,
+		db:           db,
+		messageQ:     queue,
+	}
+}
+
+func defaultOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "creator"
 	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
 }
 
 // JobQueue ... TODO
@@ -43,22 +106,42 @@ func (c Creator) JobQueue() chan service.Job {
 // Run create a connection to the AMQP server and listen to incoming requests
 // To create Github repository graphs.
 func (c Creator) Run() error {
-	return c.messageQ.Consume(c.queueName, c.receiveMessage)
+	return c.messageQ.Consume(c.Queue, c.receiveMessage)
 }
 
 func (c Creator) receiveMessage(d mq.Delivery, forever chan bool) {
 	body := d.Body()
 	log.Printf("Received a message: %s", body)
 
-	err := c.creatorWork(d.Body())
+	err := c.creatorWork(context.Background(), d.Body())
 	if err == store.ErrAlreadyExist {
 		d.Ack(false)
 		log.Printf("WARN: Asked to recreate %s, aborting", body)
 		return
 	}
+	if err == store.ErrAlreadyWorkedOn {
+		d.Ack(false)
+		log.Printf("WARN: %s is already worked on, aborting", body)
+		return
+	}
+	if err != nil && c.MaxAttempts > 0 && d.Attempts() >= c.MaxAttempts {
+		reason := fmt.Sprintf("%v (after %d attempts)", err, d.Attempts())
+		if dlqErr := c.messageQ.DeadLetter(c.Queue, d, reason); dlqErr != nil {
+			log.Printf("ERROR: Couldn't dead-letter %s: %v", body, dlqErr)
+			d.Nack(false, true)
+			return
+		}
+		log.Printf("ERROR: %s, moved to the dead-letter queue", reason)
+		return
+	}
 	if err != nil {
-		// log it
-		d.Nack(false, true)
+		delay := c.RetryBackoff.Delay(d.Attempts() - 1)
+		if retryErr := c.messageQ.Retry(c.Queue, d, delay); retryErr != nil {
+			log.Printf("WARN: Couldn't retry %s later: %v", body, retryErr)
+			d.Nack(false, true)
+			return
+		}
+		log.Printf("WARN: %v, attempt %d, retrying in %v", err, d.Attempts(), delay)
 		return
 	}
 
@@ -66,7 +149,7 @@ func (c Creator) receiveMessage(d mq.Delivery, forever chan bool) {
 	log.Printf("Done")
 }
 
-func (c Creator) creatorWork(body []byte) error {
+func (c Creator) creatorWork(ctx context.Context, body []byte) error {
 	apiJob, err := api.Unmarshal(body)
 	if err != nil {
 		return fmt.Errorf("Umarshalling error with %s: %v", body, err)
@@ -75,32 +158,27 @@ func (c Creator) creatorWork(body []byte) error {
 	repoInfo := github.RepoInfo{
 		ID:           apiJob.RepoInfo.ID,
 		Name:         apiJob.RepoInfo.Name,
+		FullName:     apiJob.RepoInfo.FullName,
 		Count:        apiJob.RepoInfo.Count,
 		CreationDate: apiJob.RepoInfo.CreationDate,
-		WorkedOn:     true,
+		StarsURL:     c.Github.StarsURL(apiJob.RepoInfo.ID),
 	}
 
 	// Create the repository on the store, claim the work
-	key, err := c.db.AddRepo(repoInfo)
+	key, err := c.repoKey(apiJob, repoInfo)
 	if err != nil {
 		return err
 	}
-
-	timestamps, err := GetAllTimestamps(c.jobQueue, 100, apiJob.Token, repoInfo)
-	if err != nil {
-		return fmt.Errorf("Error with %s: %v", body, err)
+	client := c.Github
+	if client.Tokens == nil {
+		client = client.WithToken(apiJob.Token)
+	}
+	err = c.Crawl(ctx, client, key, repoInfo)
+	if err == store.ErrAlreadyWorkedOn {
+		return err
 	}
-
-	lastStar := timestamps[len(timestamps)-1]
-
-	repoInfo.Timestamps = timestamps
-
-	repoInfo.WorkedOn = false
-	repoInfo.LastUpdate = time.Now().Format(time.RFC3339)
-	repoInfo.LastStarDate = time.Unix(lastStar, 0).Format(time.RFC3339)
-	err = c.db.PutRepo(repoInfo, key)
 	if err != nil {
-		return fmt.Errorf("Put to store error with %s: %v", body, err)
+		return fmt.Errorf("Error with %s: %v", body, err)
 	}
 
 	// TODO: Think if this could be done on the fly first
@@ -111,68 +189,452 @@ func (c Creator) creatorWork(body []byte) error {
 	return nil
 }
 
+// Crawl claims the work on the repository with the key, fetches its stars with the client
+// and replaces its series. If the Stargazers are kept, they replace the ones of the repository
+// and a snapshot of them is taken. The repository is then put with its LastUpdate set,
+// which gives the work back.
+// If another service works on the repository, it returns store.ErrAlreadyWorkedOn.
+func (c Creator) Crawl(ctx context.Context, client *github.Client, key store.ID, repoInfo github.RepoInfo) error {
+	fetcher := c.Fetcher
+	if fetcher == nil {
+		fetcher = Fetcher{JobQueue: c.jobQueue, PerPage: 100}
+	}
+	stargazerFetcher, ok := fetcher.(github.StargazerFetcher)
+	if c.Stargazers && !ok {
+		return ErrStargazersUnsupported
+	}
+
+	ttl := c.LeaseTTL
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	if _, err := c.db.ClaimWork(repoInfo, key, c.Owner, ttl); err != nil {
+		return err
+	}
+	// The repository as claimed, putRepo tells the renewals of the lease from the other writes
+	claimed, err := c.db.GetRepoByID(key)
+	if err != nil {
+		c.release(key, repoInfo.Name)
+		return err
+	}
+
+	crawlCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lost := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.renewLease(crawlCtx, cancel, key, ttl, lost)
+	}()
+
+	var timestamps []int64
+	var stargazers []github.Stargazer
+	if c.Stargazers {
+		stargazers, err = stargazerFetcher.FetchStargazers(crawlCtx, client, repoInfo)
+		if err == nil {
+			timestamps, err = github.Timestamps(stargazers)
+		}
+	} else {
+		timestamps, err = fetcher.FetchTimestamps(crawlCtx, client, repoInfo)
+	}
+	cancel()
+	<-stopped
+	select {
+	case lostErr := <-lost:
+		// Somebody else works on the repository now, don't give it back
+		return lostErr
+	default:
+	}
+	if err != nil {
+		c.release(key, repoInfo.Name)
+		return err
+	}
+
+	// The lease might have expired since its last renewal, nothing is written once it is lost
+	if _, err := c.db.RenewLease(key, c.Owner, ttl); err != nil {
+		if err != store.ErrLeaseLost {
+			c.release(key, repoInfo.Name)
+		}
+		return err
+	}
+	// The crawl replaces the series at once, a creator that crashed might have written a part of it
+	if err := c.db.ReplaceTimestamps(key, timestamps); err != nil {
+		return fmt.Errorf("Put to store error: %v", err)
+	}
+	// A snapshot of the stargazers at each crawl, diffing them tells who unstarred
+	if c.Stargazers {
+		if err := c.db.PutStargazers(key, stargazers); err != nil {
+			return fmt.Errorf("Put to store error: %v", err)
+		}
+		if err := store.TakeSnapshot(c.db, key, time.Now(), stargazers); err != nil {
+			return fmt.Errorf("Put to store error: %v", err)
+		}
+	}
+
+	// Putting the repository without lease gives the work back
+	repoInfo.WorkedOn = false
+	repoInfo.LeaseOwner = ""
+	repoInfo.LeaseExpiry = time.Time{}
+	repoInfo.LastUpdate = time.Now().Format(time.RFC3339)
+	if len(timestamps) > 0 {
+		lastStar := timestamps[len(timestamps)-1]
+		repoInfo.LastStarDate = time.Unix(lastStar, 0).Format(time.RFC3339)
+	}
+	if err := c.putRepo(repoInfo, claimed, key); err != nil {
+		if store.IsConflict(err) {
+			// The crawl can be tried again on the repository as it is now
+			c.release(key, repoInfo.Name)
+		}
+		return fmt.Errorf("Put to store error: %v", err)
+	}
+	return nil
+}
+
+// release gives the work on the repository back, the lease would expire otherwise.
+func (c Creator) release(key store.ID, name string) {
+	if err := c.db.ReleaseWork(key, c.Owner); err != nil {
+		log.Printf("WARN: Couldn't release the work on %s: %v", name, err)
+	}
+}
+
+// repoKey returns the id of the repository of the job in the store, adding it if the job has no id.
+// A creator that crashed before the end of the crawl leaves a repository that was never updated:
+// it is taken over, the lease on it being claimed once expired. Otherwise, it returns store.ErrAlreadyExist.
+func (c Creator) repoKey(apiJob api.Job, repoInfo github.RepoInfo) (store.ID, error) {
+	var existing github.RepoInfo
+	var key store.ID
+	if apiJob.ID != "" {
+		id, err := c.db.ParseID(apiJob.ID)
+		if err != nil {
+			return nil, err
+		}
+		if existing, err = c.db.GetRepoByID(id); err != nil {
+			return nil, err
+		}
+		key = id
+	} else {
+		id, err := c.db.AddRepo(repoInfo)
+		if err != store.ErrAlreadyExist {
+			return id, err
+		}
+		if existing, key, err = c.db.GetRepo(repoInfo.Name); err != nil {
+			return nil, err
+		}
+	}
+	if existing.LastUpdate != "" {
+		return nil, store.ErrAlreadyExist
+	}
+	return key, nil
+}
+
+// maxPutAttempts is the number of times putRepo puts the repository.
+const maxPutAttempts = 3
+
+// putRepo puts the repository at the version it was claimed at. The leases renewed during the crawl
+// change the version, so on a *store.ConflictError the repository is read again: if only its lease
+// was renewed since it was claimed, the repository is put at the new version. Otherwise another writer
+// changed it and the conflict is returned, its changes are not overwritten.
+func (c Creator) putRepo(repoInfo, claimed github.RepoInfo, key store.ID) error {
+	repoInfo.Version = claimed.Version
+	err := c.db.PutRepo(repoInfo, key)
+	for attempt := 1; attempt < maxPutAttempts && store.IsConflict(err); attempt++ {
+		current, getErr := c.db.GetRepoByID(key)
+		if getErr != nil {
+			return getErr
+		}
+		if current.LeaseOwner != c.Owner {
+			return store.ErrLeaseLost
+		}
+		if !leaseRenewed(claimed, current) {
+			return &store.ConflictError{Name: repoInfo.Name, Expected: claimed.Version, Actual: current.Version}
+		}
+		repoInfo.Version = current.Version
+		err = c.db.PutRepo(repoInfo, key)
+	}
+	return err
+}
+
+// leaseRenewed tells whether the current repository only differs from the claimed one
+// by the expiry of the lease and the version, the changes of RenewLease.
+func leaseRenewed(claimed, current github.RepoInfo) bool {
+	claimed.LeaseExpiry = current.LeaseExpiry
+	claimed.Version = current.Version
+	return reflect.DeepEqual(claimed, current)
+}
+
+// renewLease renews the lease on the work on the repository every third of the ttl until the context is done.
+// If the lease is lost, it sends store.ErrLeaseLost to lost and cancels the crawl.
+func (c Creator) renewLease(ctx context.Context, cancel context.CancelFunc, key store.ID, ttl time.Duration, lost chan<- error) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := c.db.RenewLease(key, c.Owner, ttl)
+			if err == store.ErrLeaseLost {
+				lost <- err
+				cancel()
+				return
+			}
+			if err != nil {
+				// The lease might still be renewed at the next tick
+				log.Printf("WARN: Couldn't renew the lease on %v: %v", key, err)
+			}
+		}
+	}
+}
+
+// Fetcher is a github.StargazerFetcher using the REST API,
+// the pages are requested concurrently by the workers listening to the JobQueue.
+// The failed pages are retried according to the Backoff, service.DefaultBackoff if zero.
+type Fetcher struct {
+	JobQueue chan service.Job
+	PerPage  int
+	Backoff  service.Backoff
+}
+
+// FetchTimestamps gets the timestamps of the stars of the repository.
+func (f Fetcher) FetchTimestamps(ctx context.Context, client *github.Client, info github.RepoInfo) ([]int64, error) {
+	timestamps, _, err := getAllTimestamps(ctx, f.JobQueue, f.PerPage, client, info, f.backoff())
+	return timestamps, err
+}
+
+// FetchStargazers gets who starred the repository and when, by time of star.
+func (f Fetcher) FetchStargazers(ctx context.Context, client *github.Client, info github.RepoInfo) ([]github.Stargazer, error) {
+	_, stargazers, err := getAllTimestamps(ctx, f.JobQueue, f.PerPage, client, info, f.backoff())
+	return stargazers, err
+}
+
+func (f Fetcher) backoff() service.Backoff {
+	if f.Backoff == (service.Backoff{}) {
+		return service.DefaultBackoff
+	}
+	return f.Backoff
+}
+
+// PagesError is returned by GetAllTimestamps when some pages couldn't be fetched,
+// even after being retried. It holds the last error of each failed page.
+type PagesError map[int]error
+
+// Pages returns the numbers of the failed pages, sorted.
+func (e PagesError) Pages() []int {
+	pages := make([]int, 0, len(e))
+	for page := range e {
+		pages = append(pages, page)
+	}
+	sort.Ints(pages)
+	return pages
+}
+
+func (e PagesError) Error() string {
+	pages := e.Pages()
+	errs := make([]string, len(pages))
+	for i, page := range pages {
+		errs[i] = fmt.Sprintf("page %d: %v", page, e[page])
+	}
+	return fmt.Sprintf("Failed to get %d pages: %s", len(pages), strings.Join(errs, "; "))
+}
+
 // GetAllTimestamps will get the timestamps for all the stars of the passed repository.
-// It will use the perPage number and the Github API token to make a number of queries the the Github API.
+// It will use the perPage number and the Github client to query the Github API:
+// the last link of the first page gives the number of pages and they are all queued at once,
+// otherwise the pages are queued one at a time, following the next links given by Github.
 // The jobQueue is used to have a pool of workers that will make one API call at a time each.
 // The service itself would typically share ressources with several other services.
-func GetAllTimestamps(jobQueue chan service.Job, perPage int, token string, repoInfo github.IRepoInfo) ([]int64, error) {
-	// calculate the number of calls to make to Github API
-	numberOfAPICall := repoInfo.StarCount() / perPage
+// A page that fails with a retryable error is requeued following service.DefaultBackoff.
+// If a page still failed, the other pages are cancelled and the timestamps of the pages fetched
+// are returned with a PagesError holding the failed page and the ones, expected from the star count, not fetched.
+func GetAllTimestamps(jobQueue chan service.Job, perPage int, client *github.Client, repoInfo github.IRepoInfo) ([]int64, error) {
+	return GetAllTimestampsContext(context.Background(), jobQueue, perPage, client, repoInfo)
+}
+
+// GetAllTimestampsContext is GetAllTimestamps with a context. When it is done, the jobs
+// still queued are dropped, the requests in progress are cancelled and the context error is returned.
+func GetAllTimestampsContext(ctx context.Context, jobQueue chan service.Job, perPage int, client *github.Client, repoInfo github.IRepoInfo) ([]int64, error) {
+	timestamps, _, err := getAllTimestamps(ctx, jobQueue, perPage, client, repoInfo, service.DefaultBackoff)
+	return timestamps, err
+}
+
+// getAllTimestamps gets the timestamps of the stars, sorted, along with the stargazers in the order of the pages.
+func getAllTimestamps(ctx context.Context, jobQueue chan service.Job, perPage int, client *github.Client, repoInfo github.IRepoInfo, backoff service.Backoff) ([]int64, []github.Stargazer, error) {
+	if repoInfo.StarCount() == 0 {
+		return []int64{}, []github.Stargazer{}, nil
+	}
+	if jobQueue == nil {
+		// Nobody would ever take the jobs
+		return nil, nil, ErrNoJobQueue
+	}
+	// calculate the number of pages expected, to report the ones not fetched after a failure
+	expectedPages := repoInfo.StarCount() / perPage
 	// don't forget to add the possible incomplete page
 	if repoInfo.StarCount()%perPage > 0 {
-		numberOfAPICall++
+		expectedPages++
 	}
 
-	url := repoInfo.URL() + "?per_page=" + strconv.Itoa(perPage)
+	// The jobs are cancelled once a page failed, the other pages wouldn't be used
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
 
-	// create the timestamp array that will be used to
-	// agregate all the timestamps that the main routine gets
-	// from the workers
-	var timestamps []int64
-	stampsChan := make(chan []int64, 8)
-	defer close(stampsChan)
+	// The channels are not closed: when the context is done, a worker might
+	// still be sending the result of a job that is not waited for anymore.
+	pageChan := make(chan service.Page, 1)
 
 	// create error channel to send the errors
 	// from the goroutines and the master
 	errchan := make(chan error)
-	defer close(errchan)
 
-	var j int
-	var err error
-	// Put jobs to make API calls in the job queue
-	for i := 0; i < numberOfAPICall; i++ {
-		jobQueue <- service.Job{
-			Num:               i + 1,
-			ApiURL:            url,
-			ApiToken:          token,
-			ErrorChannel:      errchan,
-			TimestampsChannel: stampsChan,
+	newJob := func(page int, url string) service.Job {
+		return service.Job{
+			Num:          page,
+			ApiURL:       url,
+			Client:       client,
+			Context:      ctx,
+			ErrorChannel: errchan,
+			PageChannel:  pageChan,
 		}
 	}
-L:
-	for {
+
+	// queue queues the job, or fails it with the error of the context once it is done.
+	// It runs in its own goroutine, the results are received meanwhile.
+	queue := func(job service.Job) {
+		select {
+		case jobQueue <- job:
+		case <-ctx.Done():
+			errchan <- &service.JobError{Num: job.Num, Err: ctx.Err()}
+		}
+	}
+
+	urls := make(map[int]string)
+	attempts := make(map[int]int)
+	retries := make(map[int]*time.Timer)
+	pages := make(map[int]service.Page)
+	failed := make(PagesError)
+	// pending counts the pages queued, fetched or waiting for a retry
+	pending := 0
+	lastQueued := 0
+	fetch := func(page int, url string) {
+		urls[page] = url
+		pending++
+		if page > lastQueued {
+			lastQueued = page
+		}
+		go queue(newJob(page, url))
+	}
+	// failedPage is the first page that failed for good, the pages not fetched yet are given up then
+	failedPage := 0
+	fail := func(page int, err error) {
+		if failedPage != 0 {
+			err = fmt.Errorf("Not fetched, the page %d failed", failedPage)
+		} else if parent.Err() == nil {
+			failedPage = page
+			cancel()
+		}
+		failed[page] = err
+	}
+	// concurrent is true once all the pages are queued
+	concurrent := false
+
+	fetch(1, repoInfo.URL()+"?per_page="+strconv.Itoa(perPage))
+	done := ctx.Done()
+	for pending > 0 {
 		select {
-		case err = <-errchan:
-			j++
-			// TODO better error handling
-			// Example: get the page of the call
-			// that failed and requeue a job for it
-			// Also log it
-			if j >= numberOfAPICall {
-				break L
+		case err := <-errchan:
+			// The workers always send a *service.JobError
+			jobErr := err.(*service.JobError)
+			page := jobErr.Num
+			if ctx.Err() == nil && github.Retryable(jobErr.Err) && attempts[page] < backoff.Retries {
+				delay := backoff.Delay(attempts[page])
+				if rateErr, ok := jobErr.Err.(*github.RateLimitError); ok && rateErr.Wait() > delay {
+					delay = rateErr.Wait()
+				}
+				attempts[page]++
+				log.Printf("Retrying %v in %v (attempt %d)", jobErr, delay, attempts[page])
+				job := newJob(page, urls[page])
+				retries[page] = time.AfterFunc(delay, func() {
+					queue(job)
+				})
+				continue
 			}
+			pending--
+			delete(retries, page)
+			fail(page, jobErr.Err)
 
-		case stamps := <-stampsChan:
-			timestamps = append(timestamps, stamps...)
-			j++
-			if j >= numberOfAPICall {
-				break L
+		case result := <-pageChan:
+			pending--
+			delete(retries, result.Num)
+			pages[result.Num] = result
+			if ctx.Err() != nil || concurrent {
+				continue
+			}
+			if result.Num == 1 && result.Last.Page > 1 && result.Next.Page == 2 {
+				if next, err := pageURLs(result.Last); err == nil {
+					// The last link gives the number of pages, they are all queued at once
+					concurrent = true
+					for i, url := range next {
+						fetch(i+2, url)
+					}
+					continue
+				}
+			}
+			// One page at a time, the URL of the next one is in the Link header of the current one
+			if result.Next.URL != "" && result.Next.URL != urls[result.Num] {
+				fetch(result.Num+1, result.Next.URL)
+			}
+
+		case <-done:
+			// Cancel the retries not queued yet, a queued job
+			// will fail quickly now that its context is done.
+			done = nil
+			for page, retry := range retries {
+				if retry.Stop() {
+					pending--
+					delete(retries, page)
+					fail(page, ctx.Err())
+				}
 			}
 		}
 	}
+
+	nums := make([]int, 0, len(pages))
+	for num := range pages {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	var timestamps []int64
+	var stargazers []github.Stargazer
+	for _, num := range nums {
+		timestamps = append(timestamps, pages[num].Timestamps...)
+		stargazers = append(stargazers, pages[num].Stargazers...)
+	}
 	sort.Sort(sortableTimestamps(timestamps))
 
-	return timestamps, err
+	if err := parent.Err(); err != nil {
+		return timestamps, stargazers, err
+	}
+	if len(failed) > 0 {
+		// The pages after the failed one weren't queued when they are fetched one at a time
+		for next := lastQueued + 1; next <= expectedPages; next++ {
+			failed[next] = fmt.Errorf("Not fetched, the page %d failed", failedPage)
+		}
+		return timestamps, stargazers, failed
+	}
+	return timestamps, stargazers, nil
+}
+
+// pageURLs returns the URLs of the pages from the second one to the last one.
+func pageURLs(last github.Link) ([]string, error) {
+	urls := make([]string, 0, last.Page-1)
+	for page := 2; page <= last.Page; page++ {
+		url, err := last.WithPage(page)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, nil
 }
 
 type sortableTimestamps []int64
@@ -1,13 +1,18 @@
 package creator
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/evermax/stargraph/api"
 	"github.com/evermax/stargraph/github"
 	"github.com/evermax/stargraph/lib/mq"
 	"github.com/evermax/stargraph/lib/store"
@@ -27,24 +32,76 @@ func (info mockRepoInfo) URL() string {
 	return info.url
 }
 
+// waitLen waits for the queue to have n messages ready.
+func waitLen(t *testing.T, q *mq.Memory, name string, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len(name) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d messages on %s, got %d", n, name, q.Len(name))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func TestCreatorWorkNonJSONMessage(t *testing.T) {
-	var db = storedb{}
-	var d = &delvry{body: []byte("Hello, world")}
-	var q = &msgq{delivery: d}
+	var db = store.NewMemory()
+	var q = mq.NewMemory()
+	q.DeclareQueue("add")
+	q.Publish("add", []byte("Hello, world"))
 	var creator = NewCreator(db, q)
-	creator.Run()
+	creator.Queue = "add"
+	creator.RetryBackoff = service.Backoff{Initial: 50 * time.Millisecond, Max: 50 * time.Millisecond}
+	d, _, _ := q.Get("add")
+	creator.receiveMessage(d, nil)
+
+	// Acked and retried later
+	if q.Unacked("add") != 0 || q.Len("add") != 0 {
+		t.Fatalf("The message should wait to be retried, got %d ready and %d unacked", q.Len("add"), q.Unacked("add"))
+	}
+	waitLen(t, q, "add", 1)
+	if d, _, _ := q.Get("add"); d.Attempts() != 2 {
+		t.Fatalf("Expected the second attempt, got %d", d.Attempts())
+	}
+}
 
-	if !d.nack {
-		t.Fatalf("Nack should have been called on the delivery")
+func TestCreatorWorkPoisonMessage(t *testing.T) {
+	q := mq.NewMemory()
+	q.DeclareQueue("add")
+	q.Publish("add", []byte("Hello, world"))
+	creator := NewCreator(store.NewMemory(), q)
+	creator.Queue = "add"
+	creator.MaxAttempts = 3
+	creator.RetryBackoff = service.Backoff{}
+
+	// Retried until the last attempt, then dead-lettered
+	for attempt := 1; attempt <= creator.MaxAttempts; attempt++ {
+		waitLen(t, q, "add", 1)
+		d, _, _ := q.Get("add")
+		if d.Attempts() != attempt {
+			t.Fatalf("Expected the attempt %d, got %d", attempt, d.Attempts())
+		}
+		creator.receiveMessage(d, nil)
+	}
+	dead := mq.DeadLetterQueue("add")
+	if q.Len("add") != 0 || q.Unacked("add") != 0 || q.Len(dead) != 1 {
+		t.Fatalf("Expected the message in the dead-letter queue, got %d there and %d ready", q.Len(dead), q.Len("add"))
+	}
+	letters, err := mq.Inspect(q, "add", 0)
+	if err != nil || len(letters) != 1 || string(letters[0].Body) != "Hello, world" || !strings.Contains(letters[0].Reason, "after 3 attempts") {
+		t.Fatalf("Expected the message with the reason, got %+v (%v)", letters, err)
 	}
 
-	if d.ack {
-		t.Fatal("Ack shouldn't have been called on the delivery")
+	// Replayed from scratch
+	if n, err := mq.Replay(q, "add", 0); err != nil || n != 1 {
+		t.Fatalf("Expected 1 message replayed, got %d (%v)", n, err)
+	}
+	if d, ok, _ := q.Get("add"); !ok || d.Attempts() != 1 || q.Len(dead) != 0 {
+		t.Fatalf("Expected the message back on the queue, got %v", d)
 	}
 }
 
 /*func TestCreatorWorkNonAPIJobMessage(t *testing.T) {
-	var db = storedb{}
+	var db = store.NewMemory()
 	var d = &delvry{body: []byte("{\"test\": \"test\"}")}
 	var q = &msgq{delivery: d}
 	var creator = NewCreator(db, q)
@@ -60,7 +117,7 @@ func TestCreatorWorkNonJSONMessage(t *testing.T) {
 }
 
 func TestCreatorWorkRepoAlreadyExist(t *testing.T) {
-	var db = storedb{}
+	var db = store.NewMemory()
 	var d = &delvry{body: []byte("{\"name\": \"evermax/stargraph\"}")}
 	var q = &msgq{delivery: d}
 	var creator = NewCreator(db, q)
@@ -89,7 +146,8 @@ func TestGetAllTimestamps(t *testing.T) {
 
 	var serverURL string
 	handler := func(w http.ResponseWriter, r *http.Request) {
-		var page int
+		// The first page is requested without the page parameter
+		page := 1
 		pageString := r.FormValue("page")
 		if pageString != "" {
 			var err error
@@ -107,8 +165,9 @@ func TestGetAllTimestamps(t *testing.T) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		linkFormat := github.BuildLinksFormat(serverURL)
-		w.Header().Add("Link", fmt.Sprintf(linkFormat, page, maxPage))
+		if page < maxPage {
+			w.Header().Add("Link", fmt.Sprintf("<%s&page=%d>; rel=\"next\", <%s&page=%d>; rel=\"last\"", serverURL, page+1, serverURL, maxPage))
+		}
 		w.Write(body)
 	}
 
@@ -118,7 +177,7 @@ func TestGetAllTimestamps(t *testing.T) {
 		count: expectedTimestamps,
 		url:   serverURL,
 	}
-	timestamps, err := GetAllTimestamps(dispatch.JobQueue, batch, "token", repoInfo)
+	timestamps, err := GetAllTimestamps(dispatch.JobQueue, batch, github.NewClient("token"), repoInfo)
 
 	if err != nil {
 		dispatch.Stop()
@@ -133,119 +192,648 @@ func TestGetAllTimestamps(t *testing.T) {
 	dispatch.Stop()
 }
 
-type storedb struct {
-	addRepoFail   bool
-	getRepoFail   bool
-	putRepoFail   bool
-	claimWorkFail bool
-	exist         bool
+func TestGetAllTimestampsRetry(t *testing.T) {
+	filePathFormat := "testdata/distributed_stars_%d.json"
+	batch := 5
+
+	dispatch := service.NewDispatcher(4, 4)
+	dispatch.Run()
+	defer dispatch.Stop()
+
+	tests := []struct {
+		// status is the error of the page 3
+		status int
+		calls  int
+	}{
+		// Fatal error, the page shouldn't be retried
+		{http.StatusNotFound, 1},
+		// Always failing, the page should be retried until giving up
+		{http.StatusServiceUnavailable, 4},
+	}
+	for i, test := range tests {
+		var mtx sync.Mutex
+		calls := make(map[int]int)
+		var serverURL string
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			page := 1
+			if p := r.FormValue("page"); p != "" {
+				page, _ = strconv.Atoi(p)
+			}
+			mtx.Lock()
+			calls[page]++
+			call := calls[page]
+			mtx.Unlock()
+			switch {
+			case page == 2 && call <= 2:
+				// Transient errors, the page should be retried
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			case page == 3:
+				w.WriteHeader(test.status)
+				return
+			}
+			filePath := fmt.Sprintf(filePathFormat, page)
+			body, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				t.Errorf("Reading error of %s: %v\n", filePath, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Add("Link", fmt.Sprintf("<%s?page=%d>; rel=\"next\"", serverURL, page+1))
+			w.Write(body)
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+		serverURL = server.URL
+
+		fetcher := Fetcher{
+			JobQueue: dispatch.JobQueue,
+			PerPage:  batch,
+			Backoff:  service.Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond, Retries: 3},
+		}
+		timestamps, err := fetcher.FetchTimestamps(context.Background(), github.NewClient("token"), github.RepoInfo{Count: 16, StarsURL: server.URL})
+		server.Close()
+		pagesErr, ok := err.(PagesError)
+		if !ok {
+			t.Fatalf("Test %d: expected a PagesError, got %v", i, err)
+		}
+		// The page 4 can't be reached without the Link header of the page 3
+		if pages := pagesErr.Pages(); len(pages) != 2 || pages[0] != 3 || pages[1] != 4 {
+			t.Fatalf("Test %d: expected pages 3 and 4 to fail, got %v", i, pages)
+		}
+		if len(timestamps) != 10 {
+			t.Fatalf("Test %d: expected the 10 timestamps of pages 1 and 2, got %d", i, len(timestamps))
+		}
+		mtx.Lock()
+		if calls[2] != 3 {
+			t.Fatalf("Test %d: page 2 should have been requested 3 times, got %d", i, calls[2])
+		}
+		if calls[3] != test.calls {
+			t.Fatalf("Test %d: page 3 should have been requested %d times, got %d", i, test.calls, calls[3])
+		}
+		if calls[4] != 0 {
+			t.Fatalf("Test %d: page 4 shouldn't have been requested, got %d", i, calls[4])
+		}
+		mtx.Unlock()
+	}
+}
+
+func TestGetAllTimestampsConcurrent(t *testing.T) {
+	filePathFormat := "testdata/distributed_stars_%d.json"
+	batch := 5
+	// The stars on each page
+	counts := map[int]int{1: 5, 2: 5, 3: 5, 4: 1}
+
+	dispatch := service.NewDispatcher(4, 4)
+	dispatch.Run()
+	defer dispatch.Stop()
+
+	tests := []struct {
+		// status is the status of the page 3
+		status int
+	}{
+		{http.StatusOK},
+		{http.StatusNotFound},
+	}
+	for i, test := range tests {
+		// The pages after the first one are only answered once they are all requested
+		var mtx sync.Mutex
+		requested := 0
+		all := make(chan struct{})
+		var serverURL string
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			page := 1
+			if p := r.FormValue("page"); p != "" {
+				page, _ = strconv.Atoi(p)
+			}
+			if page > 1 {
+				mtx.Lock()
+				requested++
+				if requested == 3 {
+					close(all)
+				}
+				mtx.Unlock()
+				select {
+				case <-all:
+				case <-time.After(5 * time.Second):
+					t.Errorf("Test %d: the pages weren't requested concurrently", i)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+			}
+			if page == 3 && test.status != http.StatusOK {
+				w.WriteHeader(test.status)
+				return
+			}
+			body, err := ioutil.ReadFile(fmt.Sprintf(filePathFormat, page))
+			if err != nil {
+				t.Errorf("Reading error of page %d: %v\n", page, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if page < 4 {
+				w.Header().Add("Link", fmt.Sprintf("<%s&page=%d>; rel=\"next\", <%s&page=4>; rel=\"last\"", serverURL, page+1, serverURL))
+			}
+			w.Write(body)
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+		serverURL = server.URL + "?per_page=" + strconv.Itoa(batch)
+
+		fetcher := Fetcher{
+			JobQueue: dispatch.JobQueue,
+			PerPage:  batch,
+			Backoff:  service.Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		}
+		timestamps, err := fetcher.FetchTimestamps(context.Background(), github.NewClient("token"), github.RepoInfo{Count: 16, StarsURL: server.URL})
+		server.Close()
+		if test.status == http.StatusOK {
+			if err != nil || len(timestamps) != 16 {
+				t.Fatalf("Test %d: expected the 16 timestamps, got %d (%v)", i, len(timestamps), err)
+			}
+			continue
+		}
+		pagesErr, ok := err.(PagesError)
+		if !ok {
+			t.Fatalf("Test %d: expected a PagesError, got %v", i, err)
+		}
+		if _, ok := pagesErr[3]; !ok {
+			t.Fatalf("Test %d: expected the page 3 to fail, got %v", i, pagesErr)
+		}
+		// The other pages might be cancelled or not, the timestamps are the ones of the pages fetched
+		expected := 16
+		for _, page := range pagesErr.Pages() {
+			expected -= counts[page]
+		}
+		if len(timestamps) != expected {
+			t.Fatalf("Test %d: expected the %d timestamps of the pages fetched, got %d", i, expected, len(timestamps))
+		}
+	}
 }
 
-var ErrNoName error
+func TestGetAllTimestampsCancel(t *testing.T) {
+	// Only one worker, so the other jobs stay queued while the first request hangs
+	dispatch := service.NewDispatcher(1, 4)
+	dispatch.Run()
+	defer dispatch.Stop()
 
-func (db storedb) AddRepo(repo github.RepoInfo) (store.ID, error) {
-	if repo.Name == "evermax/stargraph" {
-		return iD{}, store.ErrAlreadyExist
+	requests := make(chan struct{}, 10)
+	release := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		<-release
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requests
+		cancel()
+	}()
+
+	result := make(chan error)
+	go func() {
+		_, err := GetAllTimestampsContext(ctx, dispatch.JobQueue, 5, github.NewClient("token"), mockRepoInfo{count: 20, url: server.URL})
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err != context.Canceled {
+			t.Fatalf("Expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetAllTimestampsContext didn't return after the context was cancelled")
 	}
-	if repo.Name == "" {
-		return iD{}, ErrNoName
+	if len(requests) != 0 {
+		t.Fatalf("The queued jobs should have been dropped, %d more requests were made", len(requests))
 	}
-	if repo.ID == 0 {
-		return iD{}, ErrNoName
+}
+
+type stargazerFetcher []github.Stargazer
+
+func (f stargazerFetcher) FetchTimestamps(ctx context.Context, client *github.Client, info github.RepoInfo) ([]int64, error) {
+	return github.Timestamps(f)
+}
+
+func (f stargazerFetcher) FetchStargazers(ctx context.Context, client *github.Client, info github.RepoInfo) ([]github.Stargazer, error) {
+	return f, nil
+}
+
+func TestCreatorWorkStargazers(t *testing.T) {
+	fetcher := stargazerFetcher{
+		{Timestamp: "2015-10-31T10:00:00Z", User: github.User{Login: "octocat", ID: 583231, Type: "User"}},
+		{Timestamp: "2015-10-31T11:00:00Z", User: github.User{Login: "dependabot[bot]", ID: 49699333, Type: "Bot"}},
+	}
+	body := []byte(`{"RepoInfo": {"id": 1, "name": "stargraph", "full_name": "evermax/stargraph", "stargazers_count": 2}, "Token": "token"}`)
+
+	tests := []struct {
+		stargazers bool
+		expected   int
+	}{
+		{stargazers: false, expected: 0},
+		{stargazers: true, expected: 2},
 	}
-	if db.addRepoFail {
-		return iD{}, fmt.Errorf("Random Error")
+	for i, test := range tests {
+		db := store.NewMemory()
+		creator := NewCreator(db, mq.NewMemory())
+		creator.Fetcher = fetcher
+		creator.Stargazers = test.stargazers
+		if err := creator.creatorWork(context.Background(), body); err != nil {
+			t.Fatalf("Test %d: an error occured in creatorWork: %v", i, err)
+		}
+		put, id, err := db.GetRepo("stargraph")
+		if err != nil || !put.Exist() {
+			t.Fatalf("Test %d: the repository should have been stored: %v", i, err)
+		}
+		if put.WorkedOn {
+			t.Fatalf("Test %d: the work on the repository should be done", i)
+		}
+		timestamps, err := store.Timestamps(db, id, store.AllFrom, store.AllTo)
+		if err != nil || len(timestamps) != 2 || timestamps[1] != 1446289200 {
+			t.Fatalf("Test %d: expected the 2 timestamps to be stored, got %v (%v)", i, timestamps, err)
+		}
+		stargazers, err := store.AllStargazers(db, id)
+		if err != nil || len(stargazers) != test.expected {
+			t.Fatalf("Test %d: expected %d stargazers to be stored, got %v (%v)", i, test.expected, stargazers, err)
+		}
+		snapshots, err := db.Snapshots(id, store.AllFrom, store.AllTo)
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while reading the snapshots: %v", i, err)
+		}
+		if test.stargazers && (len(snapshots) != 1 || len(snapshots[0].Stars) != 2) {
+			t.Fatalf("Test %d: expected a snapshot of the 2 stargazers, got %+v", i, snapshots)
+		}
+		if !test.stargazers && len(snapshots) != 0 {
+			t.Fatalf("Test %d: expected no snapshot without the stargazers, got %+v", i, snapshots)
+		}
 	}
-	return iD{}, nil
 }
 
-func (db storedb) GetRepo(repo string) (github.RepoInfo, store.ID, error) {
-	if db.getRepoFail {
-		return github.RepoInfo{}, iD{}, fmt.Errorf("Random Error")
+func TestFetcherStargazers(t *testing.T) {
+	dispatch := service.NewDispatcher(2, 2)
+	dispatch.Run()
+	defer dispatch.Stop()
+
+	pages := []string{
+		`[{"starred_at": "2015-10-31T10:00:00Z", "user": {"login": "octocat", "id": 583231, "type": "User"}}]`,
+		`[{"starred_at": "2015-10-31T11:00:00Z", "user": {"login": "dependabot[bot]", "id": 49699333, "type": "Bot"}}]`,
+	}
+	var serverURL string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		if p := r.FormValue("page"); p != "" {
+			page, _ = strconv.Atoi(p)
+		}
+		if page < len(pages) {
+			w.Header().Add("Link", fmt.Sprintf("<%s?page=%d>; rel=\"next\"", serverURL, page+1))
+		}
+		w.Write([]byte(pages[page-1]))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	serverURL = server.URL
+
+	var fetcher github.StargazerFetcher = Fetcher{JobQueue: dispatch.JobQueue, PerPage: 1}
+	stargazers, err := fetcher.FetchStargazers(context.Background(), github.NewClient("token"), github.RepoInfo{Count: 2, StarsURL: server.URL})
+	if err != nil {
+		t.Fatalf("An error occured while fetching the stargazers: %v", err)
+	}
+	if len(stargazers) != 2 || stargazers[0].User.Login != "octocat" || stargazers[1].User.Login != "dependabot[bot]" {
+		t.Fatalf("Expected octocat then dependabot[bot] to have starred, got %v", stargazers)
 	}
-	repoInfo := github.RepoInfo{}
-	(&repoInfo).SetExist(db.exist)
-	return repoInfo, iD{}, nil
 }
 
-func (db storedb) PutRepo(repo github.RepoInfo, id store.ID) error {
-	if db.putRepoFail {
-		return fmt.Errorf("Random Error")
+// timestampsFetcher only gets the timestamps of the stars.
+type timestampsFetcher []int64
+
+func (f timestampsFetcher) FetchTimestamps(ctx context.Context, client *github.Client, info github.RepoInfo) ([]int64, error) {
+	return f, nil
+}
+
+func TestCrawlStargazersUnsupported(t *testing.T) {
+	db := store.NewMemory()
+	creator := NewCreator(db, mq.NewMemory())
+	creator.Fetcher = timestampsFetcher{1446285600}
+	creator.Stargazers = true
+	repoInfo := github.RepoInfo{ID: 1, Name: "stargraph", Count: 1}
+	id, err := db.AddRepo(repoInfo)
+	if err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+
+	if err := creator.Crawl(context.Background(), creator.Github, id, repoInfo); err != ErrStargazersUnsupported {
+		t.Fatalf("Expected %v, got %v", ErrStargazersUnsupported, err)
+	}
+	// The repository is left as it was for a crawl keeping the stargazers
+	put, err := db.GetRepoByID(id)
+	if err != nil {
+		t.Fatalf("An error occured while getting the repository: %v", err)
+	}
+	if put.WorkedOn || put.LastUpdate != "" {
+		t.Fatalf("The repository shouldn't have been worked on, got %+v", put)
 	}
-	return nil
 }
 
-func (db storedb) ClaimWork(repo github.RepoInfo, id store.ID) error {
-	if db.claimWorkFail {
-		return fmt.Errorf("Random Error")
+func TestCrawlNoJobQueue(t *testing.T) {
+	db := store.NewMemory()
+	creator := NewCreator(db, mq.NewMemory())
+	repoInfo := github.RepoInfo{ID: 1, Name: "stargraph", Count: 1}
+	id, err := db.AddRepo(repoInfo)
+	if err != nil {
+		t.Fatalf("An error occured while adding the repository: %v", err)
+	}
+
+	// Without workers to request the pages, the crawl fails instead of waiting for ever
+	if err := creator.Crawl(context.Background(), creator.Github, id, repoInfo); err != ErrNoJobQueue {
+		t.Fatalf("Expected %v, got %v", ErrNoJobQueue, err)
+	}
+	if put, err := db.GetRepoByID(id); err != nil || put.WorkedOn {
+		t.Fatalf("The work on the repository should have been released, got %+v (%v)", put, err)
 	}
-	return nil
 }
 
-type iD struct{}
+// blockingFetcher blocks until the crawl is cancelled.
+type blockingFetcher struct{}
+
+func (f blockingFetcher) FetchTimestamps(ctx context.Context, client *github.Client, info github.RepoInfo) ([]int64, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// lostLease is a store on which the leases can't be renewed.
+type lostLease struct {
+	*store.Memory
+}
 
-func (id iD) Test() string {
-	return ""
+func (db lostLease) RenewLease(id store.ID, owner string, ttl time.Duration) (store.Lease, error) {
+	return store.Lease{}, store.ErrLeaseLost
 }
 
-type msgq struct {
-	addQueue           string
-	updateQueue        string
-	addJobTriggered    int
-	updateJobTriggered int
-	token              string
-	delivery           *delvry
+func TestCreatorWorkLease(t *testing.T) {
+	fetcher := stargazerFetcher{{Timestamp: "2015-10-31T10:00:00Z"}}
+	body := []byte(`{"RepoInfo": {"id": 1, "name": "stargraph", "full_name": "evermax/stargraph", "stargazers_count": 1}, "Token": "token"}`)
+
+	tests := []struct {
+		ttl      time.Duration
+		expected error
+	}{
+		// The creator that claimed the work crashed, its lease expired
+		{ttl: time.Millisecond, expected: nil},
+		// Another creator is still working on it
+		{ttl: time.Minute, expected: store.ErrAlreadyWorkedOn},
+	}
+	for i, test := range tests {
+		db := store.NewMemory()
+		id, err := db.AddRepo(github.RepoInfo{ID: 1, Name: "stargraph"})
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while adding the repository: %v", i, err)
+		}
+		if _, err := db.ClaimWork(github.RepoInfo{}, id, "crashed", test.ttl); err != nil {
+			t.Fatalf("Test %d: an error occured while claiming the work: %v", i, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		creator := NewCreator(db, mq.NewMemory())
+		creator.Fetcher = fetcher
+		if err := creator.creatorWork(context.Background(), body); err != test.expected {
+			t.Fatalf("Test %d: expected %v, got %v", i, test.expected, err)
+		}
+		put, _, _ := db.GetRepo("stargraph")
+		if test.expected == nil && (put.WorkedOn || put.LeaseOwner != "" || put.LastUpdate == "") {
+			t.Fatalf("Test %d: the work should be done and the lease given back, got %+v", i, put)
+		}
+		count, _ := store.CountTimestamps(db, id, store.AllFrom, store.AllTo)
+		if test.expected == nil && count != 1 {
+			t.Fatalf("Test %d: expected the timestamp to be stored, got %d", i, count)
+		}
+		if test.expected != nil && put.LeaseOwner != "crashed" {
+			t.Fatalf("Test %d: the lease shouldn't have been taken over, got %+v", i, put)
+		}
+	}
 }
 
-func (q *msgq) DeclareQueue(name string) error {
-	if name == "" {
-		return fmt.Errorf("Name is null")
+func TestCreatorWorkAlreadyWorkedOnAck(t *testing.T) {
+	db := store.NewMemory()
+	id, _ := db.AddRepo(github.RepoInfo{ID: 1, Name: "stargraph"})
+	db.ClaimWork(github.RepoInfo{}, id, "other", time.Minute)
+
+	q := mq.NewMemory()
+	q.DeclareQueue("add")
+	q.Publish("add", []byte(`{"RepoInfo": {"id": 1, "name": "stargraph", "stargazers_count": 1}}`))
+	creator := NewCreator(db, q)
+	creator.Queue = "add"
+	creator.Fetcher = stargazerFetcher{}
+	d, _, _ := q.Get("add")
+	creator.receiveMessage(d, nil)
+
+	if q.Unacked("add") != 0 || q.Len("add") != 0 {
+		t.Fatal("The delivery should be acked when somebody else works on the repository")
 	}
-	if name == "add" {
-		q.addQueue = name
+}
+
+func TestCreatorWorkLeaseLost(t *testing.T) {
+	db := lostLease{store.NewMemory()}
+	body := []byte(`{"RepoInfo": {"id": 1, "name": "stargraph", "stargazers_count": 1}}`)
+
+	creator := NewCreator(db, mq.NewMemory())
+	creator.Fetcher = blockingFetcher{}
+	creator.LeaseTTL = 30 * time.Millisecond
+
+	// The crawl is cancelled as soon as the lease can't be renewed
+	errc := make(chan error, 1)
+	go func() { errc <- creator.creatorWork(context.Background(), body) }()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("The work should fail once the lease is lost")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The crawl wasn't cancelled when the lease was lost")
 	}
-	if name == "update" {
-		q.updateQueue = name
+	put, _, _ := db.GetRepo("stargraph")
+	if put.LastUpdate != "" || !put.WorkedOn {
+		t.Fatalf("The repository shouldn't be put nor released once the lease is lost, got %+v", put)
 	}
-	return nil
 }
 
-func (q *msgq) Publish(name string, body []byte) error {
-	if q.addQueue != name && q.updateQueue != name {
-		// TODO check if indeed an non existing queue would indeed trigger error
-		return fmt.Errorf("No such Q %s", name)
+func TestCreatorWorkLeaseLostBeforeWrite(t *testing.T) {
+	db := lostLease{store.NewMemory()}
+	body := []byte(`{"RepoInfo": {"id": 1, "name": "stargraph", "stargazers_count": 1}}`)
+
+	// The crawl ends before the first renewal, the lease is checked again before the writes
+	creator := NewCreator(db, mq.NewMemory())
+	creator.Fetcher = stargazerFetcher{{Timestamp: "2015-10-31T10:00:00Z"}}
+	if err := creator.creatorWork(context.Background(), body); err == nil {
+		t.Fatal("The work should fail once the lease is lost")
 	}
-	if name == "add" {
-		q.addJobTriggered++
+	put, id, _ := db.GetRepo("stargraph")
+	if put.LastUpdate != "" || !put.WorkedOn {
+		t.Fatalf("The repository shouldn't be put nor released once the lease is lost, got %+v", put)
 	}
-	if name == "update" {
-		q.updateJobTriggered++
+	if count, _ := store.CountTimestamps(db, id, store.AllFrom, store.AllTo); count != 0 {
+		t.Fatalf("The series shouldn't be written once the lease is lost, got %d timestamps", count)
 	}
-	return nil
 }
 
-func (q *msgq) Consume(name string, r mq.Receiver) error {
-	var forever chan bool
-	r(q.delivery, forever)
+// racingStore is a store on which another writer modifies the repository
+// right before the first put of the creator.
+type racingStore struct {
+	*store.Memory
+	write func(repoInfo *github.RepoInfo)
+	puts  int
+}
 
-	return nil
+func (db *racingStore) PutRepo(repoInfo github.RepoInfo, id store.ID) error {
+	db.puts++
+	if db.puts == 1 {
+		current, _ := db.Memory.GetRepoByID(id)
+		db.write(&current)
+		if err := db.Memory.PutRepo(current, id); err != nil {
+			return err
+		}
+	}
+	return db.Memory.PutRepo(repoInfo, id)
 }
 
-type delvry struct {
-	body []byte
-	ack  bool
-	nack bool
+func TestCreatorWorkPutConflict(t *testing.T) {
+	body := []byte(`{"RepoInfo": {"id": 1, "name": "stargraph", "stargazers_count": 1}}`)
+
+	tests := []struct {
+		write    func(repoInfo *github.RepoInfo)
+		puts     int
+		expected bool
+		count    int
+		owner    string
+	}{
+		// The lease was renewed, the repository is put at the new version
+		{write: func(repoInfo *github.RepoInfo) { repoInfo.LeaseExpiry = repoInfo.LeaseExpiry.Add(time.Minute) }, puts: 2, expected: true, count: 1},
+		// Another writer changed the repository, it isn't overwritten and the work is given back
+		{write: func(repoInfo *github.RepoInfo) { repoInfo.Count = 42 }, puts: 1, expected: false, count: 42},
+		// Somebody else took the work over, it isn't put again
+		{write: func(repoInfo *github.RepoInfo) { repoInfo.LeaseOwner = "other" }, puts: 1, expected: false, count: 1, owner: "other"},
+	}
+	for i, test := range tests {
+		db := &racingStore{Memory: store.NewMemory(), write: test.write}
+		creator := NewCreator(db, mq.NewMemory())
+		creator.Fetcher = stargazerFetcher{{Timestamp: "2015-10-31T10:00:00Z"}}
+		err := creator.creatorWork(context.Background(), body)
+		if (err == nil) != test.expected {
+			t.Fatalf("Test %d: expected the put to succeed: %v, got %v", i, test.expected, err)
+		}
+		if db.puts != test.puts {
+			t.Fatalf("Test %d: expected %d puts, got %d", i, test.puts, db.puts)
+		}
+		put, _, _ := db.GetRepo("stargraph")
+		if put.Count != test.count || put.LeaseOwner != test.owner {
+			t.Fatalf("Test %d: expected a count of %d and the lease owner %q, got %+v", i, test.count, test.owner, put)
+		}
+		if test.expected && (put.LastUpdate == "" || put.WorkedOn) {
+			t.Fatalf("Test %d: expected the repository of the creator to be put, got %+v", i, put)
+		}
+		if !test.expected && put.LastUpdate != "" {
+			t.Fatalf("Test %d: the repository of the other writer shouldn't be overwritten, got %+v", i, put)
+		}
+	}
 }
 
-func (d *delvry) Body() []byte {
-	return d.body
+// noLookup is a store on which the repositories can't be looked up by name.
+type noLookup struct {
+	*store.Memory
 }
 
-func (d *delvry) Ack(multiple bool) error {
-	d.ack = true
-	return nil
+func (db noLookup) GetRepo(repo string) (github.RepoInfo, store.ID, error) {
+	return github.RepoInfo{}, nil, fmt.Errorf("The repository %s shouldn't be looked up by name", repo)
 }
-func (d *delvry) Nack(multiple, requeue bool) error {
-	d.nack = true
-	return nil
+
+func TestCreatorWorkJobID(t *testing.T) {
+	tests := []struct {
+		lastUpdate string
+		id         func(id store.ID) string
+		expected   error
+	}{
+		// A repository added by a creator that crashed
+		{lastUpdate: "", id: store.ID.String, expected: nil},
+		{lastUpdate: "2015-11-01T10:00:00Z", id: store.ID.String, expected: store.ErrAlreadyExist},
+		{lastUpdate: "", id: func(id store.ID) string { return "stargraph" }, expected: store.ErrInvalidID},
+	}
+	for i, test := range tests {
+		db := noLookup{store.NewMemory()}
+		id, err := db.AddRepo(github.RepoInfo{ID: 1, Name: "stargraph", LastUpdate: test.lastUpdate})
+		if err != nil {
+			t.Fatalf("Test %d: an error occured while adding the repository: %v", i, err)
+		}
+		body, _ := api.Job{RepoInfo: github.RepoInfo{ID: 1, Name: "stargraph", Count: 1}, ID: test.id(id)}.Marshal()
+
+		creator := NewCreator(db, mq.NewMemory())
+		creator.Fetcher = stargazerFetcher{{Timestamp: "2015-10-31T10:00:00Z"}}
+		if err := creator.creatorWork(context.Background(), body); err != test.expected {
+			t.Fatalf("Test %d: expected %v, got %v", i, test.expected, err)
+		}
+		put, _ := db.GetRepoByID(id)
+		if test.expected == nil && (put.LastUpdate == "" || put.WorkedOn) {
+			t.Fatalf("Test %d: expected the repository to be put, got %+v", i, put)
+		}
+	}
+}
+
+// TestAPIToCreator runs the API and the creator in one process, the jobs going through a mq.Memory.
+func TestAPIToCreator(t *testing.T) {
+	githubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 1, "name": "stargraph", "full_name": "evermax/stargraph", "stargazers_count": 2}`)
+	}))
+	defer githubServer.Close()
+	client := github.NewClient("")
+	client.SetBaseURL(githubServer.URL)
+
+	db := store.NewMemory()
+	q := mq.NewMemory()
+	conf, err := api.NewConf(db, q, "add", "update")
+	if err != nil {
+		t.Fatalf("An error occured while creating the conf: %v", err)
+	}
+	conf.Github = client
+	server := httptest.NewServer(http.HandlerFunc(conf.ApiHandler))
+	defer server.Close()
+
+	creator := NewCreator(db, q)
+	creator.Github = client
+	creator.Queue = conf.AddQueue
+	creator.Fetcher = stargazerFetcher{{Timestamp: "2015-10-31T10:00:00Z"}, {Timestamp: "2015-10-31T11:00:00Z"}}
+	done := make(chan error)
+	go func() { done <- creator.Run() }()
+
+	req, _ := http.NewRequest("GET", server.URL+"?repo=evermax/stargraph", nil)
+	req.Header.Add(api.AuthorizationHeader, "Bearer test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("An error occured while doing the request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %d, expected %d", resp.StatusCode, http.StatusOK)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		repoInfo, _, _ := db.GetRepo("stargraph")
+		if repoInfo.LastUpdate != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The creator didn't store the repository")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("An error occured while consuming the jobs: %v", err)
+	}
+	if q.Unacked("add") != 0 {
+		t.Fatalf("The job should have been acked, %d unacked", q.Unacked("add"))
+	}
+	_, id, _ := db.GetRepo("stargraph")
+	if count, _ := store.CountTimestamps(db, id, store.AllFrom, store.AllTo); count != 2 {
+		t.Fatalf("Expected the 2 timestamps to be stored, got %d", count)
+	}
 }
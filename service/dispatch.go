@@ -9,7 +9,9 @@ const (
 )
 
 // Dispatcher structure has a pool of workers tand will dispatch incoming
-// jobs from the JobQueue to one of the workers via the WorkerPool channel
+// jobs from the JobQueue to one of the workers via the WorkerPool channel.
+// A job whose context is done before a worker is available is dropped,
+// and its error sent on its ErrorChannel.
 type Dispatcher struct {
 	// A pool of workers channels that are registered with the dispatcher
 	WorkerPool chan chan Job
@@ -84,11 +86,21 @@ func (d *Dispatcher) dispatch() {
 			// Send the job to the queue
 			go func(job Job) {
 				// try to obtain a worker job channel that is available.
-				// this will block until a worker is idle
-				jobChannel := <-d.WorkerPool
-
-				// dispatch the job to the worker job channel
-				jobChannel <- job
+				// this will block until a worker is idle,
+				// unless the job is cancelled in the meantime.
+				select {
+				case jobChannel := <-d.WorkerPool:
+					if err := job.ctx().Err(); err != nil {
+						// Give the worker back and drop the job
+						d.WorkerPool <- jobChannel
+						job.ErrorChannel <- &JobError{Num: job.Num, Err: err}
+						return
+					}
+					// dispatch the job to the worker job channel
+					jobChannel <- job
+				case <-job.ctx().Done():
+					job.ErrorChannel <- &JobError{Num: job.Num, Err: job.ctx().Err()}
+				}
 			}(job)
 		}
 	}
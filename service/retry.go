@@ -0,0 +1,38 @@
+package service
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes the delay before retrying a failed job.
+// The delay grows exponentially with the attempts, up to Max, and
+// is randomized (jitter) so the failed jobs are not all retried at once.
+// Retries is the maximum number of retries of a job.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Retries int
+}
+
+// DefaultBackoff retries a job 5 times, waiting between 1 and 30 seconds.
+var DefaultBackoff = Backoff{
+	Initial: time.Second,
+	Max:     30 * time.Second,
+	Retries: 5,
+}
+
+// Delay returns the time to wait before the retry number attempt (starting at 0).
+// It is between half and the whole of Initial * 2^attempt, capped to Max.
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.Max
+	if attempt < 32 {
+		if d := b.Initial << uint(attempt); d > 0 && d < b.Max {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
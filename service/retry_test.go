@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Retries: 5}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond, 800 * time.Millisecond},
+		{4, 500 * time.Millisecond, time.Second},
+		{100, 500 * time.Millisecond, time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			delay := b.Delay(test.attempt)
+			if delay < test.min || delay > test.max {
+				t.Fatalf("Attempt %d: the delay %v should be between %v and %v", test.attempt, delay, test.min, test.max)
+			}
+		}
+	}
+}
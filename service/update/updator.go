@@ -1,13 +1,39 @@
+// Package update contains the updator service that crawls again the repositories of the database
+// once their data is old, so their graphs follow the new stars and the snapshots of the stargazers
+// tell who unstarred them.
 package update
 
 import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/evermax/stargraph/github"
 	"github.com/evermax/stargraph/lib/mq"
 	"github.com/evermax/stargraph/lib/store"
 	"github.com/evermax/stargraph/service"
+	"github.com/evermax/stargraph/service/creator"
 )
 
-// Updator ... TODO
+// DefaultInterval is the Interval of the updators created with NewUpdator.
+const DefaultInterval = 24 * time.Hour
+
+// checksPerInterval is how many times per Interval the old repositories are looked for,
+// a repository is crawled again at most a fraction of the Interval late.
+const checksPerInterval = 4
+
+// Updator crawls again the repositories not updated for an Interval.
+// It implements the service.SWorker interface.
 type Updator struct {
+	// Creator crawls the repositories, with its Github client, its Fetcher and its leases,
+	// so an updator and the creators never work on the same repository at the same time.
+	// Its Stargazers must be set, and its Fetcher be a github.StargazerFetcher,
+	// for each crawl to take a snapshot of the stargazers. Its Github client should have
+	// a token or a token pool, the crawls of an anonymous client are quickly rate limited.
+	Creator creator.Creator
+	// Interval is the age of the data of the repositories crawled again.
+	Interval time.Duration
+
 	t         string
 	db        store.Store
 	messageQ  mq.MessageQueue
@@ -15,23 +41,79 @@ type Updator struct {
 	queueName string
 }
 
-// NewUpdator will create a new updator
-func NewUpdator(db store.Store, queue mq.MessageQueue) Updator {
+// NewUpdator will create a new updator, crawling the repositories every DefaultInterval
+// with the Github client and keeping the stargazers. The pages of stars are requested
+// by the workers listening to the jobQueue, the JobQueue of a service.Dispatcher.
+func NewUpdator(db store.Store, queue mq.MessageQueue, client *github.Client, jobQueue chan service.Job) Updator {
+	c := creator.NewCreator(db, queue)
+	c.Github = client
+	c.Fetcher = creator.Fetcher{JobQueue: jobQueue, PerPage: 100}
+	c.Stargazers = true
 	return Updator{
+		Creator:  c,
+		Interval: DefaultInterval,
 		t:        service.UpdatorName,
 		db:       db,
 		messageQ: queue,
+		jobQueue: jobQueue,
 	}
 }
 
-// Run create a connection to the AMQP server and listen to incoming requests
-// To update Github repository graphs.
+// Run looks for the repositories to crawl again checksPerInterval times per Interval, for ever.
 func (u Updator) Run() {
-	// Get all the timestamps from the database
-	// Get the number of pages and the repo info from Github
-	// Compare and go back until it is alright
-	// Update the DB.
-	// Set it to not worked on anymore
+	ticker := time.NewTicker(u.interval() / checksPerInterval)
+	defer ticker.Stop()
+	for {
+		updated, err := u.UpdateOld(context.Background())
+		if err != nil {
+			log.Printf("ERROR: Couldn't update the repositories: %v", err)
+		} else if updated > 0 {
+			log.Printf("Updated %d repositories", updated)
+		}
+		<-ticker.C
+	}
+}
+
+// UpdateOld crawls again the repositories not updated for an Interval, or never updated
+// because the creator crashed, and returns how many were updated. The repositories worked on
+// by another service are skipped, a failed crawl is logged and the next repositories are crawled.
+// It only returns an error if the repositories couldn't be listed.
+func (u Updator) UpdateOld(ctx context.Context) (int, error) {
+	notWorkedOn := false
+	filter := store.RepoFilter{UpdatedBefore: time.Now().Add(-u.interval()), WorkedOn: &notWorkedOn}
+	updated := 0
+	cursor := ""
+	for {
+		repos, next, err := u.db.ListRepos(filter, cursor, 0)
+		if err != nil {
+			return updated, err
+		}
+		for _, repo := range repos {
+			if ctx.Err() != nil {
+				return updated, ctx.Err()
+			}
+			err := u.Creator.Crawl(ctx, u.Creator.Github, repo.ID, repo.Info)
+			if err == store.ErrAlreadyWorkedOn {
+				continue
+			}
+			if err != nil {
+				log.Printf("WARN: Couldn't update %s: %v", repo.Info.Name, err)
+				continue
+			}
+			updated++
+		}
+		if next == "" {
+			return updated, nil
+		}
+		cursor = next
+	}
+}
+
+func (u Updator) interval() time.Duration {
+	if u.Interval <= 0 {
+		return DefaultInterval
+	}
+	return u.Interval
 }
 
 // JobQueue ... TODO
@@ -0,0 +1,99 @@
+package update
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/evermax/stargraph/github"
+	"github.com/evermax/stargraph/lib/mq"
+	"github.com/evermax/stargraph/lib/store"
+	"github.com/evermax/stargraph/service"
+)
+
+func TestUpdateOld(t *testing.T) {
+	dispatch := service.NewDispatcher(2, 2)
+	dispatch.Run()
+	defer dispatch.Stop()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Authorization") == "" {
+			t.Errorf("The request to %s should be authenticated", r.URL)
+		}
+		w.Write([]byte(`[
+			{"starred_at": "2015-10-31T10:00:00Z", "user": {"login": "octocat", "id": 583231}},
+			{"starred_at": "2015-10-31T11:00:00Z", "user": {"login": "other", "id": 42}}
+		]`))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	db := store.NewMemory()
+	repos := []struct {
+		repoInfo github.RepoInfo
+		updated  bool
+	}{
+		{repoInfo: github.RepoInfo{ID: 1, Name: "evermax/old", LastUpdate: now.Add(-48 * time.Hour).Format(time.RFC3339)}, updated: true},
+		{repoInfo: github.RepoInfo{ID: 2, Name: "evermax/fresh", LastUpdate: now.Add(-time.Hour).Format(time.RFC3339)}, updated: false},
+		// The creator crashed before the end of the crawl
+		{repoInfo: github.RepoInfo{ID: 3, Name: "evermax/crashed"}, updated: true},
+		{repoInfo: github.RepoInfo{ID: 4, Name: "evermax/worked"}, updated: false},
+	}
+	for _, repo := range repos {
+		repo.repoInfo.Count, repo.repoInfo.StarsURL = 2, server.URL
+		if _, err := db.AddRepo(repo.repoInfo); err != nil {
+			t.Fatalf("An error occured while adding %s: %v", repo.repoInfo.Name, err)
+		}
+	}
+	_, worked, _ := db.GetRepo("evermax/worked")
+	if _, err := db.ClaimWork(github.RepoInfo{}, worked, "creator", time.Minute); err != nil {
+		t.Fatalf("An error occured while claiming the work: %v", err)
+	}
+
+	updator := NewUpdator(db, mq.NewMemory(), github.NewClient("token"), dispatch.JobQueue)
+	updated, err := updator.UpdateOld(context.Background())
+	if err != nil {
+		t.Fatalf("An error occured while updating: %v", err)
+	}
+	if updated != 2 || atomic.LoadInt32(&requests) != 2 {
+		t.Fatalf("Expected 2 repositories to be updated with a request each, got %d updated and %d requests", updated, requests)
+	}
+	for _, repo := range repos {
+		repoInfo, id, err := db.GetRepo(repo.repoInfo.Name)
+		if err != nil {
+			t.Fatalf("An error occured while getting %s: %v", repo.repoInfo.Name, err)
+		}
+		snapshots, err := db.Snapshots(id, store.AllFrom, store.AllTo)
+		if err != nil {
+			t.Fatalf("An error occured while reading the snapshots of %s: %v", repo.repoInfo.Name, err)
+		}
+		timestamps, err := store.Timestamps(db, id, store.AllFrom, store.AllTo)
+		if err != nil {
+			t.Fatalf("An error occured while reading the timestamps of %s: %v", repo.repoInfo.Name, err)
+		}
+		if !repo.updated {
+			if len(snapshots) != 0 || len(timestamps) != 0 || repoInfo.LastUpdate != repo.repoInfo.LastUpdate {
+				t.Fatalf("%s shouldn't have been updated, got %+v", repo.repoInfo.Name, repoInfo)
+			}
+			continue
+		}
+		if repoInfo.LastUpdate == repo.repoInfo.LastUpdate || repoInfo.WorkedOn {
+			t.Fatalf("%s should have been updated and given back, got %+v", repo.repoInfo.Name, repoInfo)
+		}
+		if len(timestamps) != 2 {
+			t.Fatalf("Expected the 2 timestamps of %s, got %v", repo.repoInfo.Name, timestamps)
+		}
+		if len(snapshots) != 1 || len(snapshots[0].Stars) != 2 {
+			t.Fatalf("Expected a snapshot of the 2 stargazers of %s, got %+v", repo.repoInfo.Name, snapshots)
+		}
+	}
+
+	// Nothing is old anymore
+	if updated, err := updator.UpdateOld(context.Background()); err != nil || updated != 0 {
+		t.Fatalf("Expected nothing to update, got %d (%v)", updated, err)
+	}
+}
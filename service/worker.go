@@ -1,9 +1,10 @@
 package service
 
 import (
+	"context"
 	"fmt"
-	"strconv"
-	"strings"
+	"log"
+	"time"
 
 	"github.com/evermax/stargraph/github"
 )
@@ -12,14 +13,49 @@ import (
 // So it is easier to pass/expect it.
 type WorkerPool chan chan Job
 
+// JobError is sent on the ErrorChannel of a Job when it failed,
+// Num is the number of the page of the failed job.
+type JobError struct {
+	Num int
+	Err error
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("Page %d: %v", e.Num, e.Err)
+}
+
 // Job structure gets passed through the Job chan
-// to tell one of the go routine of a worker what to do
+// to tell one of the go routine of a worker what to do.
+// ApiURL is the URL of the page number Num, the first page or the next
+// one given by the Link header of the previous page.
+// The Page is sent on the PageChannel, or if the job fails, a *JobError on the ErrorChannel.
+// When the Context is done, the job is dropped or its request cancelled.
 type Job struct {
-	Num               int
-	ApiURL            string
-	ApiToken          string
-	ErrorChannel      chan error
-	TimestampsChannel chan []int64
+	Num          int
+	ApiURL       string
+	Client       *github.Client
+	Context      context.Context
+	ErrorChannel chan error
+	PageChannel  chan Page
+}
+
+// Page is the result of a Job: the timestamps of the stars of the page number Num,
+// who starred in the same order, and the links to the next and the last pages,
+// with an empty URL on the last page.
+type Page struct {
+	Num        int
+	Timestamps []int64
+	Stargazers []github.Stargazer
+	Next       github.Link
+	Last       github.Link
+}
+
+// ctx returns the context of the job, context.Background() if it has none.
+func (job Job) ctx() context.Context {
+	if job.Context == nil {
+		return context.Background()
+	}
+	return job.Context
 }
 
 // Worker is the structure that will be passed to the worker pool
@@ -55,11 +91,24 @@ func (w Worker) Start() {
 			w.WorkerPool <- w.JobChannel
 			select {
 			case job := <-w.JobChannel:
-				timestamps, err := job.work()
+				page, err := job.work()
+				// Pause the worker until the rate limit is reset instead of failing the page
+				for rateErr, ok := err.(*github.RateLimitError); ok; rateErr, ok = err.(*github.RateLimitError) {
+					log.Printf("Worker %d: %v", w.workerNumber, rateErr)
+					select {
+					case <-time.After(rateErr.Wait()):
+						page, err = job.work()
+					case <-job.ctx().Done():
+						err = job.ctx().Err()
+					case <-w.quit:
+						job.ErrorChannel <- &JobError{Num: job.Num, Err: err}
+						return
+					}
+				}
 				if err != nil {
-					job.ErrorChannel <- err
+					job.ErrorChannel <- &JobError{Num: job.Num, Err: err}
 				} else {
-					job.TimestampsChannel <- timestamps
+					job.PageChannel <- page
 				}
 			case <-w.quit:
 				return
@@ -76,27 +125,29 @@ func (w Worker) Stop() {
 	}()
 }
 
-func (job Job) work() ([]int64, error) {
-	getParam := "?page="
-	if strings.Contains(job.ApiURL, "?") {
-		getParam = "&page="
-	}
-	pageURL := job.ApiURL + getParam + strconv.Itoa(int(job.Num))
-
-	stargazers, _, err := github.GetStargazers(pageURL, job.ApiToken)
+// work gets the page and parses its Link header to find the next one.
+func (job Job) work() (Page, error) {
+	page := Page{Num: job.Num}
+	stargazers, linkHeader, err := job.Client.GetStargazersContext(job.ctx(), job.ApiURL)
 	if err != nil {
-		return make([]int64, 0), err
+		return page, err
 	}
 
-	var timestamps []int64
 	for _, star := range stargazers {
 		timestamp, err := star.GetTimestamp()
 		if err != nil {
-			return make([]int64, 0), fmt.Errorf("An error occured while parsing the timestamp: %v", err)
+			return page, fmt.Errorf("An error occured while parsing the timestamp: %v", err)
 		}
 
-		timestamps = append(timestamps, timestamp)
+		page.Timestamps = append(page.Timestamps, timestamp)
 	}
+	page.Stargazers = stargazers
 
-	return timestamps, nil
+	links, err := github.ParseLinks(linkHeader)
+	if err != nil {
+		return page, fmt.Errorf("An error occured while parsing the header: %v, link header is %s", err, linkHeader)
+	}
+	page.Next, _ = links.Next()
+	page.Last, _ = links.Last()
+	return page, nil
 }